@@ -0,0 +1,129 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runAudit lists the audit log or verifies its hash chain
+func runAudit(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	actor := fs.String("actor", "", "list: only entries by this actor")
+	action := fs.String("action", "", "list: only this action, or all actions under a prefix such as settings")
+	target := fs.String("target", "", "list: only targets containing this text")
+	from := fs.String("from", "", "list: only entries from this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "list: only entries before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 50, "list: newest entries to show")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot audit [flags] list|verify")
+		fmt.Fprintln(os.Stderr, "\nverify checks that no entry was changed, removed or reordered and prints the")
+		fmt.Fprintln(os.Stderr, "hash of the newest entry; keep it elsewhere to also detect removed newest entries.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one of list or verify")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", *format)
+	}
+
+	filter := db.AuditFilter{Actor: *actor, Action: *action, Target: *target, Limit: *limit}
+	var err error
+	if filter.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	if filter.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	switch fs.Arg(0) {
+	case "list":
+		entries, err := store.ListAudit(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			return writeJSON(entries)
+		}
+		return printAudit(entries)
+
+	case "verify":
+		result, err := store.VerifyAudit(ctx)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			if err := writeJSON(result); err != nil {
+				return err
+			}
+		} else if result.OK {
+			fmt.Printf("The audit log is intact: %d entries, newest hash %s\n", result.Entries, result.Head)
+		}
+		if !result.OK {
+			return fmt.Errorf("the audit log was tampered with at entry #%d: %s", result.BrokenAt, result.Problem)
+		}
+		return nil
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown audit action %q", fs.Arg(0))
+	}
+}
+
+// printAudit writes audit entries as a table, newest first
+func printAudit(entries []db.AuditEntry) error {
+	if len(entries) == 0 {
+		fmt.Println("No matching audit entries")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tACTOR\tACTION\tTARGET\tBEFORE\tAFTER")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.OccurredAt.Local().Format("2006-01-02 15:04:05"),
+			e.Actor, e.Action, e.Target, clip(string(e.Before), 40), clip(string(e.After), 40))
+	}
+	return tw.Flush()
+}
+
+// writeJSON writes v as indented JSON to stdout
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// audit records a change made by the operator running the command
+func audit(ctx context.Context, store *db.SQLStore, action, target string, before, after interface{}) error {
+	_, err := store.AppendAudit(ctx, db.AuditEvent{
+		Actor:  currentUser(),
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+	})
+	if err != nil {
+		return fmt.Errorf("recording %s in the audit log: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang-llm-sqlite-bot/core/backup"
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runBackup copies the live database to a timestamped file, or lists the backups
+func runBackup(cfg *config.Config, args []string) error {
+	opts := backup.OptionsFromConfig(cfg)
+
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.StringVar(&opts.Dir, "dir", opts.Dir, "directory holding the backups")
+	fs.BoolVar(&opts.Gzip, "gzip", opts.Gzip, "compress the backup")
+	fs.IntVar(&opts.Keep, "keep", opts.Keep, "newest backups to keep, 0 keeps all")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot backup [flags] [list]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if cfg.DBDriver != db.DriverSQLite {
+		return db.ErrBackupUnsupported
+	}
+
+	switch fs.Arg(0) {
+	case "":
+	case "list":
+		return printBackups(opts)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown backup action %q", fs.Arg(0))
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	result, err := backup.Create(ctx, store, opts)
+	if result != nil {
+		fmt.Printf("Backed up %s to %s (%d bytes in %v)\n",
+			cfg.DBPath, result.Path, result.Size, result.Duration.Round(time.Millisecond))
+		for _, path := range result.Removed {
+			fmt.Printf("Removed old backup %s\n", path)
+		}
+		if _, auditErr := store.AppendAudit(context.Background(), result.AuditEvent(currentUser())); auditErr != nil {
+			return errors.Join(err, fmt.Errorf("recording the backup in the audit log: %w", auditErr))
+		}
+	}
+	return err
+}
+
+func printBackups(opts backup.Options) error {
+	backups, err := backup.List(opts)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups in %s\n", opts.Dir)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSIZE\tMODIFIED")
+	for _, path := range backups {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", path, info.Size(), info.ModTime().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// runRestore replaces the database with a backup
+func runRestore(cfg *config.Config, args []string) error {
+	opts := backup.OptionsFromConfig(cfg)
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVar(&opts.Dir, "dir", opts.Dir, "directory holding the backups, used with latest")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot restore [flags] <backup file>|latest")
+		fmt.Fprintln(os.Stderr, "\nStop wabot before restoring; the current database is kept next to it.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected the backup file to restore")
+	}
+	if cfg.DBDriver != db.DriverSQLite {
+		return db.ErrBackupUnsupported
+	}
+
+	src := fs.Arg(0)
+	if src == "latest" {
+		backups, err := backup.List(opts)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no backups in %s", opts.Dir)
+		}
+		src = backups[len(backups)-1]
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	result, err := backup.Restore(ctx, cfg, src)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s from %s (%d interactions, schema version %d)\n",
+		cfg.DBPath, src, result.Check.Interactions, result.Check.Version)
+	if result.Previous != "" {
+		fmt.Printf("The replaced database was kept as %s\n", result.Previous)
+	}
+	if result.Erasures > 0 {
+		fmt.Printf("Carried over %d erasures recorded after the backup, erasing %d senders from it again:\n", result.Erasures, len(result.Reapplied))
+		for _, e := range result.Reapplied {
+			fmt.Printf("  %s  requested by %s, %d interactions and %d messages removed\n", e.SubjectHash[:12], e.RequestedBy, e.Interactions, e.Messages)
+		}
+	}
+
+	// The restore is recorded in the restored database, which is migrated
+	// first if its schema predates the audit log
+	store, err := db.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("opening the restored database: %w", err)
+	}
+	defer store.Close()
+	if _, err := store.AppendAudit(context.Background(), result.AuditEvent(currentUser(), cfg.DBPath)); err != nil {
+		return fmt.Errorf("recording the restore in the audit log: %w", err)
+	}
+	return nil
+}
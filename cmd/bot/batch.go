@@ -0,0 +1,53 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"golang-llm-sqlite-bot/core/batch"
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// runBatch processes a JSONL prompt file and writes the results to another JSONL file
+func runBatch(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	in := fs.String("in", "", "input JSONL file with {\"prompt\", \"completion\"} lines")
+	out := fs.String("out", "", "output JSONL file, also used as the resume checkpoint")
+	concurrency := fs.Int("concurrency", 4, "number of prompts processed in parallel")
+	rate := fs.Float64("rate", 0, "maximum requests per second (0 for unlimited)")
+	fresh := fs.Bool("fresh", false, "ignore existing results and start over")
+	retryFailed := fs.Bool("retry-failed", false, "process lines again whose existing results are errors")
+	fs.Parse(args)
+
+	if *in == "" || *out == "" {
+		fs.Usage()
+		return errors.New("-in and -out are required")
+	}
+	if cfg.GroqAPIKey == "" {
+		return errors.New("GROQ_API_KEY environment variable is required")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	runner := batch.NewRunner(llm.NewGroqClient(cfg), batch.Options{
+		Concurrency: *concurrency,
+		RateLimit:   *rate,
+		Resume:      !*fresh,
+		RetryFailed: *retryFailed,
+	})
+
+	summary, err := runner.Run(ctx, *in, *out)
+	if summary != nil {
+		fmt.Printf("Processed %d lines: %d succeeded, %d failed, %d skipped from checkpoint\n",
+			summary.Total, summary.Succeeded, summary.Failed, summary.Skipped)
+	}
+	if errors.Is(err, ctx.Err()) && err != nil {
+		fmt.Println("Interrupted; rerun the same command to resume")
+		return nil
+	}
+	return err
+}
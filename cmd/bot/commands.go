@@ -0,0 +1,75 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"golang-llm-sqlite-bot/core/config"
+)
+
+// command is an administrative subcommand of the bot binary
+type command struct {
+	summary string
+	run     func(cfg *config.Config, args []string) error
+}
+
+// commands lists the available subcommands by name
+var commands = map[string]command{
+	"audit":       {"List the audit log of administrative changes or verify it", runAudit},
+	"backup":      {"Back up the SQLite database or list the backups", runBackup},
+	"batch":       {"Run a JSONL prompt file through the LLM", runBatch},
+	"encryption":  {"Show, rotate or remove the encryption of stored messages", runEncryption},
+	"eval":        {"Score prompt/model profiles against golden test cases", runEval},
+	"experiments": {"List A/B experiments or compare their variants", runExperiments},
+	"export":      {"Export stored interactions as JSONL", runExport},
+	"import":      {"Import JSONL exports and WhatsApp chat exports", runImport},
+	"migrate":     {"Show, apply or roll back database migrations", runMigrate},
+	"privacy":     {"Export or erase all data stored about a sender", runPrivacy},
+	"prompts":     {"List system prompt versions seen in interactions", runPrompts},
+	"prune":       {"Apply the retention rules to stored interactions", runPrune},
+	"restore":     {"Replace the SQLite database with a backup", runRestore},
+	"review":      {"Review interactions and correct responses for training data", runReview},
+	"search":      {"Full-text search over stored prompts and responses", runSearch},
+	"similar":     {"Find stored interactions similar to a text or interaction", runSimilar},
+	"stats":       {"Report usage, latency, token cost and error statistics", runStats},
+}
+
+// runCommand executes the named subcommand
+func runCommand(cfg *config.Config, name string, args []string) error {
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return nil
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(cfg, args)
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: bot [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\nWithout a command the interactive chat is started.")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// signalContext returns a context that is cancelled on Ctrl+C or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
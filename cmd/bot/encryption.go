@@ -0,0 +1,136 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// runEncryption manages the keys that encrypt stored message content
+func runEncryption(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("encryption", flag.ExitOnError)
+	batchSize := fs.Int("batch", 500, "rotate, decrypt: rows re-encrypted per transaction")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot encryption [flags] status|rotate|decrypt|generate-key [id]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected one of status, rotate, decrypt or generate-key")
+	}
+
+	action := fs.Arg(0)
+	if action == "generate-key" {
+		id := fs.Arg(1)
+		if id == "" {
+			id = time.Now().UTC().Format("20060102")
+		}
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:%s\n", id, key)
+		return nil
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	switch action {
+	case "status":
+		usage, err := store.EncryptionStatus(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TABLE\tKEY\tROWS")
+		for _, u := range usage {
+			key := u.KeyID
+			if key == "" {
+				key = "(plaintext)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", u.Table, key, u.Rows)
+		}
+		return tw.Flush()
+
+	case "rotate", "decrypt":
+		before, err := store.EncryptionStatus(ctx)
+		if err != nil {
+			return err
+		}
+		var current string // table being rotated, for progress output
+		done, err := store.RotateKeys(ctx, db.RotationOptions{
+			BatchSize: *batchSize,
+			Decrypt:   action == "decrypt",
+			Progress: func(table string, done int) {
+				if table != current && current != "" {
+					fmt.Fprintln(os.Stderr)
+				}
+				current = table
+				fmt.Fprintf(os.Stderr, "\r%s: %d rows", table, done)
+			},
+		})
+		if current != "" {
+			fmt.Fprintln(os.Stderr)
+		}
+		verb := "Re-encrypted"
+		if action == "decrypt" {
+			verb = "Decrypted"
+		}
+		tables := make([]string, 0, len(done))
+		for table := range done {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			fmt.Printf("%s %d %s rows\n", verb, done[table], table)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("All rows already use the target key")
+		}
+		if len(done) > 0 {
+			if auditErr := auditRotation(store, action, before); auditErr != nil {
+				return errors.Join(err, auditErr)
+			}
+		}
+		return err
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown encryption action %q", action)
+	}
+}
+
+// auditRotation records a finished or interrupted rotation with the key usage
+// before and after it. It uses its own context so an interrupted rotation is
+// still recorded.
+func auditRotation(store *db.SQLStore, action string, before []db.KeyUsage) error {
+	ctx := context.Background()
+	after, err := store.EncryptionStatus(ctx)
+	if err != nil {
+		return err
+	}
+	name := db.AuditEncryptionRotate
+	if action == "decrypt" {
+		name = db.AuditEncryptionDecrypt
+	}
+	return audit(ctx, store, name, "encryption", before, after)
+}
@@ -0,0 +1,97 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/eval"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// runEval runs the evaluation cases against one or two profiles and prints a report
+func runEval(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	casesPath := fs.String("cases", "", "JSON or JSONL file with evaluation cases")
+	basePath := fs.String("a", "", "baseline profile JSON (defaults to the current environment)")
+	otherPath := fs.String("b", "", "candidate profile JSON to compare against the baseline")
+	judgeModel := fs.String("judge-model", "", "model used to score answers against reference answers")
+	threshold := fs.Float64("judge-threshold", 0.7, "minimum judge score (0-1) for a case to pass")
+	concurrency := fs.Int("concurrency", 4, "number of cases evaluated in parallel")
+	jsonOut := fs.String("json", "", "also write the full report as JSON to this file")
+	fs.Parse(args)
+
+	if *casesPath == "" {
+		fs.Usage()
+		return errors.New("-cases is required")
+	}
+	if cfg.GroqAPIKey == "" {
+		return errors.New("GROQ_API_KEY environment variable is required")
+	}
+
+	cases, err := eval.LoadCases(*casesPath)
+	if err != nil {
+		return err
+	}
+
+	// Both profiles are loaded up front, so a broken candidate is reported
+	// before the baseline run spends its LLM calls
+	base := eval.BaseProfile(cfg)
+	if *basePath != "" {
+		if base, err = eval.LoadProfile(*basePath, cfg); err != nil {
+			return err
+		}
+	}
+	var other *eval.Profile
+	if *otherPath != "" {
+		if other, err = eval.LoadProfile(*otherPath, cfg); err != nil {
+			return err
+		}
+	}
+
+	var judge *eval.Judge
+	if *judgeModel != "" {
+		judgeCfg := *cfg
+		judgeCfg.ModelName = *judgeModel
+		judgeCfg.SystemPrompt = eval.JudgePrompt
+		judge = eval.NewJudge(llm.NewGroqClient(&judgeCfg), *threshold)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	runner := eval.NewRunner(judge, *concurrency)
+
+	fmt.Printf("Evaluating %d cases with %s...\n", len(cases), base.Name)
+	baseRun := runner.Run(ctx, llm.NewGroqClient(base.Apply(cfg)), base, cases)
+
+	var otherRun *eval.RunResult
+	if other != nil {
+		fmt.Printf("Evaluating %d cases with %s...\n", len(cases), other.Name)
+		otherRun = runner.Run(ctx, llm.NewGroqClient(other.Apply(cfg)), other, cases)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	report := eval.Compare(baseRun, otherRun)
+	fmt.Println()
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding report: %w", err)
+		}
+		if err := os.WriteFile(*jsonOut, data, 0o644); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/experiment"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// runExperiments lists the configured experiments or reports on one of them
+func runExperiments(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("experiments", flag.ExitOnError)
+	report := fs.String("report", "", "compare the variants of this experiment")
+	assign := fs.String("assign", "", "show the variant assigned to this chat ID")
+	fs.Parse(args)
+
+	if cfg.ExperimentsFile == "" {
+		return errors.New("EXPERIMENTS_FILE is not set")
+	}
+	set, err := experiment.Load(cfg.ExperimentsFile, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *assign != "":
+		a, ok := set.Assign(*assign)
+		if !ok {
+			fmt.Printf("Chat %s is not enrolled in any experiment\n", *assign)
+			return nil
+		}
+		fmt.Printf("Chat %s: experiment %s, variant %s\n", *assign, a.Experiment, a.Variant.Name)
+		return nil
+
+	case *report != "":
+		return printExperimentReport(cfg, set, *report)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EXPERIMENT\tTRAFFIC\tVARIANT\tWEIGHT\tMODEL\tPROMPT VERSION")
+	for _, exp := range set.Experiments() {
+		for _, v := range exp.Variants {
+			fmt.Fprintf(tw, "%s\t%.0f%%\t%s\t%d\t%s\t%s\n",
+				exp.Name, exp.Traffic*100, v.Name, v.Weight, v.Request.Model, db.PromptHash(v.Request.SystemPrompt)[:12])
+		}
+	}
+	return tw.Flush()
+}
+
+func printExperimentReport(cfg *config.Config, set *experiment.Set, name string) error {
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := store.ExperimentReport(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		fmt.Printf("No interactions recorded for experiment %s\n", name)
+		return nil
+	}
+
+	// Variants that are still defined are priced with their own model prices
+	pricing := make(map[string]llm.Pricing)
+	if exp, ok := set.Experiment(name); ok {
+		for _, v := range exp.Variants {
+			pricing[v.Name] = v.Pricing
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIANT\tINTERACTIONS\tCHATS\tTURNS/CHAT\tAVG MS\tTOKENS\tCOST USD\tCOST/TURN\t+1\t-1\tNET")
+	for _, v := range stats {
+		p, ok := pricing[v.Variant]
+		if !ok {
+			p = llm.Pricing{PromptPerMTok: cfg.PromptPricePerMTok, CompletionPerMTok: cfg.CompletionPricePerMTok}
+		}
+		cost := p.Cost(llm.Usage{PromptTokens: int(v.PromptTokens), CompletionTokens: int(v.CompletionTokens)})
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.0f\t%d\t%.6f\t%.6f\t%d\t%d\t%+d\n",
+			v.Variant, v.Interactions, v.Chats, v.AvgConversationLength(), v.AvgLatencyMS,
+			v.PromptTokens+v.CompletionTokens, cost, cost/float64(v.Interactions),
+			v.PositiveFeedback, v.NegativeFeedback, v.NetFeedback())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,234 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// pairFormats are the export formats writing preference pairs instead of examples
+var pairFormats = []string{"dpo", "dpo-chat"}
+
+// runExport streams the stored interactions as a fine-tuning dataset
+func runExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "completion", "dataset format: "+strings.Join(append(db.ExportFormats(), pairFormats...), ", "))
+	out := fs.String("out", "-", "output file, - for stdout; a .gz suffix compresses the output")
+	compress := fs.Bool("gzip", false, "gzip the output")
+	from := fs.String("from", "", "only export interactions on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only export interactions before this date (YYYY-MM-DD)")
+	chatID := fs.String("chat", "", "only export interactions from this chat")
+	promptVersion := fs.String("prompt-version", "", "only export interactions from this prompt version (hash prefix)")
+	model := fs.String("model", "", "only export interactions from this model")
+	provider := fs.String("provider", "", "only export interactions from this provider")
+	rating := fs.String("rating", "", "only export interactions rated positive, negative or unrated")
+	review := fs.String("review", "", "only export interactions with this review status: pending, approved, rejected, edited or accepted (approved or edited)")
+	multiTurn := fs.Bool("multi-turn", false, "group consecutive interactions of a chat into one conversation")
+	sessionGap := fs.Duration("session-gap", 30*time.Minute, "multi-turn: start a new conversation after this much silence")
+	maxTurns := fs.Int("max-turns", 20, "multi-turn: maximum interactions per conversation")
+	noSystem := fs.Bool("no-system", false, "leave the system prompt out of the examples")
+	minConfidence := fs.Float64("min-confidence", 0.5, "dpo: drop preference pairs with a lower confidence (0-1)")
+	regenWindow := fs.Duration("regeneration-window", 10*time.Minute, "dpo: a prompt repeated within this time regenerates the previous answer")
+	dedup := fs.String("dedup", "", "remove duplicate examples: exact (same normalized text) or near (also similar texts)")
+	dedupAction := fs.String("dedup-action", db.DedupKeepOne, "dedup: what to keep of every cluster of duplicates: keep-one, cap or drop (none)")
+	dedupBy := fs.String("dedup-by", db.DedupByPrompt, "dedup: text compared: prompt (user messages) or example (user and assistant messages)")
+	dedupSimilarity := fs.Float64("dedup-similarity", 0.8, "dedup near: similarity (0-1) from which texts are duplicates")
+	dedupCap := fs.Int("dedup-cap", 3, "dedup cap: examples kept per cluster")
+	dedupReport := fs.String("dedup-report", "", "dedup: write the clusters found as JSON to this file")
+	fs.Parse(args)
+
+	pairs := slices.Contains(pairFormats, *format)
+	if !pairs && !slices.Contains(db.ExportFormats(), *format) {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	if pairs && *dedup != "" {
+		return errors.New("-dedup does not apply to preference pairs, which are deduplicated already")
+	}
+
+	filter := db.InteractionFilter{
+		ChatID:        *chatID,
+		PromptVersion: *promptVersion,
+		Model:         *model,
+		Provider:      *provider,
+	}
+	var err error
+	if filter.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if filter.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if filter.Rating, err = db.ParseRatingFilter(*rating); err != nil {
+		return err
+	}
+	if filter.Review, err = db.ParseReviewFilter(*review); err != nil {
+		return err
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var w io.Writer = os.Stdout
+	toFile := *out != "" && *out != "-"
+	if toFile {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	buf := bufio.NewWriter(w)
+	w = buf
+	var gz *gzip.Writer
+	if *compress || strings.HasSuffix(*out, ".gz") {
+		gz = gzip.NewWriter(buf)
+		w = gz
+	}
+
+	var n int
+	var report db.DedupReport
+	if pairs {
+		n, err = db.ExportPreferencePairs(context.Background(), store, w, db.PairOptions{
+			Filter:             filter,
+			MinConfidence:      *minConfidence,
+			RegenerationWindow: *regenWindow,
+			Conversational:     *format == "dpo-chat",
+			OmitSystemPrompt:   *noSystem,
+		})
+	} else {
+		n, err = db.Export(context.Background(), store, w, db.ExportOptions{
+			Format:           *format,
+			Filter:           filter,
+			MultiTurn:        *multiTurn,
+			SessionGap:       *sessionGap,
+			MaxTurns:         *maxTurns,
+			OmitSystemPrompt: *noSystem,
+			Dedup: db.DedupOptions{
+				Mode:          *dedup,
+				Action:        *dedupAction,
+				By:            *dedupBy,
+				Similarity:    *dedupSimilarity,
+				MaxPerCluster: *dedupCap,
+				Report:        &report,
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("compressing output: %w", err)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+
+	if *dedup != "" {
+		if err := writeDedupReport(&report, *dedupReport); err != nil {
+			return err
+		}
+	}
+
+	// The flags given record what was exported
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+	target := *out
+	if !toFile {
+		target = "stdout"
+	}
+	exported := map[string]interface{}{"format": *format, "records": n, "flags": flags}
+	if err := audit(context.Background(), store, db.AuditExport, target, nil, exported); err != nil {
+		return err
+	}
+
+	if toFile {
+		unit := "examples"
+		if pairs {
+			unit = "preference pairs"
+		}
+		fmt.Printf("Exported %d %s as %s to %s\n", n, unit, *format, *out)
+	}
+	return nil
+}
+
+// writeDedupReport prints a summary of the duplicates removed to stderr,
+// since the export itself may go to stdout, and writes the clusters as JSON
+// when a report file is given
+func writeDedupReport(report *db.DedupReport, path string) error {
+	fmt.Fprintf(os.Stderr, "Deduplication kept %d of %d examples, dropped %d in %d clusters\n",
+		report.Kept, report.Examples, report.Dropped, len(report.Clusters))
+	for _, c := range report.Clusters[:min(len(report.Clusters), 5)] {
+		fmt.Fprintf(os.Stderr, "  %5d  %s\n", c.Size, c.Text)
+	}
+
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding dedup report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing dedup report: %w", err)
+	}
+	return nil
+}
+
+// runPrompts lists the system prompt versions recorded with interactions
+func runPrompts(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("prompts", flag.ExitOnError)
+	show := fs.String("show", "", "print the full content of the prompt version with this hash prefix")
+	fs.Parse(args)
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	versions, err := store.ListPromptVersions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *show != "" {
+		for _, v := range versions {
+			if strings.HasPrefix(v.Hash, *show) {
+				fmt.Println(v.Content)
+				return nil
+			}
+		}
+		return fmt.Errorf("no prompt version matches %q", *show)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tFIRST SEEN\tINTERACTIONS\tPROMPT")
+	for _, v := range versions {
+		firstLine := []rune(strings.SplitN(v.Content, "\n", 2)[0])
+		if len(firstLine) > 60 {
+			firstLine = append(firstLine[:60], []rune("...")...)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", v.Hash[:12], v.CreatedAt.Format("2006-01-02 15:04"), v.Interactions, string(firstLine))
+	}
+	return tw.Flush()
+}
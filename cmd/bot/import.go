@@ -0,0 +1,169 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+	"golang-llm-sqlite-bot/core/importer"
+)
+
+// participantFlag collects repeated -participant name=jid flags
+type participantFlag map[string]string
+
+func (p participantFlag) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p participantFlag) Set(value string) error {
+	name, id, ok := strings.Cut(value, "=")
+	if !ok || name == "" || id == "" {
+		return errors.New("expected name=jid")
+	}
+	p[name] = id
+	return nil
+}
+
+// maxPrintedErrors limits the parse errors listed per file
+const maxPrintedErrors = 10
+
+// runImport reads JSONL exports and WhatsApp chat exports into the store
+func runImport(cfg *config.Config, args []string) error {
+	participants := participantFlag{}
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "input format, jsonl or whatsapp; detected from the file extension by default")
+	channel := fs.String("channel", "", "channel of the imported chats (default import for JSONL, whatsapp for chat exports)")
+	chatID := fs.String("chat", "", "attribute all imported messages to this chat ID")
+	botName := fs.String("bot", "", "whatsapp: display name of the bot, whose messages become responses")
+	fs.Var(participants, "participant", "whatsapp: map a display name to a sender JID as name=jid, repeatable")
+	dateOrder := fs.String("date-order", "", "whatsapp: date order dmy, mdy or ymd (detected by default)")
+	tz := fs.String("tz", "Local", "whatsapp: time zone of the timestamps")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot import [flags] <file>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected at least one file to import")
+	}
+	switch *dateOrder {
+	case "", importer.DateDMY, importer.DateMDY, importer.DateYMD:
+	default:
+		return fmt.Errorf("unknown date order %q", *dateOrder)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fmt.Errorf("invalid -tz: %w", err)
+	}
+
+	keys, err := encryption.FromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("loading encryption keys: %w", err)
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	opts := importer.Options{
+		Format:       *format,
+		Channel:      *channel,
+		ChatID:       *chatID,
+		BotName:      *botName,
+		Participants: participants,
+		DateOrder:    *dateOrder,
+		Location:     loc,
+		Keys:         keys,
+	}
+
+	var reports []*importer.Report
+	failed := false
+	for _, path := range fs.Args() {
+		report, err := importer.Import(ctx, store, path, opts)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	printImportReports(reports)
+	if err := auditImport(store, reports); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some files could not be imported")
+	}
+	return nil
+}
+
+// importedFile is the audit record of one imported file
+type importedFile struct {
+	File         string `json:"file"`
+	Format       string `json:"format"`
+	Interactions int    `json:"interactions"`
+	Messages     int    `json:"messages"`
+}
+
+// auditImport records the files that added data in the audit log. It uses
+// its own context so an interrupted import is still recorded.
+func auditImport(store *db.SQLStore, reports []*importer.Report) error {
+	var files []importedFile
+	for _, r := range reports {
+		if r.Interactions > 0 || r.Messages > 0 {
+			files = append(files, importedFile{File: r.File, Format: r.Format, Interactions: r.Interactions, Messages: r.Messages})
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return audit(context.Background(), store, db.AuditImport, "interactions", nil, files)
+}
+
+func printImportReports(reports []*importer.Report) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tFORMAT\tRECORDS\tINTERACTIONS\tMESSAGES\tDUPLICATES\tSKIPPED\tERRORS")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			r.File, r.Format, r.Records, r.Interactions, r.Messages, r.Duplicates, r.Skipped, len(r.Errors))
+	}
+	tw.Flush()
+
+	for _, r := range reports {
+		if len(r.Warnings) > 0 || len(r.Errors) > 0 {
+			fmt.Println()
+		}
+		for _, w := range r.Warnings {
+			fmt.Printf("%s: warning: %s\n", r.File, w)
+		}
+		for i, e := range r.Errors {
+			if i == maxPrintedErrors {
+				fmt.Printf("%s: ... and %d more errors\n", r.File, len(r.Errors)-maxPrintedErrors)
+				break
+			}
+			fmt.Printf("%s: %v\n", r.File, e)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"golang-llm-sqlite-bot/core/bot"
 	"golang-llm-sqlite-bot/core/config"
 	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/experiment"
 	"golang-llm-sqlite-bot/core/llm"
 
 	"github.com/joho/godotenv"
@@ -28,6 +29,19 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	// Run an administrative subcommand when one is given
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	runChat(cfg)
+}
+
+// runChat runs the interactive chat loop
+func runChat(cfg *config.Config) {
 	// Validate required config
 	if cfg.GroqAPIKey == "" {
 		log.Fatal("GROQ_API_KEY environment variable is required")
@@ -37,14 +51,27 @@ func main() {
 	llmClient := llm.NewGroqClient(cfg)
 
 	// Initialize database
-	store, err := db.NewSQLiteStore(cfg)
+	store, err := db.Open(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer store.Close()
 
+	// Load A/B experiments
+	var experiments *experiment.Set
+	if cfg.ExperimentsFile != "" {
+		if experiments, err = experiment.Load(cfg.ExperimentsFile, cfg); err != nil {
+			log.Fatalf("Failed to load experiments: %v", err)
+		}
+	}
+
+	settings, err := db.SettingsFromConfig(cfg, store)
+	if err != nil {
+		log.Fatalf("Invalid setting defaults: %v", err)
+	}
+
 	// Create bot instance
-	chatBot := bot.NewBot(llmClient, store)
+	chatBot := bot.NewBot(llmClient, store, bot.WithExperiments(experiments), bot.WithSettings(settings))
 
 	// Set up context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -60,7 +87,7 @@ func main() {
 	}()
 
 	// Start chat loop
-	fmt.Println("Chat started. Press Ctrl+C to exit.")
+	fmt.Println("Chat started. Rate the last answer with /good or /bad, see the settings with /settings, press Ctrl+C to exit.")
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for {
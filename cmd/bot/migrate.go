@@ -0,0 +1,107 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runMigrate shows, applies or rolls back database schema migrations
+func runMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to roll back with down")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot migrate [flags] status|up|down")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one of status, up or down")
+	}
+
+	migrator, conn, err := db.OpenMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if fs.Arg(0) == "status" {
+		return printMigrationStatus(ctx, migrator)
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		if len(applied) > 0 {
+			auditMigration(ctx, migrator, applied[0].Version-1, applied[len(applied)-1].Version)
+		}
+		return err
+
+	case "down":
+		if *steps < 1 {
+			return errors.New("-steps must be at least 1")
+		}
+		rolledBack, err := migrator.Down(ctx, *steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if len(rolledBack) > 0 {
+			auditMigration(ctx, migrator, rolledBack[0].Version, rolledBack[len(rolledBack)-1].Version-1)
+		}
+		return err
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate action %q", fs.Arg(0))
+	}
+}
+
+// auditMigration records a schema change in the audit log. The schema has
+// already changed, so a failure only warns.
+func auditMigration(ctx context.Context, migrator *db.Migrator, from, to int) {
+	if _, err := db.AuditMigration(ctx, migrator, currentUser(), from, to); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the migration was not recorded in the audit log: %v\n", err)
+	}
+}
+
+func printMigrationStatus(ctx context.Context, migrator *db.Migrator) error {
+	current, err := migrator.Current(ctx)
+	if err != nil {
+		return err
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database version %d, binary supports %d\n\n", current, migrator.Latest())
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		status, at := "pending", ""
+		if s.Applied {
+			status, at = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, status, at)
+	}
+	if current > migrator.Latest() {
+		fmt.Fprintf(tw, "\nThe database is newer than this binary; upgrade before running the bot.\n")
+	}
+	return tw.Flush()
+}
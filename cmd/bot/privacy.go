@@ -0,0 +1,149 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runPrivacy exports or erases the data stored about a sender
+func runPrivacy(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("privacy", flag.ExitOnError)
+	out := fs.String("out", "", "export: write the JSON bundle to this file instead of stdout")
+	requestedBy := fs.String("by", "", "erase: who requested the erasure, recorded in the audit log")
+	reason := fs.String("reason", "", "erase: reason recorded in the audit log")
+	yes := fs.Bool("yes", false, "erase: delete without asking for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot privacy [flags] export <jid>|erase <jid>|erasures [jid]")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nerase only removes the sender from the database. Their interactions stay in")
+		fmt.Fprintln(os.Stderr, "retention archives (RETENTION_ARCHIVE_DIR) and backups (BACKUP_DIR) taken")
+		fmt.Fprintln(os.Stderr, "before the erasure, which must be purged separately.")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected one of export, erase or erasures")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	action, subject := fs.Arg(0), fs.Arg(1)
+	switch action {
+	case "export":
+		if subject == "" {
+			return errors.New("export needs the sender's JID or phone number")
+		}
+		return exportSubject(ctx, store, subject, *out)
+
+	case "erase":
+		if subject == "" {
+			return errors.New("erase needs the sender's JID or phone number")
+		}
+		if *requestedBy == "" {
+			return errors.New("-by is required so the erasure can be audited")
+		}
+		return eraseSubject(ctx, store, subject, *requestedBy, *reason, *yes)
+
+	case "erasures":
+		return printErasures(ctx, store, subject)
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown privacy action %q", action)
+	}
+}
+
+// exportSubject writes everything stored about a sender as a JSON bundle
+func exportSubject(ctx context.Context, store *db.SQLStore, subject, out string) error {
+	data, err := store.ExportSubject(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding bundle: %w", err)
+	}
+	exported := map[string]int{"interactions": len(data.Interactions), "messages": len(data.Messages)}
+	if err := audit(ctx, store, db.AuditPrivacyExport, store.Subjects().Target(subject), nil, exported); err != nil {
+		return err
+	}
+	if out == "" {
+		fmt.Println(string(bundle))
+		return nil
+	}
+	if err := os.WriteFile(out, append(bundle, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	fmt.Printf("Exported %d interactions and %d messages of %s to %s\n", len(data.Interactions), len(data.Messages), subject, out)
+	return nil
+}
+
+// eraseSubject deletes everything stored about a sender after confirmation
+func eraseSubject(ctx context.Context, store *db.SQLStore, subject, requestedBy, reason string, yes bool) error {
+	data, err := store.ExportSubject(ctx, subject)
+	if err != nil {
+		return err
+	}
+	if data.Empty() {
+		fmt.Printf("Nothing is stored about %s\n", subject)
+		return nil
+	}
+
+	fmt.Printf("This permanently deletes %d interactions, %d messages, %d ratings, %d chats and %d participant records of %s.\n",
+		len(data.Interactions), len(data.Messages), len(data.Feedback), len(data.Chats), len(data.Participants), subject)
+	if !yes {
+		fmt.Print("Type the JID or phone number again to confirm: ")
+		var answer string
+		fmt.Scanln(&answer)
+		if answer != subject {
+			return errors.New("erasure cancelled")
+		}
+	}
+
+	erasure, err := store.EraseSubject(ctx, subject, requestedBy, reason)
+	if err != nil {
+		return err
+	}
+	if err := audit(ctx, store, db.AuditPrivacyErase, store.Subjects().Target(subject), nil, erasure); err != nil {
+		return err
+	}
+	fmt.Printf("Erased %d interactions, %d messages, %d ratings, %d chats and %d participant records (audit record #%d)\n",
+		erasure.Interactions, erasure.Messages, erasure.Feedback, erasure.Chats, erasure.Participants, erasure.ID)
+	fmt.Println("Retention archives and backups taken before now still hold their data and must be purged separately.")
+	if !store.Subjects().Keyed() {
+		fmt.Fprintln(os.Stderr, "Warning: SUBJECT_HASH_KEY is not set, so the erasure record names the sender by a hash that can be reversed")
+	}
+	return nil
+}
+
+// printErasures lists the erasure audit records, optionally for one sender
+func printErasures(ctx context.Context, store *db.SQLStore, subject string) error {
+	erasures, err := store.ListErasures(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tERASED AT\tSUBJECT\tREQUESTED BY\tINTERACTIONS\tMESSAGES\tREASON")
+	for _, e := range erasures {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\t%s\n", e.ID, e.ErasedAt.Local().Format("2006-01-02 15:04"),
+			e.SubjectHash[:12], e.RequestedBy, e.Interactions, e.Messages, e.Reason)
+	}
+	return tw.Flush()
+}
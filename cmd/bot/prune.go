@@ -0,0 +1,89 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+	"golang-llm-sqlite-bot/core/retention"
+)
+
+// runPrune applies the retention rules once and reports what was removed
+func runPrune(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", cfg.RetentionDryRun, "only report what would be removed")
+	archive := fs.String("archive", cfg.RetentionArchiveDir, "archive removed interactions as JSONL in this directory")
+	fs.Parse(args)
+
+	rules, err := retention.RulesFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if !rules.Enabled() {
+		return errors.New("no retention rules configured, set RETENTION_MAX_AGE, RETENTION_MAX_PER_CHAT or RETENTION_CHANNELS")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	keys, err := encryption.FromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("loading encryption keys: %w", err)
+	}
+
+	janitor := retention.NewJanitor(store, rules, retention.Options{
+		BatchSize:  cfg.RetentionBatchSize,
+		ArchiveDir: *archive,
+		DryRun:     *dryRun,
+		Keys:       keys,
+	})
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	report, err := janitor.Prune(ctx)
+	if report != nil {
+		printRetentionReport(report)
+		if report.Deleted() > 0 {
+			// Recorded even when interrupted, the deleted batches are gone
+			if _, auditErr := store.AppendAudit(context.Background(), report.AuditEvent(currentUser())); auditErr != nil {
+				return errors.Join(err, fmt.Errorf("recording the pruning in the audit log: %w", auditErr))
+			}
+		}
+	}
+	return err
+}
+
+func printRetentionReport(report *retention.Report) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANNEL\tMAX AGE\tMAX PER CHAT\tEXPIRED\tARCHIVED\tDELETED")
+	for _, c := range report.Channels {
+		channel := c.Channel
+		if channel == "" {
+			channel = "(none)"
+		}
+		maxAge, maxPerChat := "-", "-"
+		if c.Policy.MaxAge > 0 {
+			maxAge = c.Policy.MaxAge.String()
+		}
+		if c.Policy.MaxPerChat > 0 {
+			maxPerChat = fmt.Sprint(c.Policy.MaxPerChat)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\n", channel, maxAge, maxPerChat, c.Expired, c.Archived, c.Deleted)
+	}
+	tw.Flush()
+
+	if report.DryRun {
+		fmt.Println("\nDry run: nothing was removed")
+	}
+}
@@ -0,0 +1,367 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runReview lists, shows and reviews interactions for dataset curation
+func runReview(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	status := fs.String("status", db.ReviewPending, "list, next: interactions to show: pending, approved, rejected, edited or accepted")
+	channel := fs.String("channel", "", "list, next: only this channel (cli or whatsapp)")
+	chat := fs.String("chat", "", "list, next: only this chat (external chat ID)")
+	model := fs.String("model", "", "list, next: only this model")
+	after := fs.Int64("after", 0, "list, next: start after this interaction ID")
+	limit := fs.Int("limit", 20, "list: interactions per page")
+	reviewer := fs.String("reviewer", "", "who reviews, defaults to the current user")
+	note := fs.String("note", "", "approve, reject, edit: note stored with the review")
+	response := fs.String("response", "", "edit: the corrected response; read from stdin when not set")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot review [flags] list|next|show <id>|approve <id...>|reject <id...>|edit <id>|reset <id...>")
+		fmt.Fprintln(os.Stderr, "\nnext walks through the queue one interaction at a time. reset makes interactions pending again.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected one of list, next, show, approve, reject, edit or reset")
+	}
+
+	filter := db.InteractionFilter{Channel: *channel, ChatID: *chat, Model: *model}
+	var err error
+	if filter.Review, err = db.ParseReviewFilter(*status); err != nil {
+		return err
+	}
+	if *reviewer == "" {
+		*reviewer = currentUser()
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	action := fs.Arg(0)
+	ids, err := parseIDs(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "list":
+		return listReviewQueue(ctx, store, filter, db.Page{AfterID: *after, Limit: *limit})
+
+	case "next":
+		return walkReviewQueue(ctx, store, filter, *after, *reviewer)
+
+	case "show":
+		if len(ids) != 1 {
+			return errors.New("show needs one interaction ID")
+		}
+		return showInteraction(ctx, store, ids[0])
+
+	case "approve", "reject", "reset":
+		if len(ids) == 0 {
+			return fmt.Errorf("%s needs at least one interaction ID", action)
+		}
+		rev := db.Review{Status: db.ReviewApproved, Reviewer: *reviewer, Note: *note}
+		switch action {
+		case "reject":
+			rev.Status = db.ReviewRejected
+		case "reset":
+			rev = db.Review{Status: db.ReviewPending}
+		}
+		for _, id := range ids {
+			rev.InteractionID = id
+			if err := setReview(ctx, store, &rev); err != nil {
+				return err
+			}
+			fmt.Printf("Interaction #%d is %s\n", id, rev.Status)
+		}
+		return nil
+
+	case "edit":
+		if len(ids) != 1 {
+			return errors.New("edit needs one interaction ID")
+		}
+		corrected := *response
+		if corrected == "" {
+			if isTerminal(os.Stdin) {
+				fmt.Println("Type the corrected response and end it with Ctrl+D:")
+			}
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("reading the corrected response: %w", err)
+			}
+			corrected = string(data)
+		}
+		rev := db.Review{
+			InteractionID:     ids[0],
+			Status:            db.ReviewEdited,
+			CorrectedResponse: strings.TrimSpace(corrected),
+			Reviewer:          *reviewer,
+			Note:              *note,
+		}
+		if err := setReview(ctx, store, &rev); err != nil {
+			return err
+		}
+		fmt.Printf("Interaction #%d is %s\n", rev.InteractionID, rev.Status)
+		return nil
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown review action %q", action)
+	}
+}
+
+// listReviewQueue prints one page of the interactions matching the filter
+func listReviewQueue(ctx context.Context, store *db.SQLStore, filter db.InteractionFilter, page db.Page) error {
+	result, err := store.ListInteractions(ctx, filter, page)
+	if err != nil {
+		return err
+	}
+	total, err := store.CountInteractions(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if len(result.Items) == 0 {
+		fmt.Printf("No %s interactions\n", filter.Review)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tCHAT\tSTATUS\tRATING\tPROMPT\tRESPONSE")
+	for _, rec := range result.Items {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%+d\t%s\t%s\n", rec.ID, rec.CreatedAt.Local().Format("2006-01-02 15:04"),
+			rec.ChatID, reviewStatus(&rec), rec.Rating, clip(oneLine(rec.Prompt), 40), clip(oneLine(rec.Response), 40))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d %s interactions in total", total, filter.Review)
+	if result.NextAfterID != 0 {
+		fmt.Printf(", next page: -after %d", result.NextAfterID)
+	}
+	fmt.Println()
+	return nil
+}
+
+// walkReviewQueue shows the matching interactions one at a time and asks
+// for a verdict on each, until the queue ends or the reviewer quits
+func walkReviewQueue(ctx context.Context, store *db.SQLStore, filter db.InteractionFilter, after int64, reviewer string) error {
+	in := bufio.NewReader(os.Stdin)
+	reviewed := 0
+	page := db.Page{AfterID: after, Limit: 20}
+	for {
+		result, err := store.ListInteractions(ctx, filter, page)
+		if err != nil {
+			return err
+		}
+		for _, rec := range result.Items {
+			if err := showInteraction(ctx, store, rec.ID); err != nil {
+				return err
+			}
+
+			rev, quit, err := askVerdict(in, rec.ID, reviewer)
+			if err != nil {
+				return err
+			}
+			if quit {
+				fmt.Printf("Reviewed %d interactions, continue with -after %d\n", reviewed, rec.ID-1)
+				return nil
+			}
+			if rev == nil {
+				continue
+			}
+			if err := setReview(ctx, store, rev); err != nil {
+				return err
+			}
+			reviewed++
+			fmt.Printf("Interaction #%d is %s\n\n", rec.ID, rev.Status)
+		}
+		if result.NextAfterID == 0 {
+			fmt.Printf("Reviewed %d interactions, the queue is empty\n", reviewed)
+			return nil
+		}
+		page.AfterID = result.NextAfterID
+	}
+}
+
+// setReview stores a review in place of any earlier one and records the
+// change in the audit log
+func setReview(ctx context.Context, store *db.SQLStore, rev *db.Review) error {
+	rec, err := store.GetInteraction(ctx, rev.InteractionID)
+	if err != nil {
+		return err
+	}
+	if err := store.SetReview(ctx, rev); err != nil {
+		return err
+	}
+	if _, err := store.AppendAudit(ctx, db.ReviewEvent(currentUser(), rec.Review, rev)); err != nil {
+		return fmt.Errorf("recording the review in the audit log: %w", err)
+	}
+	return nil
+}
+
+// askVerdict reads the reviewer's choice for one interaction. It returns
+// a nil review when the interaction is skipped.
+func askVerdict(in *bufio.Reader, id int64, reviewer string) (rev *db.Review, quit bool, err error) {
+	for {
+		fmt.Print("[a]pprove, [r]eject, [e]dit, [s]kip or [q]uit? ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			if errors.Is(err, io.EOF) {
+				return nil, true, nil
+			}
+			return nil, false, err
+		}
+
+		rev := &db.Review{InteractionID: id, Reviewer: reviewer}
+		choice, note, _ := strings.Cut(strings.TrimSpace(line), " ")
+		rev.Note = strings.TrimSpace(note)
+		switch strings.ToLower(choice) {
+		case "a", "approve":
+			rev.Status = db.ReviewApproved
+		case "r", "reject":
+			rev.Status = db.ReviewRejected
+		case "e", "edit":
+			rev.Status = db.ReviewEdited
+			if rev.CorrectedResponse, err = readCorrection(in); err != nil {
+				return nil, false, err
+			}
+			if rev.CorrectedResponse == "" {
+				fmt.Println("The corrected response is empty, nothing changed")
+				continue
+			}
+		case "s", "skip", "":
+			return nil, false, nil
+		case "q", "quit":
+			return nil, true, nil
+		default:
+			fmt.Println("Unknown choice; a note may follow the choice, e.g. \"r wrong date\"")
+			continue
+		}
+		return rev, false, nil
+	}
+}
+
+// readCorrection reads a corrected response ending with a line holding a single dot
+func readCorrection(in *bufio.Reader) (string, error) {
+	fmt.Println("Type the corrected response and end it with a line holding a single \".\":")
+	var lines []string
+	for {
+		line, err := in.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == "." {
+			break
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// showInteraction prints an interaction with its review and feedback
+func showInteraction(ctx context.Context, store *db.SQLStore, id int64) error {
+	rec, err := store.GetInteraction(ctx, id)
+	if err != nil {
+		return err
+	}
+	feedback, err := store.ListFeedback(ctx, []int64{id})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("#%d  %s  %s %s  %s\n", rec.ID, rec.CreatedAt.Local().Format("2006-01-02 15:04"), rec.Channel, rec.ChatID, rec.Model)
+	fmt.Printf("Status: %s\n", reviewStatus(rec))
+	if rev := rec.Review; rev != nil {
+		fmt.Printf("Reviewed by %s at %s", rev.Reviewer, rev.ReviewedAt.Local().Format("2006-01-02 15:04"))
+		if rev.Note != "" {
+			fmt.Printf(": %s", rev.Note)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("\nPrompt:\n%s\n\nResponse:\n%s\n", rec.Prompt, rec.Response)
+	if rec.Review != nil && rec.Review.CorrectedResponse != "" {
+		fmt.Printf("\nCorrected response:\n%s\n", rec.Review.CorrectedResponse)
+	}
+
+	// The review's own operator feedback is already shown above
+	var others []db.FeedbackRecord
+	for _, fb := range feedback {
+		if fb.Source != db.FeedbackOperator || rec.Review == nil || fb.SenderID != rec.Review.Reviewer {
+			others = append(others, fb)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Println("\nFeedback:")
+		for _, fb := range others {
+			line := fmt.Sprintf("  %+d  %s %s", fb.Rating, fb.Source, fb.SenderID)
+			if fb.Comment != "" {
+				line += ": " + fb.Comment
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// reviewStatus names the review status of an interaction
+func reviewStatus(rec *db.InteractionRecord) string {
+	if rec.Review == nil {
+		return db.ReviewPending
+	}
+	return rec.Review.Status
+}
+
+// parseIDs parses interaction IDs given as arguments
+func parseIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid interaction ID %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// clip shortens s to at most n characters
+func clip(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// currentUser names the operator running the command
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "operator"
+}
@@ -0,0 +1,100 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// runSearch prints the interactions matching a full-text query, best first
+func runSearch(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	chat := fs.String("chat", "", "only search this chat (external chat ID)")
+	sender := fs.String("sender", "", "only search messages from this sender (external ID)")
+	channel := fs.String("channel", "", "only search this channel (cli or whatsapp)")
+	from := fs.String("from", "", "only search from this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only search before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 20, "maximum number of results")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot search [flags] <words...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no search words given")
+	}
+
+	filter := db.InteractionFilter{Channel: *channel, ChatID: *chat, SenderID: *sender}
+	var err error
+	if filter.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	if filter.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	query := db.SearchQuery{
+		Text:   strings.Join(fs.Args(), " "),
+		Filter: filter,
+		Limit:  *limit,
+	}
+	if isTerminal(os.Stdout) {
+		query.HighlightStart, query.HighlightEnd = "\033[1m", "\033[0m"
+	}
+
+	results, err := store.Search(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No matching interactions")
+		return nil
+	}
+
+	for n, r := range results {
+		rec := r.Interaction
+		where := rec.Channel
+		if rec.ChatID != "" {
+			where = strings.TrimSpace(where + " " + rec.ChatID)
+		}
+		fmt.Printf("%d. #%d  %s  %s  (score %.3g)\n", n+1, rec.ID, rec.CreatedAt.Local().Format("2006-01-02 15:04"), where, r.Score)
+		fmt.Printf("   Q: %s\n", oneLine(r.PromptSnippet))
+		fmt.Printf("   A: %s\n\n", oneLine(r.ResponseSnippet))
+	}
+	return nil
+}
+
+// parseDate parses an optional YYYY-MM-DD date in local time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// oneLine collapses whitespace so a snippet prints on a single line
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
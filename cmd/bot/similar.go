@@ -0,0 +1,137 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/embedding"
+)
+
+// runSimilar prints the stored interactions most similar to a text or to
+// another interaction, using their embeddings
+func runSimilar(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("similar", flag.ExitOnError)
+	id := fs.Int64("id", 0, "find interactions similar to this interaction instead of a text")
+	chat := fs.String("chat", "", "only search this chat (external chat ID)")
+	channel := fs.String("channel", "", "only search this channel (cli or whatsapp)")
+	from := fs.String("from", "", "only search from this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only search before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 10, "maximum number of results")
+	minScore := fs.Float64("min-score", 0, "lowest similarity shown (-1 to 1)")
+	index := fs.Bool("index", true, "embed the interactions that have no embedding yet before searching")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot similar [flags] <text...>")
+		fmt.Fprintln(os.Stderr, "       bot similar [flags] -id <interaction>")
+		fmt.Fprintln(os.Stderr, "\nThe embedder is chosen with EMBEDDINGS_PROVIDER (local or openai).")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	text := strings.Join(fs.Args(), " ")
+	if (text == "") == (*id == 0) {
+		fs.Usage()
+		return errors.New("give either a text or -id")
+	}
+
+	filter := db.InteractionFilter{Channel: *channel, ChatID: *chat}
+	var err error
+	if filter.From, err = parseDate(*from); err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	if filter.To, err = parseDate(*to); err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	embedder, err := embedding.EmbedderFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if embedder == nil {
+		return errors.New("no embedder configured, set EMBEDDINGS_PROVIDER to local or openai")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	if *index {
+		n, err := embedding.NewPopulator(store, embedder, cfg.EmbeddingsBatchSize).EmbedPending(ctx)
+		if n > 0 {
+			fmt.Fprintf(os.Stderr, "Embedded %d new interactions with %s\n", n, embedder.Model())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	query := db.SimilarQuery{
+		Model:     embedder.Model(),
+		Filter:    filter,
+		ExcludeID: *id,
+		MinScore:  *minScore,
+		Limit:     *limit,
+	}
+	if *id != 0 {
+		query.Vector, err = interactionVector(ctx, store, embedder, *id)
+	} else {
+		var vectors [][]float32
+		if vectors, err = embedder.Embed(ctx, []string{text}); err == nil {
+			query.Vector = vectors[0]
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	results, err := store.SimilarInteractions(ctx, query)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No similar interactions")
+		return nil
+	}
+
+	for n, r := range results {
+		rec := r.Interaction
+		where := rec.Channel
+		if rec.ChatID != "" {
+			where = strings.TrimSpace(where + " " + rec.ChatID)
+		}
+		fmt.Printf("%d. #%d  %s  %s  (similarity %.3f)\n", n+1, rec.ID, rec.CreatedAt.Local().Format("2006-01-02 15:04"), where, r.Score)
+		fmt.Printf("   Q: %s\n", clip(oneLine(rec.Prompt), 200))
+		fmt.Printf("   A: %s\n\n", clip(oneLine(rec.Response), 200))
+	}
+	return nil
+}
+
+// interactionVector returns the stored embedding of an interaction, or
+// embeds it when it has none yet
+func interactionVector(ctx context.Context, store *db.SQLStore, embedder embedding.Embedder, id int64) ([]float32, error) {
+	vector, err := store.GetEmbedding(ctx, id, embedder.Model())
+	if !errors.Is(err, db.ErrNotFound) {
+		return vector, err
+	}
+
+	rec, err := store.GetInteraction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := embedder.Embed(ctx, []string{embedding.Text(rec)})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
@@ -0,0 +1,250 @@
+// Package main provides the entry point for the LLM bot
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// statsSections are the tables the csv format can write
+var statsSections = []string{"daily", "hours", "chats", "models", "totals"}
+
+// runStats prints usage statistics for a date range
+func runStats(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	from := fs.String("from", "", "first day to include (YYYY-MM-DD, UTC); defaults to -days before -to")
+	to := fs.String("to", "", "last day to include (YYYY-MM-DD, UTC); defaults to today")
+	days := fs.Int("days", 30, "number of days reported when -from is not set")
+	channel := fs.String("channel", "", "only count this channel (cli or whatsapp)")
+	top := fs.Int("top", 10, "number of chats in the top chats ranking")
+	format := fs.String("format", "table", "output format: table, json or csv")
+	section := fs.String("section", "daily", "table written by the csv format: "+strings.Join(statsSections, ", "))
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: bot stats [flags]")
+		fmt.Fprintln(os.Stderr, "\nDays and hours are in UTC. Costs use PRICE_PROMPT_PER_MTOK and PRICE_COMPLETION_PER_MTOK.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	query := db.StatsQuery{
+		Channel:                *channel,
+		TopChats:               *top,
+		PromptPricePerMTok:     cfg.PromptPricePerMTok,
+		CompletionPricePerMTok: cfg.CompletionPricePerMTok,
+	}
+	// Both ends are whole UTC days; To is exclusive in the query
+	lastDay := time.Now().UTC().Truncate(24 * time.Hour)
+	if *to != "" {
+		day, err := time.Parse(time.DateOnly, *to)
+		if err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+		lastDay = day
+	}
+	query.To = lastDay.AddDate(0, 0, 1)
+	if *from != "" {
+		day, err := time.Parse(time.DateOnly, *from)
+		if err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+		query.From = day
+	} else {
+		if *days <= 0 {
+			return errors.New("-days must be positive")
+		}
+		query.From = query.To.AddDate(0, 0, -*days)
+	}
+	if !query.From.Before(query.To) {
+		return errors.New("-from must not be after -to")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := store.UsageStats(context.Background(), query)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "table":
+		return printStats(os.Stdout, stats)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "csv":
+		return writeStatsCSV(os.Stdout, stats, *section)
+	default:
+		return fmt.Errorf("unknown format %q, expected table, json or csv", *format)
+	}
+}
+
+// printStats writes the statistics as human readable tables
+func printStats(w io.Writer, stats *db.UsageStats) error {
+	t := stats.Totals
+	fmt.Fprintf(w, "Usage from %s to %s (UTC)", stats.From.Format(time.DateOnly), stats.To.AddDate(0, 0, -1).Format(time.DateOnly))
+	if stats.Channel != "" {
+		fmt.Fprintf(w, ", channel %s", stats.Channel)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Messages\t%d received, %d sent\n", t.Inbound, t.Outbound)
+	fmt.Fprintf(tw, "Active users\t%d in %d chats\n", t.ActiveUsers, t.Chats)
+	fmt.Fprintf(tw, "Interactions\t%d\n", t.Interactions)
+	fmt.Fprintf(tw, "Avg latency\t%.0f ms\n", t.AvgLatencyMS)
+	fmt.Fprintf(tw, "Tokens\t%d prompt, %d completion\n", t.PromptTokens, t.CompletionTokens)
+	fmt.Fprintf(tw, "Cost\t%.4f USD\n", t.CostUSD)
+	fmt.Fprintf(tw, "LLM errors\t%d (%.1f%%)\n", t.LLMFailures, 100*t.LLMErrorRate)
+	fmt.Fprintf(tw, "Delivery errors\t%d (%.1f%%)\n", t.DeliveryFailures, 100*t.DeliveryErrorRate)
+	fmt.Fprintf(tw, "Feedback\t%d positive, %d negative\n", t.PositiveFeedback, t.NegativeFeedback)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(stats.Daily) > 0 {
+		fmt.Fprintln(w, "\nPer day:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DAY\tRECEIVED\tSENT\tUSERS\tAVG MS\tTOKENS\tCOST USD\tERRORS\tFEEDBACK")
+		for _, d := range stats.Daily {
+			// Quiet days are only kept in the json and csv output, for charts
+			if d.Inbound+d.Outbound+d.Interactions+d.LLMFailures+d.DeliveryFailures+d.PositiveFeedback+d.NegativeFeedback == 0 {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.0f\t%d\t%.4f\t%d\t+%d/-%d\n", d.Day, d.Inbound, d.Outbound, d.ActiveUsers,
+				d.AvgLatencyMS, d.PromptTokens+d.CompletionTokens, d.CostUSD, d.LLMFailures+d.DeliveryFailures,
+				d.PositiveFeedback, d.NegativeFeedback)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(stats.TopChats) > 0 {
+		fmt.Fprintln(w, "\nTop chats:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHANNEL\tCHAT\tTITLE\tMESSAGES\tUSERS\tLAST MESSAGE")
+		for _, c := range stats.TopChats {
+			last := ""
+			if !c.LastMessageAt.IsZero() {
+				last = c.LastMessageAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", c.Channel, c.ExternalID, c.Title, c.Messages, c.ActiveUsers, last)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(stats.Models) > 0 {
+		fmt.Fprintln(w, "\nModels:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MODEL\tINTERACTIONS\tAVG MS\tTOKENS\tCOST USD\tFEEDBACK")
+		for _, m := range stats.Models {
+			model := m.Model
+			if model == "" {
+				model = "(unknown)"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%.0f\t%d\t%.4f\t+%d/-%d\n", model, m.Interactions, m.AvgLatencyMS,
+				m.PromptTokens+m.CompletionTokens, m.CostUSD, m.PositiveFeedback, m.NegativeFeedback)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	busiest := 0
+	for _, h := range stats.Hours {
+		busiest = max(busiest, h.Inbound)
+	}
+	if busiest > 0 {
+		fmt.Fprintln(w, "\nReceived messages per hour (UTC):")
+		for _, h := range stats.Hours {
+			bar := strings.Repeat("#", (h.Inbound*40+busiest-1)/busiest)
+			fmt.Fprintln(w, strings.TrimSpace(fmt.Sprintf("%02d:00  %6d  %s", h.Hour, h.Inbound, bar)))
+		}
+	}
+	return nil
+}
+
+// writeStatsCSV writes one section of the statistics as CSV with a header row
+func writeStatsCSV(w io.Writer, stats *db.UsageStats, section string) error {
+	itoa := strconv.Itoa
+	i64 := func(n int64) string { return strconv.FormatInt(n, 10) }
+	ftoa := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+	var records [][]string
+	switch section {
+	case "daily":
+		records = append(records, []string{"day", "inbound_messages", "outbound_messages", "active_users", "interactions",
+			"avg_latency_ms", "prompt_tokens", "completion_tokens", "cost_usd", "llm_failures", "delivery_failures",
+			"positive_feedback", "negative_feedback"})
+		for _, d := range stats.Daily {
+			records = append(records, []string{d.Day, itoa(d.Inbound), itoa(d.Outbound), itoa(d.ActiveUsers), itoa(d.Interactions),
+				ftoa(d.AvgLatencyMS), i64(d.PromptTokens), i64(d.CompletionTokens), ftoa(d.CostUSD), itoa(d.LLMFailures), itoa(d.DeliveryFailures),
+				itoa(d.PositiveFeedback), itoa(d.NegativeFeedback)})
+		}
+	case "hours":
+		records = append(records, []string{"hour", "inbound_messages"})
+		for _, h := range stats.Hours {
+			records = append(records, []string{itoa(h.Hour), itoa(h.Inbound)})
+		}
+	case "chats":
+		records = append(records, []string{"channel", "external_id", "title", "is_group", "messages", "active_users", "last_message_at"})
+		for _, c := range stats.TopChats {
+			last := ""
+			if !c.LastMessageAt.IsZero() {
+				last = c.LastMessageAt.UTC().Format(time.RFC3339)
+			}
+			records = append(records, []string{c.Channel, c.ExternalID, c.Title, strconv.FormatBool(c.IsGroup),
+				itoa(c.Messages), itoa(c.ActiveUsers), last})
+		}
+	case "models":
+		records = append(records, []string{"model", "interactions", "avg_latency_ms", "prompt_tokens", "completion_tokens", "cost_usd",
+			"positive_feedback", "negative_feedback"})
+		for _, m := range stats.Models {
+			records = append(records, []string{m.Model, itoa(m.Interactions), ftoa(m.AvgLatencyMS),
+				i64(m.PromptTokens), i64(m.CompletionTokens), ftoa(m.CostUSD), itoa(m.PositiveFeedback), itoa(m.NegativeFeedback)})
+		}
+	case "totals":
+		t := stats.Totals
+		records = append(records,
+			[]string{"inbound_messages", "outbound_messages", "active_users", "chats", "interactions", "avg_latency_ms",
+				"prompt_tokens", "completion_tokens", "cost_usd", "llm_failures", "delivery_failures", "llm_error_rate", "delivery_error_rate",
+				"positive_feedback", "negative_feedback"},
+			[]string{itoa(t.Inbound), itoa(t.Outbound), itoa(t.ActiveUsers), itoa(t.Chats), itoa(t.Interactions), ftoa(t.AvgLatencyMS),
+				i64(t.PromptTokens), i64(t.CompletionTokens), ftoa(t.CostUSD), itoa(t.LLMFailures), itoa(t.DeliveryFailures),
+				ftoa(t.LLMErrorRate), ftoa(t.DeliveryErrorRate), itoa(t.PositiveFeedback), itoa(t.NegativeFeedback)})
+	default:
+		return fmt.Errorf("unknown section %q, expected one of %s", section, strings.Join(statsSections, ", "))
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("writing CSV: %w", err)
+	}
+	return nil
+}
@@ -2,15 +2,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"golang-llm-sqlite-bot/core/bot"
+	"golang-llm-sqlite-bot/core/app"
 	"golang-llm-sqlite-bot/core/config"
-	"golang-llm-sqlite-bot/core/db"
-	"golang-llm-sqlite-bot/core/llm"
 
 	"github.com/joho/godotenv"
 )
@@ -24,32 +26,21 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	// Validate required config
-	if cfg.GroqAPIKey == "" {
-		log.Fatal("GROQ_API_KEY environment variable is required")
-	}
-
-	// Create LLM client
-	llmClient := llm.NewGroqClient(cfg)
-
-	// Initialize database
-	store, err := db.NewSQLiteStore(cfg)
+	// Open the database and start the bot with its background services
+	rt, err := app.Start(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		log.Fatalf("Failed to start: %v", err)
 	}
-	defer store.Close()
-
-	// Create bot instance
-	chatBot := bot.NewBot(llmClient, store)
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Start webhook server in a goroutine
+	server := &http.Server{}
 	errChan := make(chan error, 1)
 	go func() {
-		if err := chatBot.StartWebhookServer(8080); err != nil {
+		if err := rt.Bot.StartWebhookServer(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
@@ -61,4 +52,15 @@ func main() {
 	case err := <-errChan:
 		log.Printf("Server error: %v", err)
 	}
+
+	// Stop taking requests, then let queued work finish before closing the database
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Webhook server did not stop in time: %v", err)
+		server.Close()
+	}
+	if err := rt.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown failed: %v", err)
+	}
 }
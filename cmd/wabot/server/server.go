@@ -9,10 +9,8 @@ import (
 	"os/signal"
 	"time"
 
-	"golang-llm-sqlite-bot/core/bot"
+	"golang-llm-sqlite-bot/core/app"
 	"golang-llm-sqlite-bot/core/config"
-	"golang-llm-sqlite-bot/core/db"
-	"golang-llm-sqlite-bot/core/llm"
 
 	"github.com/joho/godotenv"
 )
@@ -26,27 +24,20 @@ func StartServer() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	// Validate required config
-	if cfg.GroqAPIKey == "" {
-		log.Fatal("GROQ_API_KEY environment variable is required")
-	}
-
-	// Create LLM client
-	llmClient := llm.NewGroqClient(cfg)
-
-	// Initialize database
-	store, err := db.NewSQLiteStore(cfg)
+	// Open the database and start the bot with its background services
+	rt, err := app.Start(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		log.Fatalf("Failed to start: %v", err)
 	}
-	defer store.Close()
-
-	// Create bot instance
-	chatBot := bot.NewBot(llmClient, store)
 
 	// Set up HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/webhook/wa", chatBot.HandleWhatsAppWebhook)
+	mux.HandleFunc("/webhook/wa", rt.Bot.HandleWhatsAppWebhook)
+	mux.HandleFunc("/metrics/queue", rt.Bot.HandleQueueMetrics)
+	mux.HandleFunc("/metrics/writes", rt.Bot.HandleWriteMetrics)
+	if rt.Admin != nil {
+		mux.Handle("/admin/", rt.Admin)
+	}
 
 	server := &http.Server{
 		Addr:         ":8080",
@@ -91,5 +82,10 @@ func StartServer() {
 		if err != nil {
 			log.Fatalf("Could not stop server gracefully: %v", err)
 		}
+
+		// Let queued work finish before closing the database
+		if err := rt.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown failed: %v", err)
+		}
 	}
 }
@@ -0,0 +1,84 @@
+// Package admin provides the HTTP API operators use to manage the bot
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// API serves the admin endpoints under /admin/. Every request must carry the
+// admin token as a bearer token.
+type API struct {
+	store    db.Store
+	settings *db.Settings
+	token    string
+	mux      *http.ServeMux
+}
+
+// FromConfig creates the API from ADMIN_TOKEN, or returns nil when no token
+// is set and the API is off. Settings changed through the API go through
+// settings, which should be the bot's own so its cache sees them.
+func FromConfig(cfg *config.Config, store db.Store, settings *db.Settings) *API {
+	if cfg.AdminToken == "" {
+		return nil
+	}
+	return New(store, settings, cfg.AdminToken)
+}
+
+// New creates the API for the given token, which must not be empty
+func New(store db.Store, settings *db.Settings, token string) *API {
+	a := &API{store: store, settings: settings, token: token, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /admin/audit", a.listAudit)
+	a.mux.HandleFunc("GET /admin/audit/verify", a.verifyAudit)
+	a.mux.HandleFunc("GET /admin/reviews", a.listReviews)
+	a.mux.HandleFunc("GET /admin/reviews/{id}", a.getReview)
+	a.mux.HandleFunc("PUT /admin/reviews/{id}", a.putReview)
+	a.mux.HandleFunc("GET /admin/settings", a.listSettingDefs)
+	a.mux.HandleFunc("GET /admin/settings/{scope}/{channel}/{subject}", a.getSettings)
+	a.mux.HandleFunc("PUT /admin/settings/{scope}/{channel}/{subject}/{key}", a.putSetting)
+	a.mux.HandleFunc("DELETE /admin/settings/{scope}/{channel}/{subject}/{key}", a.deleteSetting)
+	return a
+}
+
+// ServeHTTP checks the token and routes the request
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Missing or invalid admin token", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether the request carries the admin token
+func (a *API) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// actor names who makes a request in the audit log: the operator named in
+// the request body, else the X-Admin-Actor header, else admin
+func actor(r *http.Request, named string) string {
+	if named != "" {
+		return named
+	}
+	if header := r.Header.Get("X-Admin-Actor"); header != "" {
+		return header
+	}
+	return "admin"
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding admin response: %v", err)
+	}
+}
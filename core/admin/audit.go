@@ -0,0 +1,79 @@
+// Package admin provides the HTTP API operators use to manage the bot
+package admin
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// maxAuditPage limits the audit entries returned at once
+const maxAuditPage = 500
+
+// auditPage is the response of the audit log listing
+type auditPage struct {
+	Entries []db.AuditEntry `json:"entries"`
+}
+
+// listAudit returns the newest audit entries, filtered by actor, action,
+// target, from and to
+func (a *API) listAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = min(filter.Limit, maxAuditPage)
+	}
+
+	entries, err := a.store.ListAudit(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error listing the audit log: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []db.AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, auditPage{Entries: entries})
+}
+
+// verifyAudit checks the hash chain of the audit log
+func (a *API) verifyAudit(w http.ResponseWriter, r *http.Request) {
+	result, err := a.store.VerifyAudit(r.Context())
+	if err != nil {
+		log.Printf("Error verifying the audit log: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseTime parses an RFC 3339 time or a date, zero when empty
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
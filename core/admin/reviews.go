@@ -0,0 +1,182 @@
+// Package admin provides the HTTP API operators use to manage the bot
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// Review queue page sizes
+const (
+	defaultReviewPage = 20
+	maxReviewPage     = 100
+)
+
+// maxReviewBody limits the size of a submitted review
+const maxReviewBody = 1 << 20
+
+// reviewPage is the response of the review queue listing
+type reviewPage struct {
+	Items       []db.InteractionRecord `json:"items"`
+	NextAfterID int64                  `json:"next_after_id,omitempty"` // pass as after for the next page
+	Total       int                    `json:"total"`                   // interactions matching the filter
+}
+
+// reviewDetail is one interaction with all the feedback it received
+type reviewDetail struct {
+	Interaction *db.InteractionRecord `json:"interaction"`
+	Feedback    []db.FeedbackRecord   `json:"feedback"`
+}
+
+// reviewRequest is the body of a submitted review
+type reviewRequest struct {
+	Status            string `json:"status"`
+	CorrectedResponse string `json:"corrected_response"`
+	Reviewer          string `json:"reviewer"`
+	Note              string `json:"note"`
+}
+
+// listReviews pages through the interactions with a review status, pending
+// by default, in ID order
+func (a *API) listReviews(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		status = db.ReviewPending
+	}
+	review, err := db.ParseReviewFilter(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := db.InteractionFilter{
+		Channel:       query.Get("channel"),
+		ChatID:        query.Get("chat"),
+		Model:         query.Get("model"),
+		PromptVersion: query.Get("prompt_version"),
+		Review:        review,
+	}
+
+	page := db.Page{Limit: defaultReviewPage}
+	if after := query.Get("after"); after != "" {
+		if page.AfterID, err = strconv.ParseInt(after, 10, 64); err != nil || page.AfterID < 0 {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		page.Limit = min(page.Limit, maxReviewPage)
+	}
+
+	result, err := a.store.ListInteractions(r.Context(), filter, page)
+	if err != nil {
+		log.Printf("Error listing review queue: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	total, err := a.store.CountInteractions(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error counting review queue: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := reviewPage{Items: result.Items, NextAfterID: result.NextAfterID, Total: total}
+	if resp.Items == nil {
+		resp.Items = []db.InteractionRecord{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getReview returns an interaction with its review and feedback
+func (a *API) getReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := interactionID(w, r)
+	if !ok {
+		return
+	}
+
+	rec, err := a.store.GetInteraction(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Interaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading interaction %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	feedback, err := a.store.ListFeedback(r.Context(), []int64{id})
+	if err != nil {
+		log.Printf("Error reading feedback of interaction %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if feedback == nil {
+		feedback = []db.FeedbackRecord{}
+	}
+	writeJSON(w, http.StatusOK, reviewDetail{Interaction: rec, Feedback: feedback})
+}
+
+// putReview stores the verdict on an interaction, replacing any earlier one
+func (a *API) putReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := interactionID(w, r)
+	if !ok {
+		return
+	}
+
+	var req reviewRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReviewBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Invalid review: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rev := &db.Review{
+		InteractionID:     id,
+		Status:            req.Status,
+		CorrectedResponse: req.CorrectedResponse,
+		Reviewer:          req.Reviewer,
+		Note:              req.Note,
+	}
+	if err := rev.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := a.store.GetInteraction(r.Context(), id)
+	if err == nil {
+		err = a.store.SetReview(r.Context(), rev)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Interaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing review of interaction %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := a.store.AppendAudit(r.Context(), db.ReviewEvent(actor(r, rev.Reviewer), rec.Review, rev)); err != nil {
+		log.Printf("Failed to record the review of interaction %d in the audit log: %v", id, err)
+	}
+	writeJSON(w, http.StatusOK, rev)
+}
+
+// interactionID parses the {id} path value, answering 400 when it is invalid
+func interactionID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid interaction ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
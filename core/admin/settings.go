@@ -0,0 +1,124 @@
+// Package admin provides the HTTP API operators use to manage the bot
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// maxSettingBody limits the size of a submitted setting
+const maxSettingBody = 64 << 10
+
+// settingDefs is the response listing the known settings
+type settingDefs struct {
+	Settings []db.SettingDef `json:"settings"`
+}
+
+// subjectSettings are the settings of one chat or sender
+type subjectSettings struct {
+	Subject   db.SettingSubject  `json:"subject"`
+	Stored    []db.SettingRecord `json:"stored"`
+	Effective db.Values          `json:"effective"` // stored values over the defaults
+}
+
+// settingRequest is the body of a changed setting
+type settingRequest struct {
+	Value     string `json:"value"`
+	UpdatedBy string `json:"updated_by"`
+}
+
+// listSettingDefs returns the known settings with their types and defaults
+func (a *API) listSettingDefs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, settingDefs{Settings: a.settings.Defs()})
+}
+
+// getSettings returns the stored and effective settings of a chat or sender
+func (a *API) getSettings(w http.ResponseWriter, r *http.Request) {
+	subject, ok := settingSubject(w, r)
+	if !ok {
+		return
+	}
+
+	stored, err := a.store.ListSettings(r.Context(), subject)
+	if err != nil {
+		log.Printf("Error reading settings of %s %s: %v", subject.Scope, subject.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	effective, err := a.settings.Resolve(r.Context(), subject)
+	if err != nil {
+		log.Printf("Error resolving settings of %s %s: %v", subject.Scope, subject.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil {
+		stored = []db.SettingRecord{}
+	}
+	writeJSON(w, http.StatusOK, subjectSettings{Subject: subject, Stored: stored, Effective: effective})
+}
+
+// putSetting validates and stores a setting of a chat or sender
+func (a *API) putSetting(w http.ResponseWriter, r *http.Request) {
+	subject, ok := settingSubject(w, r)
+	if !ok {
+		return
+	}
+
+	var req settingRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Invalid setting: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := a.settings.Set(r.Context(), subject, r.PathValue("key"), req.Value, actor(r, req.UpdatedBy))
+	if errors.Is(err, db.ErrInvalidSetting) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing setting %s of %s %s: %v", r.PathValue("key"), subject.Scope, subject.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// deleteSetting removes a setting of a chat or sender so its default applies again
+func (a *API) deleteSetting(w http.ResponseWriter, r *http.Request) {
+	subject, ok := settingSubject(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.settings.Delete(r.Context(), subject, r.PathValue("key"), actor(r, ""))
+	if errors.Is(err, db.ErrInvalidSetting) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Setting not set", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting setting %s of %s %s: %v", r.PathValue("key"), subject.Scope, subject.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// settingSubject parses the {scope}, {channel} and {subject} path values,
+// answering 400 when they are invalid
+func settingSubject(w http.ResponseWriter, r *http.Request) (db.SettingSubject, bool) {
+	subject := db.SettingSubject{Scope: r.PathValue("scope"), Channel: r.PathValue("channel"), ID: r.PathValue("subject")}
+	if err := subject.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return subject, false
+	}
+	return subject, true
+}
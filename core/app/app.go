@@ -0,0 +1,160 @@
+// Package app wires the bot together with its store and background services
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang-llm-sqlite-bot/core/admin"
+	"golang-llm-sqlite-bot/core/backup"
+	"golang-llm-sqlite-bot/core/bot"
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/embedding"
+	"golang-llm-sqlite-bot/core/experiment"
+	"golang-llm-sqlite-bot/core/llm"
+	"golang-llm-sqlite-bot/core/retention"
+	"golang-llm-sqlite-bot/core/writebehind"
+)
+
+// Runtime is a started bot with the services it runs on
+type Runtime struct {
+	Store      *db.SQLStore
+	Dispatcher *bot.Dispatcher
+	Writer     *writebehind.Writer // nil when exchanges are recorded synchronously
+	Settings   *db.Settings
+	Admin      *admin.API // nil when ADMIN_TOKEN is not set
+	Bot        *bot.Bot
+
+	stop     context.CancelFunc // stops the dispatcher workers and background services
+	services sync.WaitGroup     // the running background services
+}
+
+// Start opens the database and starts the LLM worker pool, the write-behind
+// writer and the retention janitor, backup scheduler and embeddings populator
+// enabled in cfg, and creates the bot on top of them. The services run until
+// Shutdown is called or ctx is cancelled.
+func Start(ctx context.Context, cfg *config.Config) (*Runtime, error) {
+	if cfg.GroqAPIKey == "" {
+		return nil, errors.New("GROQ_API_KEY environment variable is required")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("initializing database: %w", err)
+	}
+	rt, err := newRuntime(cfg, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	// The services are only started once everything could be created
+	runCtx, stop := context.WithCancel(ctx)
+	rt.stop = stop
+	rt.Dispatcher.Start(runCtx)
+	if rt.Writer != nil {
+		rt.Writer.Start()
+	}
+	if janitor, _ := retention.FromConfig(cfg, store); janitor != nil {
+		rt.run(func() { janitor.Run(runCtx, cfg.RetentionInterval) })
+	}
+	if scheduler := backup.FromConfig(cfg, store); scheduler != nil {
+		rt.run(func() { scheduler.Run(runCtx, cfg.BackupInterval) })
+	}
+	if populator, _ := embedding.FromConfig(cfg, store); populator != nil {
+		rt.run(func() { populator.Run(runCtx, cfg.EmbeddingsInterval) })
+	}
+	return rt, nil
+}
+
+// run starts a background service that Shutdown waits for
+func (rt *Runtime) run(service func()) {
+	rt.services.Add(1)
+	go func() {
+		defer rt.services.Done()
+		service()
+	}()
+}
+
+// newRuntime creates the bot and its services without starting them, and
+// checks the configuration of the services started later
+func newRuntime(cfg *config.Config, store *db.SQLStore) (*Runtime, error) {
+	if _, err := retention.FromConfig(cfg, store); err != nil {
+		return nil, fmt.Errorf("invalid retention rules: %w", err)
+	}
+	if _, err := embedding.FromConfig(cfg, store); err != nil {
+		return nil, fmt.Errorf("invalid embeddings settings: %w", err)
+	}
+
+	writer, err := writebehind.FromConfig(cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("opening the write journal: %w", err)
+	}
+
+	var experiments *experiment.Set
+	if cfg.ExperimentsFile != "" {
+		if experiments, err = experiment.Load(cfg.ExperimentsFile, cfg); err != nil {
+			return nil, fmt.Errorf("loading experiments: %w", err)
+		}
+	}
+
+	// Chat and sender settings, shared with the admin API
+	settings, err := db.SettingsFromConfig(cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid setting defaults: %w", err)
+	}
+
+	rt := &Runtime{
+		Store:      store,
+		Dispatcher: bot.NewDispatcher(cfg),
+		Writer:     writer,
+		Settings:   settings,
+		Admin:      admin.FromConfig(cfg, store, settings),
+	}
+	rt.Bot = bot.NewBot(llm.NewGroqClient(cfg), store,
+		bot.WithDispatcher(rt.Dispatcher),
+		bot.WithAdmins(cfg.AdminJIDs),
+		bot.WithExperiments(experiments),
+		bot.WithWriter(writer),
+		bot.WithSettings(settings),
+		bot.WithAdminAPI(rt.Admin),
+	)
+	return rt, nil
+}
+
+// Shutdown lets queued messages finish, writes the exchanges that are still
+// buffered, stops the background services and waits for them, and closes the
+// database. Work not done when ctx ends is logged and abandoned.
+func (rt *Runtime) Shutdown(ctx context.Context) error {
+	if err := rt.Dispatcher.Shutdown(ctx); err != nil {
+		log.Printf("Dispatcher did not drain in time: %v", err)
+	}
+	if rt.Writer != nil {
+		if err := rt.Writer.Shutdown(ctx); err != nil {
+			log.Printf("Write-behind did not flush in time: %v", err)
+		}
+	}
+	rt.stop()
+
+	// A pass of the janitor, a backup or an embeddings batch finishes its
+	// current statement before it sees the cancellation
+	done := make(chan struct{})
+	go func() {
+		rt.services.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Background services did not stop in time: %v", ctx.Err())
+	}
+
+	if err := rt.Store.Close(); err != nil {
+		return fmt.Errorf("closing database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package backup creates, rotates and restores copies of the SQLite database
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// timestampLayout names backup files so they sort by creation time
+const timestampLayout = "20060102T150405Z"
+
+// Store is the database being backed up
+type Store interface {
+	BackupTo(ctx context.Context, path string) error
+	AppendAudit(ctx context.Context, ev db.AuditEvent) (*db.AuditEntry, error)
+}
+
+// Options controls where backups are written and how many are kept
+type Options struct {
+	Dir  string // directory holding the backups
+	Name string // file name prefix, usually the database file name without extension
+	Gzip bool
+	Keep int // newest backups kept by rotation, 0 keeps all
+}
+
+// OptionsFromConfig builds the options from the BACKUP_* settings
+func OptionsFromConfig(cfg *config.Config) Options {
+	return Options{
+		Dir:  cfg.BackupDir,
+		Name: strings.TrimSuffix(filepath.Base(cfg.DBPath), filepath.Ext(cfg.DBPath)),
+		Gzip: cfg.BackupGzip,
+		Keep: cfg.BackupKeep,
+	}
+}
+
+// Result describes a created backup
+type Result struct {
+	Path     string        `json:"path"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"-"`
+	Removed  []string      `json:"removed,omitempty"` // older backups deleted by rotation
+}
+
+// AuditEvent returns the event recording the backup in the audit log
+func (r *Result) AuditEvent(actor string) db.AuditEvent {
+	return db.AuditEvent{Actor: actor, Action: db.AuditBackupCreate, Target: r.Path, After: r}
+}
+
+// Create writes a timestamped copy of the database to the backup directory
+// and rotates old copies. The file only appears under its final name once it
+// is complete, so an interrupted backup never counts towards rotation.
+func Create(ctx context.Context, store Store, opts Options) (*Result, error) {
+	start := time.Now()
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	path := filepath.Join(opts.Dir, opts.Name+"-"+start.UTC().Format(timestampLayout)+".db")
+	partial := path + ".partial"
+	os.Remove(partial)
+	if err := store.BackupTo(ctx, partial); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+
+	if opts.Gzip {
+		path += ".gz"
+		if err := compress(partial, path+".partial"); err != nil {
+			os.Remove(partial)
+			os.Remove(path + ".partial")
+			return nil, err
+		}
+		os.Remove(partial)
+		partial = path + ".partial"
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return nil, fmt.Errorf("finishing backup: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup size: %w", err)
+	}
+	result := &Result{Path: path, Size: info.Size(), Duration: time.Since(start)}
+
+	if opts.Keep > 0 {
+		if result.Removed, err = rotate(opts); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// List returns the backups in the directory, oldest first
+func List(opts Options) ([]string, error) {
+	entries, err := os.ReadDir(opts.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, opts.Name+"-")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(strings.TrimSuffix(stamp, ".gz"), ".db")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(timestampLayout, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(opts.Dir, name))
+	}
+	// The timestamp is the only part that differs, so names sort by age
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// rotate deletes all but the newest Keep backups
+func rotate(opts Options) ([]string, error) {
+	backups, err := List(opts)
+	if err != nil || len(backups) <= opts.Keep {
+		return nil, err
+	}
+
+	old := backups[:len(backups)-opts.Keep]
+	for _, path := range old {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing old backup: %w", err)
+		}
+	}
+	return old, nil
+}
+
+// compress gzips src into dst
+func compress(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating compressed backup: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return fmt.Errorf("compressing backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("compressing backup: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("syncing backup: %w", err)
+	}
+	return out.Close()
+}
+
+// Scheduler creates backups at a fixed interval inside a long-running process
+type Scheduler struct {
+	store Store
+	opts  Options
+}
+
+// FromConfig creates a scheduler from the BACKUP_* settings, or returns nil
+// when scheduled backups are off
+func FromConfig(cfg *config.Config, store Store) *Scheduler {
+	if cfg.BackupInterval <= 0 || cfg.DBDriver != db.DriverSQLite {
+		return nil
+	}
+	return &Scheduler{store: store, opts: OptionsFromConfig(cfg)}
+}
+
+// Run creates a backup at every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := Create(ctx, s.store, s.opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Scheduled backup failed: %v", err)
+			}
+			continue
+		}
+		log.Printf("Backed up database to %s (%d bytes in %v, %d old backups removed)",
+			result.Path, result.Size, result.Duration.Round(time.Millisecond), len(result.Removed))
+		if _, err := s.store.AppendAudit(ctx, result.AuditEvent(db.ActorBackup)); err != nil {
+			log.Printf("Failed to record the backup in the audit log: %v", err)
+		}
+	}
+}
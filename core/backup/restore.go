@@ -0,0 +1,175 @@
+// Package backup creates, rotates and restores copies of the SQLite database
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// RestoreResult describes a restored backup
+type RestoreResult struct {
+	Source   string            `json:"source"`
+	Check    *db.DatabaseCheck `json:"check"`
+	Previous string            `json:"previous,omitempty"` // where the replaced database was moved, empty if there was none
+
+	// Erasures recorded by the replaced database after the backup was taken,
+	// carried over to the restored one; Reapplied are those that removed data again
+	Erasures  int          `json:"erasures,omitempty"`
+	Reapplied []db.Erasure `json:"reapplied,omitempty"`
+}
+
+// AuditEvent returns the event recording the restore of dbPath in the audit log
+func (r *RestoreResult) AuditEvent(actor, dbPath string) db.AuditEvent {
+	return db.AuditEvent{Actor: actor, Action: db.AuditBackupRestore, Target: dbPath, After: r}
+}
+
+// Restore replaces the database at cfg.DBPath with a backup after checking
+// its integrity and schema version. Senders erased since the backup was taken
+// are erased from it again before it is put in place. The replaced database
+// is kept next to it. Nothing may have the database open while it is restored.
+func Restore(ctx context.Context, cfg *config.Config, src string) (*RestoreResult, error) {
+	dbPath := cfg.DBPath
+
+	// Erasures must survive the restore, so a database whose erasures cannot
+	// be read is not replaced
+	var erasures []db.Erasure
+	if _, err := os.Stat(dbPath); err == nil {
+		if erasures, err = db.ErasuresIn(ctx, dbPath); err != nil {
+			return nil, fmt.Errorf("reading the erasures of the current database, move it away to restore without them: %w", err)
+		}
+	}
+
+	// Work on a copy next to the database so the final rename is atomic and
+	// the backup itself is never modified
+	staged := dbPath + ".restore"
+	os.Remove(staged)
+	if err := stage(src, staged); err != nil {
+		os.Remove(staged)
+		return nil, err
+	}
+
+	check, err := db.CheckDatabase(ctx, staged)
+	if err != nil {
+		err = fmt.Errorf("checking %s: %w", src, err)
+	}
+	if err == nil && !check.OK() {
+		err = fmt.Errorf("backup failed the integrity check: %s", strings.Join(check.Problems, "; "))
+	}
+	if err == nil && check.Version > check.Latest {
+		err = fmt.Errorf("%w: backup is at version %d, binary supports up to %d", db.ErrSchemaTooNew, check.Version, check.Latest)
+	}
+	if err != nil {
+		os.Remove(staged)
+		return nil, err
+	}
+
+	result := &RestoreResult{Source: src, Check: check}
+	if result.Erasures, result.Reapplied, err = reapplyErasures(ctx, cfg, staged, erasures); err != nil {
+		os.Remove(staged)
+		return nil, fmt.Errorf("re-applying erasures to the backup: %w", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		result.Previous = unusedPath(dbPath + ".before-restore-" + time.Now().UTC().Format(timestampLayout))
+		if err := os.Rename(dbPath, result.Previous); err != nil {
+			os.Remove(staged)
+			return nil, fmt.Errorf("moving current database aside: %w", err)
+		}
+	}
+
+	// Journal files belong to the replaced database and would corrupt the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + suffix); err != nil {
+			continue
+		}
+		target := dbPath + suffix + ".stale"
+		if result.Previous != "" {
+			target = result.Previous + suffix
+		}
+		if err := os.Rename(dbPath+suffix, target); err != nil {
+			os.Remove(staged)
+			return nil, fmt.Errorf("moving %s aside: %w", dbPath+suffix, err)
+		}
+	}
+
+	if err := os.Rename(staged, dbPath); err != nil {
+		return nil, fmt.Errorf("moving restored database into place: %w", err)
+	}
+	return result, nil
+}
+
+// reapplyErasures carries the erasures over to the staged database, which is
+// migrated on the way, and returns how many were new to it and those that
+// removed data again
+func reapplyErasures(ctx context.Context, cfg *config.Config, staged string, erasures []db.Erasure) (int, []db.Erasure, error) {
+	if len(erasures) == 0 {
+		return 0, nil, nil
+	}
+	stagedCfg := *cfg
+	stagedCfg.DBPath = staged
+	store, err := db.NewSQLiteStore(&stagedCfg)
+	if err != nil {
+		return 0, nil, err
+	}
+	copied, reapplied, err := store.ReapplyErasures(ctx, erasures)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return copied, reapplied, err
+}
+
+// unusedPath returns path, or path with a counter appended if it exists
+func unusedPath(path string) string {
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", path, i)
+	}
+}
+
+// stage copies a backup to dst, decompressing it when it is gzipped
+func stage(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening backup: %w", err)
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if strings.HasSuffix(src, ".gz") {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("reading compressed backup: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("staging backup: %w", err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("backup is truncated or damaged: %w", err)
+		}
+		return fmt.Errorf("staging backup: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("syncing staged backup: %w", err)
+	}
+	return out.Close()
+}
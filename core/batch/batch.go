@@ -0,0 +1,366 @@
+// Package batch provides offline processing of prompt files through the LLM
+package batch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// maxLineSize bounds the size of a single JSONL input line
+const maxLineSize = 1024 * 1024
+
+// Options controls how a batch is processed
+type Options struct {
+	Concurrency int     // number of prompts processed in parallel
+	RateLimit   float64 // maximum requests per second, 0 for unlimited
+	Resume      bool    // skip lines already present in the output file
+	RetryFailed bool    // when resuming, process lines again whose results are errors
+}
+
+// Result is written to the output file for every processed input line
+type Result struct {
+	Line             int    `json:"line"`
+	Prompt           string `json:"prompt"`
+	Expected         string `json:"expected,omitempty"`
+	Completion       string `json:"completion,omitempty"`
+	Model            string `json:"model,omitempty"`
+	LatencyMS        int64  `json:"latency_ms"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	Error            string `json:"error,omitempty"`
+}
+
+// Summary reports the outcome of a batch run
+type Summary struct {
+	Total     int
+	Skipped   int
+	Succeeded int
+	Failed    int
+}
+
+// job is a single input line waiting to be processed
+type job struct {
+	line  int
+	entry db.Interaction
+	err   error
+}
+
+// Runner processes JSONL prompt files with an LLM client
+type Runner struct {
+	client llm.Client
+	opts   Options
+}
+
+// NewRunner creates a batch runner for the given client
+func NewRunner(client llm.Client, opts Options) *Runner {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &Runner{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// Run reads prompts from inPath and appends one result per line to outPath.
+// The output file doubles as the checkpoint: when resuming, lines that
+// already have a result are skipped, so an interrupted run can be restarted
+// with the same arguments. With RetryFailed, the error results are removed
+// from the output first and their lines are processed again, so the output
+// keeps one result per line.
+func (r *Runner) Run(ctx context.Context, inPath, outPath string) (*Summary, error) {
+	done := make(map[int]bool)
+	if r.opts.Resume {
+		if r.opts.RetryFailed {
+			if err := dropFailed(outPath); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if done, err = readCheckpoint(outPath); err != nil {
+			return nil, err
+		}
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening input: %w", err)
+	}
+	defer in.Close()
+
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if !r.opts.Resume {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(outPath, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening output: %w", err)
+	}
+	defer out.Close()
+
+	if err := terminateLastLine(out); err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{}
+	var mu sync.Mutex
+	record := func(res *Result) error {
+		line, err := json.Marshal(res)
+		if err != nil {
+			return fmt.Errorf("encoding result: %w", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := fmt.Fprintln(out, string(line)); err != nil {
+			return fmt.Errorf("writing result: %w", err)
+		}
+		if res.Error != "" {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+		return nil
+	}
+
+	// A failed write stops the reader and the other workers
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan job)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		readErr <- r.readJobs(runCtx, in, done, jobs, summary, &mu)
+	}()
+
+	var limiter <-chan time.Time
+	if r.opts.RateLimit > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.opts.RateLimit))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	writeErrs := make(chan error, r.opts.Concurrency)
+	for i := 0; i < r.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if limiter != nil && j.err == nil {
+					select {
+					case <-limiter:
+					case <-runCtx.Done():
+						continue
+					}
+				}
+
+				res := r.process(runCtx, j)
+				// Results of interrupted requests are not checkpointed so they run again on resume
+				if runCtx.Err() != nil {
+					continue
+				}
+				if err := record(res); err != nil {
+					writeErrs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(writeErrs)
+
+	if err := <-writeErrs; err != nil {
+		return summary, err
+	}
+	if err := <-readErr; err != nil {
+		return summary, err
+	}
+	return summary, ctx.Err()
+}
+
+// readJobs feeds unprocessed input lines to the workers
+func (r *Runner) readJobs(ctx context.Context, in *os.File, done map[int]bool, jobs chan<- job, summary *Summary, mu *sync.Mutex) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		mu.Lock()
+		summary.Total++
+		if done[line] {
+			summary.Skipped++
+			mu.Unlock()
+			continue
+		}
+		mu.Unlock()
+
+		j := job{line: line}
+		if err := json.Unmarshal([]byte(text), &j.entry); err != nil {
+			j.err = fmt.Errorf("invalid input line: %w", err)
+		} else if strings.TrimSpace(j.entry.Prompt) == "" {
+			j.err = errors.New("empty prompt")
+		}
+
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	return nil
+}
+
+// process sends a single prompt to the LLM and measures it
+func (r *Runner) process(ctx context.Context, j job) *Result {
+	res := &Result{
+		Line:     j.line,
+		Prompt:   j.entry.Prompt,
+		Expected: j.entry.Completion,
+	}
+	if j.err != nil {
+		res.Error = j.err.Error()
+		return res
+	}
+
+	start := time.Now()
+	completion, err := r.client.Complete(ctx, j.entry.Prompt)
+	res.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Completion = completion.Content
+	res.Model = completion.Model
+	res.PromptTokens = completion.Usage.PromptTokens
+	res.CompletionTokens = completion.Usage.CompletionTokens
+	res.TotalTokens = completion.Usage.TotalTokens
+	return res
+}
+
+// readCheckpoint collects the input line numbers that already have results
+func readCheckpoint(path string) (map[int]bool, error) {
+	done := make(map[int]bool)
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var res Result
+		// A partially written last line from a crash is ignored and reprocessed
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil || res.Line == 0 {
+			continue
+		}
+		done[res.Line] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	return done, nil
+}
+
+// dropFailed rewrites the output without its error results and partially
+// written lines. The file is replaced in one rename, so an interrupted
+// rewrite leaves the previous output in place.
+func dropFailed(path string) error {
+	in, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening checkpoint: %w", err)
+	}
+	defer in.Close()
+
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating output: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var res Result
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil || res.Line == 0 || res.Error != "" {
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("syncing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replacing output: %w", err)
+	}
+	return nil
+}
+
+// terminateLastLine makes sure new results do not get appended to a line
+// that was cut off when a previous run was killed
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("checking output: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return fmt.Errorf("reading output: %w", err)
+	}
+	if last[0] != '\n' {
+		if _, err := file.WriteString("\n"); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Package bot provides the main bot functionality
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+)
+
+// Priority selects the lane a task is queued in
+type Priority int
+
+const (
+	// PriorityLow is used for group chats
+	PriorityLow Priority = iota
+	// PriorityHigh is used for admins and private chats
+	PriorityHigh
+
+	numPriorities
+)
+
+// String returns the lane name used in metrics
+func (p Priority) String() string {
+	if p == PriorityHigh {
+		return "high"
+	}
+	return "low"
+}
+
+var (
+	// ErrQueueFull is returned when the dispatcher queue depth limit is reached
+	ErrQueueFull = errors.New("dispatcher queue is full")
+	// ErrDispatcherClosed is returned when submitting to a stopped dispatcher
+	ErrDispatcherClosed = errors.New("dispatcher is closed")
+)
+
+// Task is a unit of work executed by a dispatcher worker
+type Task struct {
+	ChatID   string
+	Priority Priority
+	Run      func(ctx context.Context)
+
+	enqueuedAt time.Time
+}
+
+// chatQueue holds the pending tasks of a single chat. A chat is scheduled
+// while it sits in a lane or one of its tasks is running, which guarantees
+// that at most one task per chat executes at a time.
+type chatQueue struct {
+	tasks     []*Task
+	scheduled bool
+}
+
+// laneMetrics accumulates wait time statistics for a priority lane
+type laneMetrics struct {
+	processed uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// LaneStats reports the state of a single priority lane
+type LaneStats struct {
+	Waiting   int    `json:"waiting"`
+	Processed uint64 `json:"processed"`
+	AvgWaitMS int64  `json:"avg_wait_ms"`
+	MaxWaitMS int64  `json:"max_wait_ms"`
+}
+
+// DispatcherStats is a point-in-time snapshot of dispatcher metrics
+type DispatcherStats struct {
+	Workers  int                  `json:"workers"`
+	Running  int                  `json:"running"`
+	Queued   int                  `json:"queued"`
+	MaxDepth int                  `json:"max_depth"`
+	Rejected uint64               `json:"rejected"`
+	Lanes    map[string]LaneStats `json:"lanes"`
+}
+
+// Dispatcher runs tasks on a bounded pool of workers. Tasks of the same chat
+// run strictly in submission order, and chats waiting in the high priority
+// lane are always picked before chats in the low priority lane.
+type Dispatcher struct {
+	workers  int
+	maxDepth int
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    [numPriorities][]string
+	chats    map[string]*chatQueue
+	depth    int
+	running  int
+	rejected uint64
+	metrics  [numPriorities]laneMetrics
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher sized from the configuration
+func NewDispatcher(cfg *config.Config) *Dispatcher {
+	workers := cfg.WorkerCount
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &Dispatcher{
+		workers:  workers,
+		maxDepth: cfg.QueueDepth,
+		timeout:  cfg.TaskTimeout,
+		chats:    make(map[string]*chatQueue),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// Start launches the worker goroutines. Tasks receive a context derived from ctx.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.worker(ctx)
+	}
+	log.Printf("Dispatcher started with %d workers (queue depth %d)", d.workers, d.maxDepth)
+}
+
+// Submit queues a task. It returns ErrQueueFull when the queue depth limit is
+// reached so callers can apply backpressure to their own clients.
+func (d *Dispatcher) Submit(task Task) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+	if d.maxDepth > 0 && d.depth >= d.maxDepth {
+		d.rejected++
+		return ErrQueueFull
+	}
+
+	t := task
+	t.enqueuedAt = time.Now()
+
+	cq, ok := d.chats[t.ChatID]
+	if !ok {
+		cq = &chatQueue{}
+		d.chats[t.ChatID] = cq
+	}
+	cq.tasks = append(cq.tasks, &t)
+	d.depth++
+
+	if !cq.scheduled {
+		cq.scheduled = true
+		d.lanes[t.Priority] = append(d.lanes[t.Priority], t.ChatID)
+		d.cond.Signal()
+	}
+	return nil
+}
+
+// Shutdown stops accepting tasks and waits for queued tasks to finish or ctx to expire
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the dispatcher metrics
+func (d *Dispatcher) Stats() DispatcherStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := DispatcherStats{
+		Workers:  d.workers,
+		Running:  d.running,
+		Queued:   d.depth,
+		MaxDepth: d.maxDepth,
+		Rejected: d.rejected,
+		Lanes:    make(map[string]LaneStats, numPriorities),
+	}
+
+	waiting := make(map[Priority]int, numPriorities)
+	for _, cq := range d.chats {
+		for _, t := range cq.tasks {
+			waiting[t.Priority]++
+		}
+	}
+
+	for p := Priority(0); p < numPriorities; p++ {
+		m := d.metrics[p]
+		lane := LaneStats{
+			Waiting:   waiting[p],
+			Processed: m.processed,
+			MaxWaitMS: m.maxWait.Milliseconds(),
+		}
+		if m.processed > 0 {
+			lane.AvgWaitMS = (m.totalWait / time.Duration(m.processed)).Milliseconds()
+		}
+		stats.Lanes[p.String()] = lane
+	}
+	return stats
+}
+
+// next blocks until a task is available and claims it. It returns nil once
+// the dispatcher is closed and fully drained.
+func (d *Dispatcher) next() (string, *Task) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(d.lanes[p]) == 0 {
+				continue
+			}
+			chatID := d.lanes[p][0]
+			d.lanes[p] = d.lanes[p][1:]
+
+			cq := d.chats[chatID]
+			task := cq.tasks[0]
+			cq.tasks = cq.tasks[1:]
+			d.depth--
+			d.running++
+
+			wait := time.Since(task.enqueuedAt)
+			m := &d.metrics[task.Priority]
+			m.processed++
+			m.totalWait += wait
+			if wait > m.maxWait {
+				m.maxWait = wait
+			}
+			return chatID, task
+		}
+
+		if d.closed {
+			return "", nil
+		}
+		d.cond.Wait()
+	}
+}
+
+// done releases the chat claimed by a worker and reschedules its next task
+func (d *Dispatcher) done(chatID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.running--
+	cq := d.chats[chatID]
+	if len(cq.tasks) == 0 {
+		delete(d.chats, chatID)
+		if d.closed {
+			d.cond.Broadcast()
+		}
+		return
+	}
+
+	// The chat is requeued in the lane of its oldest pending task
+	next := cq.tasks[0].Priority
+	d.lanes[next] = append(d.lanes[next], chatID)
+	d.cond.Signal()
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		chatID, task := d.next()
+		if task == nil {
+			return
+		}
+		d.run(ctx, task)
+		d.done(chatID)
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context, task *Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Dispatcher task for chat %s panicked: %v", task.ChatID, r)
+		}
+	}()
+
+	taskCtx := ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		taskCtx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	task.Run(taskCtx)
+}
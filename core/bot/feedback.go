@@ -0,0 +1,116 @@
+// Package bot provides the main bot functionality
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// Feedback is a rating of one of the bot's replies given in a chat
+type Feedback struct {
+	Channel  string
+	ChatID   string
+	SenderID string
+	ReplyID  string // external ID of the rated reply, empty for the latest reply in the chat
+	Rating   int    // db.RatingPositive or db.RatingNegative, 0 takes back an earlier reaction
+	Source   string // db.FeedbackReaction or db.FeedbackCommand
+	Comment  string
+}
+
+// RecordFeedback links feedback to the interaction behind the rated reply and
+// stores it in place of the sender's earlier reaction or command. It
+// returns db.ErrNotFound when the rated message is not a reply of the bot.
+func (b *Bot) RecordFeedback(ctx context.Context, fb Feedback) (*db.FeedbackRecord, error) {
+	reply, err := b.findReply(ctx, db.ChatRef{Channel: fb.Channel, ExternalID: fb.ChatID}, fb.ReplyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &db.FeedbackRecord{
+		InteractionID: reply.InteractionID,
+		Rating:        fb.Rating,
+		Source:        fb.Source,
+		SenderID:      fb.SenderID,
+		Comment:       fb.Comment,
+	}
+	if err := b.store.ReplaceFeedback(ctx, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// findReply looks up a reply of the bot, writing the exchanges still waiting
+// in the write-behind queue first when the reply is not stored yet
+func (b *Bot) findReply(ctx context.Context, chat db.ChatRef, replyID string) (*db.MessageRecord, error) {
+	// Without an ID the latest reply is wanted, which may be the queued one
+	if replyID != "" || b.writer == nil {
+		reply, err := b.store.FindReply(ctx, chat, replyID)
+		if b.writer == nil || !errors.Is(err, db.ErrNotFound) {
+			return reply, err
+		}
+	}
+
+	if err := b.writer.Flush(ctx); err != nil {
+		return nil, fmt.Errorf("writing queued exchanges: %w", err)
+	}
+	return b.store.FindReply(ctx, chat, replyID)
+}
+
+// commandFeedback records a /good or /bad command and returns the answer to
+// send back. A command quoting a reply rates that reply, otherwise the latest one.
+func (b *Bot) commandFeedback(ctx context.Context, in Incoming, rating int, comment string) (string, error) {
+	_, err := b.RecordFeedback(ctx, Feedback{
+		Channel:  in.Channel,
+		ChatID:   in.ChatID,
+		SenderID: in.SenderID,
+		ReplyID:  in.ReplyToID,
+		Rating:   rating,
+		Source:   db.FeedbackCommand,
+		Comment:  comment,
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return "There is no answer to rate yet.", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("recording feedback: %w", err)
+	}
+	return "Thanks for the feedback!", nil
+}
+
+// parseFeedbackCommand recognizes /good and /bad, optionally followed by a comment
+func parseFeedbackCommand(text string) (rating int, comment string, ok bool) {
+	command, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	switch strings.ToLower(command) {
+	case "/good":
+		rating = db.RatingPositive
+	case "/bad":
+		rating = db.RatingNegative
+	default:
+		return 0, "", false
+	}
+	return rating, strings.TrimSpace(rest), true
+}
+
+// reactionRating maps a reaction emoji to a rating. Thumbs up and down count
+// in any skin tone; other emoji, and a removed reaction, rate nothing.
+func reactionRating(emoji string) int {
+	base := strings.Map(func(r rune) rune {
+		if r == '\uFE0F' || (r >= 0x1F3FB && r <= 0x1F3FF) {
+			return -1
+		}
+		return r
+	}, emoji)
+
+	switch base {
+	case "\U0001F44D":
+		return db.RatingPositive
+	case "\U0001F44E":
+		return db.RatingNegative
+	default:
+		return 0
+	}
+}
@@ -4,38 +4,270 @@ package bot
 import (
 	"context"
 	"fmt"
+	"log"
+	"os/user"
+	"time"
 
+	"golang-llm-sqlite-bot/core/admin"
+	"golang-llm-sqlite-bot/core/config"
 	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/experiment"
 	"golang-llm-sqlite-bot/core/llm"
+	"golang-llm-sqlite-bot/core/writebehind"
 )
 
+// cliChatID identifies the local chat of the CLI interface
+const cliChatID = "cli"
+
+// Incoming is a message received on one of the bot's interfaces
+type Incoming struct {
+	Channel    string
+	ChatID     string
+	IsGroup    bool
+	SenderID   string
+	SenderName string
+	Text       string
+	MessageID  string
+	ReplyToID  string
+	Quoted     string
+	SentAt     time.Time
+}
+
+// Reply is the bot's answer to an incoming message. It is recorded once the
+// delivery outcome is known.
+type Reply struct {
+	Text     string
+	exchange *db.Exchange
+}
+
 // Bot handles chat interactions using the LLM service
 type Bot struct {
-	llm   llm.Client
-	store db.Store
+	llm         llm.Client
+	store       db.Store
+	dispatcher  *Dispatcher
+	admins      map[string]bool
+	experiments *experiment.Set
+	writer      *writebehind.Writer
+	adminAPI    *admin.API
+	settings    *db.Settings
+}
+
+// Option configures optional bot dependencies
+type Option func(*Bot)
+
+// WithDispatcher makes webhook messages run on the dispatcher's worker pool
+// instead of inside the HTTP handler
+func WithDispatcher(d *Dispatcher) Option {
+	return func(b *Bot) {
+		b.dispatcher = d
+	}
+}
+
+// WithAdmins marks the given JIDs as admins whose messages are prioritized
+func WithAdmins(jids []string) Option {
+	return func(b *Bot) {
+		for _, jid := range jids {
+			b.admins[jidUser(jid)] = true
+		}
+	}
+}
+
+// WithExperiments routes chats enrolled in an experiment to their variant's prompt and model
+func WithExperiments(set *experiment.Set) Option {
+	return func(b *Bot) {
+		b.experiments = set
+	}
+}
+
+// WithWriter records exchanges through the write-behind writer instead of
+// writing them on the request path
+func WithWriter(w *writebehind.Writer) Option {
+	return func(b *Bot) {
+		b.writer = w
+	}
+}
+
+// WithAdminAPI serves the admin API from the webhook server
+func WithAdminAPI(api *admin.API) Option {
+	return func(b *Bot) {
+		b.adminAPI = api
+	}
+}
+
+// WithSettings applies chat and sender settings such as the reply language
+// and enables the settings commands
+func WithSettings(s *db.Settings) Option {
+	return func(b *Bot) {
+		b.settings = s
+	}
 }
 
 // NewBot creates a new bot instance with the provided dependencies
-func NewBot(llmClient llm.Client, store db.Store) *Bot {
-	return &Bot{
-		llm:   llmClient,
-		store: store,
+func NewBot(llmClient llm.Client, store db.Store, opts ...Option) *Bot {
+	b := &Bot{
+		llm:    llmClient,
+		store:  store,
+		admins: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	return b
 }
 
-// HandleMessage processes a user message and returns the LLM's response
+// HandleMessage processes a CLI message and returns the LLM's response
 func (b *Bot) HandleMessage(ctx context.Context, input string) (string, error) {
+	in := Incoming{
+		Channel:  db.ChannelCLI,
+		ChatID:   cliChatID,
+		SenderID: localUser(),
+		Text:     input,
+		SentAt:   time.Now(),
+	}
+	return b.Handle(ctx, in)
+}
+
+// Handle processes an incoming message, records it and returns the LLM's
+// response. Chat commands are answered instead, and muted chats only get a notice.
+func (b *Bot) Handle(ctx context.Context, in Incoming) (string, error) {
+	if answer, ok, err := b.command(ctx, in); ok {
+		return answer, err
+	}
+	if b.muted(ctx, in) {
+		return "The bot is muted in this chat, send /set mute off to unmute it.", nil
+	}
+
+	reply, err := b.Respond(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	b.Record(ctx, reply, "")
+	return reply.Text, nil
+}
+
+// command answers the chat commands: /good and /bad rate a reply, while
+// /settings, /set and /unset manage settings. ok is false for other messages.
+func (b *Bot) command(ctx context.Context, in Incoming) (answer string, ok bool, err error) {
+	if rating, comment, ok := parseFeedbackCommand(in.Text); ok {
+		answer, err := b.commandFeedback(ctx, in, rating, comment)
+		return answer, true, err
+	}
+	if cmd, ok := parseSettingsCommand(in.Text); ok {
+		answer, err := b.commandSettings(ctx, in, cmd)
+		return answer, true, err
+	}
+	return "", false, nil
+}
+
+// Respond gets the LLM's response to an incoming message without recording
+// it. A failed LLM call is recorded as a failure right away.
+func (b *Bot) Respond(ctx context.Context, in Incoming) (*Reply, error) {
+	req := llm.Request{Prompt: in.Text}
+	settings := b.settingsFor(ctx, in)
+
+	// Chats enrolled in an experiment use their variant's configuration and
+	// keep it regardless of the persona setting
+	assignment, enrolled := b.experiments.Assign(in.ChatID)
+	if enrolled {
+		req = assignment.Variant.Request
+		req.Prompt = in.Text
+	} else {
+		req.SystemPrompt = config.Personas[settings.String(db.SettingPersona)]
+	}
+	if language := settings.String(db.SettingLanguage); language != "" {
+		req.Instructions = fmt.Sprintf("Always reply in %s.", language)
+	}
+
 	// Send message to LLM
-	resp, err := b.llm.SendMessage(ctx, input)
+	start := time.Now()
+	completion, err := b.llm.CompleteRequest(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("getting LLM response: %w", err)
+		b.recordFailure(ctx, &db.FailureRecord{
+			Channel: in.Channel,
+			Stage:   db.FailureLLM,
+			Model:   req.Model,
+			Error:   err.Error(),
+		})
+		return nil, fmt.Errorf("getting LLM response: %w", err)
+	}
+	latency := time.Since(start)
+
+	ex := &db.Exchange{
+		Chat: db.ChatRef{
+			Channel:    in.Channel,
+			ExternalID: in.ChatID,
+			IsGroup:    in.IsGroup,
+		},
+		Sender: db.ParticipantRef{
+			ExternalID:  in.SenderID,
+			DisplayName: in.SenderName,
+		},
+		Inbound: db.MessageRecord{
+			Content:           in.Text,
+			ExternalID:        in.MessageID,
+			ReplyToExternalID: in.ReplyToID,
+			QuotedContent:     in.Quoted,
+			SentAt:            in.SentAt,
+		},
+		Outbound: db.MessageRecord{
+			Content:           completion.Content,
+			ReplyToExternalID: in.MessageID,
+		},
+		// The interaction keeps the configuration that produced the response
+		Interaction: db.InteractionRecord{
+			Prompt:           in.Text,
+			Response:         completion.Content,
+			SystemPrompt:     completion.SystemPrompt,
+			Model:            completion.Model,
+			Provider:         completion.Provider,
+			Temperature:      completion.Params.Temperature,
+			TopP:             completion.Params.TopP,
+			MaxTokens:        completion.Params.MaxTokens,
+			LatencyMS:        latency.Milliseconds(),
+			PromptTokens:     completion.Usage.PromptTokens,
+			CompletionTokens: completion.Usage.CompletionTokens,
+		},
+	}
+	if enrolled {
+		ex.Interaction.Experiment = assignment.Experiment
+		ex.Interaction.Variant = assignment.Variant.Name
 	}
 
-	// Log the interaction
-	if err := b.store.LogInteraction(ctx, input, resp); err != nil {
+	return &Reply{Text: completion.Content, exchange: ex}, nil
+}
+
+// Record stores the exchange behind a reply. externalID is the ID the channel
+// assigned to the delivered reply, if any.
+func (b *Bot) Record(ctx context.Context, reply *Reply, externalID string) {
+	ex := reply.exchange
+	ex.Outbound.ExternalID = externalID
+	ex.Outbound.SentAt = time.Now()
+
+	if b.writer != nil {
+		b.writer.Enqueue(ex)
+		return
+	}
+	if err := b.store.RecordExchange(ctx, ex); err != nil {
 		// Log error but don't fail the request
-		fmt.Printf("Failed to log interaction: %v\n", err)
+		log.Printf("Failed to record exchange for chat %s: %v", ex.Chat.ExternalID, err)
+	}
+}
+
+// recordFailure stores a failed request for the usage statistics
+func (b *Bot) recordFailure(ctx context.Context, f *db.FailureRecord) {
+	// The request may have failed because ctx expired, which must not keep
+	// the failure from being recorded
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := b.store.RecordFailure(ctx, f); err != nil {
+		log.Printf("Failed to record %s failure: %v", f.Stage, err)
 	}
+}
 
-	return resp, nil
+// localUser names the sender of CLI messages
+func localUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "local"
 }
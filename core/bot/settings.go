@@ -0,0 +1,162 @@
+// Package bot provides the main bot functionality
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// settingsCommand is a parsed /settings, /set or /unset command
+type settingsCommand struct {
+	name  string // settings, set or unset
+	user  bool   // "my" was given, so the sender's own setting is meant
+	key   string
+	value string
+}
+
+// settingsUsage explains the settings commands
+const settingsUsage = "Send /set <setting> <value> to change a setting of this chat, /set my <setting> <value> " +
+	"to change it only for you, or /unset [my] <setting> to go back to the default."
+
+// parseSettingsCommand recognizes /settings, /set [my] <key> <value> and /unset [my] <key>
+func parseSettingsCommand(text string) (settingsCommand, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return settingsCommand{}, false
+	}
+
+	command := strings.ToLower(fields[0])
+	switch command {
+	case "/settings", "/set", "/unset":
+	default:
+		return settingsCommand{}, false
+	}
+	cmd := settingsCommand{name: strings.TrimPrefix(command, "/")}
+	if command == "/settings" {
+		return cmd, true
+	}
+
+	args := fields[1:]
+	if len(args) > 0 && strings.EqualFold(args[0], "my") {
+		cmd.user = true
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		cmd.key = strings.ToLower(args[0])
+		cmd.value = strings.Join(args[1:], " ")
+	}
+	return cmd, true
+}
+
+// commandSettings answers a settings command sent in a chat. Only admins may
+// change the settings of a group chat; everyone may change their own.
+func (b *Bot) commandSettings(ctx context.Context, in Incoming, cmd settingsCommand) (string, error) {
+	if b.settings == nil {
+		return "Settings are not available.", nil
+	}
+	if cmd.name == "settings" {
+		return b.listSettings(ctx, in)
+	}
+	if cmd.key == "" || (cmd.name == "set" && cmd.value == "") {
+		return settingsUsage, nil
+	}
+
+	def, ok := b.settings.Def(cmd.key)
+	if !ok {
+		return fmt.Sprintf("There is no setting %q, send /settings to see them.", cmd.key), nil
+	}
+	subject, whom := db.UserSubject(in.Channel, in.SenderID), "you"
+	if !cmd.user && def.Allows(db.ScopeChat) {
+		if in.IsGroup && !b.admins[jidUser(in.SenderID)] {
+			if def.Allows(db.ScopeUser) {
+				return fmt.Sprintf("Only admins can change the settings of this group, send /%s my %s to change it only for you.", cmd.name, def.Key), nil
+			}
+			return "Only admins can change the settings of this group.", nil
+		}
+		subject, whom = db.ChatSubject(in.Channel, in.ChatID), "this chat"
+	}
+
+	if cmd.name == "unset" {
+		err := b.settings.Delete(ctx, subject, def.Key, b.store.Subjects().Target(in.SenderID))
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Sprintf("%s is not set for %s.", def.Key, whom), nil
+		}
+		if errors.Is(err, db.ErrInvalidSetting) {
+			return err.Error(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("removing setting: %w", err)
+		}
+		return fmt.Sprintf("%s is back to its default for %s.", def.Key, whom), nil
+	}
+
+	rec, err := b.settings.Set(ctx, subject, def.Key, cmd.value, b.store.Subjects().Target(in.SenderID))
+	if errors.Is(err, db.ErrInvalidSetting) {
+		return err.Error(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("storing setting: %w", err)
+	}
+	return fmt.Sprintf("%s is now %s for %s.", def.Key, displayValue(def, rec.Value), whom), nil
+}
+
+// listSettings describes the effective settings of the sender in the chat
+func (b *Bot) listSettings(ctx context.Context, in Incoming) (string, error) {
+	values, err := b.settings.Resolve(ctx, db.ChatSubject(in.Channel, in.ChatID), db.UserSubject(in.Channel, in.SenderID))
+	if err != nil {
+		return "", fmt.Errorf("reading settings: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Settings:\n")
+	for _, def := range b.settings.Defs() {
+		value := values[def.Key]
+		fmt.Fprintf(&sb, "- %s: %s", def.Key, displayValue(def, value.Value))
+		switch value.Scope {
+		case db.ScopeChat:
+			sb.WriteString(" (set for this chat)")
+		case db.ScopeUser:
+			sb.WriteString(" (set for you)")
+		}
+		if def.Kind == db.KindChoice {
+			fmt.Fprintf(&sb, ", one of %s", strings.Join(def.Choices, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(settingsUsage)
+	return sb.String(), nil
+}
+
+// displayValue shows a setting value the way it can be typed back
+func displayValue(def db.SettingDef, value string) string {
+	switch {
+	case def.Kind == db.KindBool && value == "true":
+		return "on"
+	case def.Kind == db.KindBool:
+		return "off"
+	case value == "":
+		return "not set"
+	default:
+		return value
+	}
+}
+
+// settingsFor returns the effective settings of a message's chat and
+// sender. The defaults apply when they cannot be read.
+func (b *Bot) settingsFor(ctx context.Context, in Incoming) db.Values {
+	values, err := b.settings.Resolve(ctx, db.ChatSubject(in.Channel, in.ChatID), db.UserSubject(in.Channel, in.SenderID))
+	if err != nil {
+		log.Printf("Failed to read settings of chat %s: %v", in.ChatID, err)
+	}
+	return values
+}
+
+// muted reports whether the bot is muted in the message's chat
+func (b *Bot) muted(ctx context.Context, in Incoming) bool {
+	return b.settingsFor(ctx, in).Bool(db.SettingMute)
+}
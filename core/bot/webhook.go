@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
+
+	"golang-llm-sqlite-bot/core/db"
 )
 
 func init() {
@@ -28,6 +32,10 @@ type WhatsAppWebhookPayload struct {
 		RepliedID     string `json:"replied_id"`
 		QuotedMessage string `json:"quoted_message"`
 	} `json:"message"`
+	Reaction struct {
+		Message string `json:"message"` // the emoji, empty when a reaction is removed
+		ID      string `json:"id"`      // ID of the message reacted to
+	} `json:"reaction"`
 	PushName  string `json:"pushname"`
 	SenderID  string `json:"sender_id"`
 	Timestamp string `json:"timestamp"`
@@ -88,28 +96,166 @@ func (b *Bot) HandleWhatsAppWebhook(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Processing message from %s (%s): %s",
 		payload.From, payload.PushName, payload.Message.Text)
 
-	// Process message using existing bot logic
-	response, err := b.HandleMessage(r.Context(), payload.Message.Text)
-	if err != nil {
-		log.Printf("Error processing message: %v", err)
-		http.Error(w, "Error processing message", http.StatusInternalServerError)
+	// Without a dispatcher the message is processed inside the request
+	if b.dispatcher == nil {
+		if err := b.processWhatsAppMessage(r.Context(), &payload); err != nil {
+			log.Printf("Error processing message: %v", err)
+			http.Error(w, "Error processing message", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	log.Printf("Got LLM response: %s", response)
+	task := Task{
+		ChatID:   payload.chatKey(),
+		Priority: b.priorityFor(&payload),
+		Run: func(ctx context.Context) {
+			if err := b.processWhatsAppMessage(ctx, &payload); err != nil {
+				log.Printf("Error processing queued message for chat %s: %v", payload.chatKey(), err)
+			}
+		},
+	}
 
-	// Send response back to WhatsApp
-	if err := b.sendDirectWhatsAppResponse(r.Context(), payload.From, response); err != nil {
-		log.Printf("Error sending WhatsApp response: %v", err)
-		http.Error(w, "Error sending response", http.StatusInternalServerError)
+	if err := b.dispatcher.Submit(task); err != nil {
+		log.Printf("Rejecting message for chat %s: %v", task.ChatID, err)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Bot is busy, try again later", http.StatusServiceUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// processWhatsAppMessage gets the LLM response for a webhook payload, sends it back and records the exchange.
+// Reactions are recorded as feedback and chat commands answered instead; muted chats get no answer.
+func (b *Bot) processWhatsAppMessage(ctx context.Context, payload *WhatsAppWebhookPayload) error {
+	if payload.Reaction.ID != "" {
+		return b.processWhatsAppReaction(ctx, payload)
+	}
+
+	in := payload.incoming()
+	if answer, ok, err := b.command(ctx, in); ok {
+		if err != nil {
+			return err
+		}
+		if _, err := b.sendDirectWhatsAppResponse(ctx, payload.From, answer); err != nil {
+			return fmt.Errorf("sending WhatsApp response: %w", err)
+		}
+		return nil
+	}
+	if b.muted(ctx, in) {
+		log.Printf("Ignoring message in muted chat %s", in.ChatID)
+		return nil
+	}
+
+	// Process message using existing bot logic
+	reply, err := b.Respond(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Got LLM response: %s", reply.Text)
+
+	// Send response back to WhatsApp. The exchange is recorded even when
+	// delivery fails, since the response has already been generated.
+	messageID, sendErr := b.sendDirectWhatsAppResponse(ctx, payload.From, reply.Text)
+	if sendErr != nil {
+		reply.exchange.DeliveryError = sendErr.Error()
+	}
+	b.Record(ctx, reply, messageID)
+	if sendErr != nil {
+		return fmt.Errorf("sending WhatsApp response: %w", sendErr)
+	}
+
+	return nil
+}
+
+// processWhatsAppReaction records a reaction to one of the bot's replies as
+// feedback. Reactions to other messages are ignored.
+func (b *Bot) processWhatsAppReaction(ctx context.Context, payload *WhatsAppWebhookPayload) error {
+	in := payload.incoming()
+	fb, err := b.RecordFeedback(ctx, Feedback{
+		Channel:  in.Channel,
+		ChatID:   in.ChatID,
+		SenderID: in.SenderID,
+		ReplyID:  payload.Reaction.ID,
+		Rating:   reactionRating(payload.Reaction.Message),
+		Source:   db.FeedbackReaction,
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("recording reaction: %w", err)
+	}
+
+	log.Printf("Recorded reaction %q on interaction %d from %s", payload.Reaction.Message, fb.InteractionID, in.SenderID)
+	return nil
+}
+
+// incoming converts the webhook payload into a channel independent message
+func (p *WhatsAppWebhookPayload) incoming() Incoming {
+	sender := p.SenderID
+	if sender == "" {
+		sender = p.From
+	}
+
+	sentAt, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		sentAt = time.Now()
+	}
+
+	return Incoming{
+		Channel:    db.ChannelWhatsApp,
+		ChatID:     p.chatKey(),
+		IsGroup:    p.isGroup(),
+		SenderID:   sender,
+		SenderName: p.PushName,
+		Text:       p.Message.Text,
+		MessageID:  p.Message.ID,
+		ReplyToID:  p.Message.RepliedID,
+		Quoted:     p.Message.QuotedMessage,
+		SentAt:     sentAt,
+	}
+}
+
+// chatKey identifies the conversation a payload belongs to
+func (p *WhatsAppWebhookPayload) chatKey() string {
+	if p.ChatID != "" {
+		return p.ChatID
+	}
+	return p.From
+}
+
+// isGroup reports whether the payload was sent in a group chat
+func (p *WhatsAppWebhookPayload) isGroup() bool {
+	return strings.HasSuffix(p.ChatID, "@g.us") || strings.Contains(p.From, "@g.us")
 }
 
-// sendDirectWhatsAppResponse sends the response directly to WhatsApp
-func (b *Bot) sendDirectWhatsAppResponse(ctx context.Context, jid string, message string) error {
+// priorityFor puts admins and private chats ahead of group chats
+func (b *Bot) priorityFor(payload *WhatsAppWebhookPayload) Priority {
+	sender := payload.SenderID
+	if sender == "" {
+		sender = payload.From
+	}
+	if b.admins[jidUser(sender)] || !payload.isGroup() {
+		return PriorityHigh
+	}
+	return PriorityLow
+}
+
+// jidUser strips the server and device parts from a JID, leaving the phone number
+func jidUser(jid string) string {
+	if i := strings.IndexAny(jid, "@:"); i >= 0 {
+		jid = jid[:i]
+	}
+	return strings.TrimSpace(jid)
+}
+
+// sendDirectWhatsAppResponse sends the response directly to WhatsApp and
+// returns the ID of the sent message
+func (b *Bot) sendDirectWhatsAppResponse(ctx context.Context, jid string, message string) (string, error) {
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
@@ -124,7 +270,7 @@ func (b *Bot) sendDirectWhatsAppResponse(ctx context.Context, jid string, messag
 	// Convert to JSON
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("error marshaling payload: %w", err)
+		return "", fmt.Errorf("error marshaling payload: %w", err)
 	}
 
 	log.Printf("Sending to WhatsApp API: %s", string(jsonData))
@@ -132,7 +278,7 @@ func (b *Bot) sendDirectWhatsAppResponse(ctx context.Context, jid string, messag
 	// Create request
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost:3000/send/message", bytes.NewReader(jsonData))
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return "", fmt.Errorf("error creating request: %w", err)
 	}
 
 	// Set headers
@@ -144,24 +290,30 @@ func (b *Bot) sendDirectWhatsAppResponse(ctx context.Context, jid string, messag
 	// Send request
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
+		return "", fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading response: %w", err)
+		return "", fmt.Errorf("error reading response: %w", err)
 	}
 
 	log.Printf("WhatsApp API response: %s", string(respBody))
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
+		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
 	}
 
-	return nil
+	var apiResp WhatsAppAPIResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		log.Printf("Could not parse WhatsApp API response: %v", err)
+		return "", nil
+	}
+
+	return apiResp.Results.MessageID, nil
 }
 
 // RegisterWebhook registers our webhook URL with the WhatsApp API
@@ -216,8 +368,35 @@ func (b *Bot) RegisterWebhook(webhookURL string) error {
 	return nil
 }
 
-// StartWebhookServer starts the webhook server
-func (b *Bot) StartWebhookServer(port int) error {
+// HandleQueueMetrics reports the dispatcher queue metrics as JSON
+func (b *Bot) HandleQueueMetrics(w http.ResponseWriter, r *http.Request) {
+	if b.dispatcher == nil {
+		http.Error(w, "Dispatcher is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.dispatcher.Stats()); err != nil {
+		log.Printf("Error encoding queue metrics: %v", err)
+	}
+}
+
+// HandleWriteMetrics reports the write-behind metrics as JSON
+func (b *Bot) HandleWriteMetrics(w http.ResponseWriter, r *http.Request) {
+	if b.writer == nil {
+		http.Error(w, "Write-behind is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.writer.Stats()); err != nil {
+		log.Printf("Error encoding write metrics: %v", err)
+	}
+}
+
+// StartWebhookServer serves the webhook, metrics and admin endpoints with
+// server until it is shut down, which returns http.ErrServerClosed
+func (b *Bot) StartWebhookServer(server *http.Server) error {
 	mux := http.NewServeMux()
 
 	// Add handlers with CORS support
@@ -237,6 +416,12 @@ func (b *Bot) StartWebhookServer(port int) error {
 		b.HandleWhatsAppWebhook(w, r)
 	})
 
+	mux.HandleFunc("/metrics/queue", b.HandleQueueMetrics)
+	mux.HandleFunc("/metrics/writes", b.HandleWriteMetrics)
+	if b.adminAPI != nil {
+		mux.Handle("/admin/", b.adminAPI)
+	}
+
 	mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 		w.Write([]byte("Webhook server is running"))
@@ -248,9 +433,7 @@ func (b *Bot) StartWebhookServer(port int) error {
 		return fmt.Errorf("failed to create listener: %w", err)
 	}
 
-	server := &http.Server{
-		Handler: mux,
-	}
+	server.Handler = mux
 
 	log.Printf("Starting webhook server on 0.0.0.0:4444")
 	log.Printf("If running WhatsApp API in WSL, configure webhook URL as:")
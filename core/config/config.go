@@ -4,6 +4,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,11 +16,81 @@ type Config struct {
 	SystemPrompt   string
 	RequestTimeout time.Duration
 
+	// Sampling parameters sent with every request
+	Temperature float64
+	TopP        float64
+	MaxTokens   int
+
+	// Path to the A/B experiment definitions, empty to disable experiments
+	ExperimentsFile string
+
+	// Pricing in USD per million tokens, used for cost reporting
+	PromptPricePerMTok     float64
+	CompletionPricePerMTok float64
+
 	// Database Configuration
-	DBPath          string
+	DBDriver        string // sqlite or postgres
+	DBPath          string // SQLite database file
+	DBDSN           string // Postgres connection string
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
+
+	// Retention Configuration. Zero limits keep data forever.
+	RetentionMaxAge     time.Duration
+	RetentionMaxPerChat int
+	RetentionChannels   []string // per-channel overrides as channel:max_age:max_per_chat
+	RetentionInterval   time.Duration
+	RetentionBatchSize  int
+	RetentionArchiveDir string // archive deleted interactions here as JSONL, empty to only delete
+	RetentionDryRun     bool
+
+	// Backup Configuration. Scheduled backups are off when the interval is 0.
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int // newest backups kept, 0 keeps all
+	BackupGzip     bool
+
+	// Encryption Configuration. Message content is stored in plaintext when
+	// no keys are configured.
+	EncryptionKeyFile   string   // file with one id:base64key per line
+	EncryptionKeys      []string // additional id:base64key entries
+	EncryptionActiveKey string   // key ID sealing new content, default the last key
+
+	// SubjectHashKey keys the hashes erasures and the audit log name senders
+	// by. Without it they are plain SHA-256 hashes, which can be reversed by
+	// hashing every phone number.
+	SubjectHashKey string
+
+	// Write-behind Configuration. Exchanges are recorded synchronously when
+	// the queue size is 0.
+	WriteQueueSize     int
+	WriteBatchSize     int
+	WriteFlushInterval time.Duration
+	WriteJournalPath   string // spill file for failed writes, empty to drop them
+
+	// Embeddings Configuration. Interactions are not embedded when no
+	// provider is set.
+	EmbeddingsProvider   string // local or openai
+	EmbeddingsURL        string // openai: the /embeddings endpoint of any compatible API
+	EmbeddingsModel      string
+	EmbeddingsAPIKey     string
+	EmbeddingsDimensions int // local: vector size; openai: requested size, 0 for the model's own
+	EmbeddingsBatchSize  int
+	EmbeddingsInterval   time.Duration
+
+	// Admin API Configuration. The API is off when the token is empty.
+	AdminToken string
+
+	// Settings Configuration
+	SettingsDefaults []string // key=value entries replacing the built-in defaults
+	SettingsCacheTTL time.Duration
+
+	// Dispatcher Configuration
+	WorkerCount int
+	QueueDepth  int
+	TaskTimeout time.Duration
+	AdminJIDs   []string
 }
 
 // LoadConfig loads configuration from environment variables with sensible defaults
@@ -30,12 +101,72 @@ func LoadConfig() *Config {
 		ModelName:      getEnvOrDefault("MODEL_NAME", "llama3-8b-8192"),
 		SystemPrompt:   getEnvOrDefault("DEFAULT_PROMPT", DefaultPrompt),
 		RequestTimeout: getDurationOrDefault("REQUEST_TIMEOUT", 30*time.Second),
+		Temperature:    getFloatOrDefault("TEMPERATURE", 1.0),
+		TopP:           getFloatOrDefault("TOP_P", 1.0),
+		MaxTokens:      getIntOrDefault("MAX_TOKENS", 0),
+
+		ExperimentsFile: os.Getenv("EXPERIMENTS_FILE"),
+
+		// Pricing Config
+		PromptPricePerMTok:     getFloatOrDefault("PRICE_PROMPT_PER_MTOK", 0.05),
+		CompletionPricePerMTok: getFloatOrDefault("PRICE_COMPLETION_PER_MTOK", 0.08),
 
 		// Database Config
+		DBDriver:        getEnvOrDefault("DB_DRIVER", "sqlite"),
 		DBPath:          getEnvOrDefault("DB_PATH", "D:/db/test.db"),
+		DBDSN:           os.Getenv("DB_DSN"),
 		MaxOpenConns:    getIntOrDefault("DB_MAX_OPEN_CONNS", 25),
 		MaxIdleConns:    getIntOrDefault("DB_MAX_IDLE_CONNS", 25),
 		ConnMaxLifetime: getDurationOrDefault("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+
+		// Retention Config
+		RetentionMaxAge:     getDurationOrDefault("RETENTION_MAX_AGE", 0),
+		RetentionMaxPerChat: getIntOrDefault("RETENTION_MAX_PER_CHAT", 0),
+		RetentionChannels:   getListOrDefault("RETENTION_CHANNELS", nil),
+		RetentionInterval:   getDurationOrDefault("RETENTION_INTERVAL", time.Hour),
+		RetentionBatchSize:  getIntOrDefault("RETENTION_BATCH_SIZE", 500),
+		RetentionArchiveDir: os.Getenv("RETENTION_ARCHIVE_DIR"),
+		RetentionDryRun:     getBoolOrDefault("RETENTION_DRY_RUN", false),
+
+		// Backup Config
+		BackupDir:      getEnvOrDefault("BACKUP_DIR", "backups"),
+		BackupInterval: getDurationOrDefault("BACKUP_INTERVAL", 0),
+		BackupKeep:     getIntOrDefault("BACKUP_KEEP", 7),
+		BackupGzip:     getBoolOrDefault("BACKUP_GZIP", true),
+
+		// Encryption Config
+		EncryptionKeyFile:   os.Getenv("ENCRYPTION_KEY_FILE"),
+		EncryptionKeys:      getListOrDefault("ENCRYPTION_KEYS", nil),
+		EncryptionActiveKey: os.Getenv("ENCRYPTION_ACTIVE_KEY"),
+		SubjectHashKey:      os.Getenv("SUBJECT_HASH_KEY"),
+
+		// Write-behind Config
+		WriteQueueSize:     getIntOrDefault("WRITE_QUEUE_SIZE", 1000),
+		WriteBatchSize:     getIntOrDefault("WRITE_BATCH_SIZE", 50),
+		WriteFlushInterval: getDurationOrDefault("WRITE_FLUSH_INTERVAL", time.Second),
+		WriteJournalPath:   getEnvOrDefault("WRITE_JOURNAL_PATH", "write-journal.jsonl"),
+
+		// Embeddings Config
+		EmbeddingsProvider:   os.Getenv("EMBEDDINGS_PROVIDER"),
+		EmbeddingsURL:        getEnvOrDefault("EMBEDDINGS_URL", "https://api.openai.com/v1/embeddings"),
+		EmbeddingsModel:      getEnvOrDefault("EMBEDDINGS_MODEL", "text-embedding-3-small"),
+		EmbeddingsAPIKey:     os.Getenv("EMBEDDINGS_API_KEY"),
+		EmbeddingsDimensions: getIntOrDefault("EMBEDDINGS_DIMENSIONS", 0),
+		EmbeddingsBatchSize:  getIntOrDefault("EMBEDDINGS_BATCH_SIZE", 32),
+		EmbeddingsInterval:   getDurationOrDefault("EMBEDDINGS_INTERVAL", 30*time.Second),
+
+		// Admin API Config
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+
+		// Settings Config
+		SettingsDefaults: getListOrDefault("SETTINGS_DEFAULTS", nil),
+		SettingsCacheTTL: getDurationOrDefault("SETTINGS_CACHE_TTL", time.Minute),
+
+		// Dispatcher Config
+		WorkerCount: getIntOrDefault("LLM_WORKERS", 4),
+		QueueDepth:  getIntOrDefault("LLM_QUEUE_DEPTH", 100),
+		TaskTimeout: getDurationOrDefault("LLM_TASK_TIMEOUT", 60*time.Second),
+		AdminJIDs:   getListOrDefault("ADMIN_JIDS", nil),
 	}
 }
 
@@ -55,6 +186,15 @@ func getIntOrDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -63,3 +203,25 @@ func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration
 	}
 	return defaultValue
 }
+
+func getBoolOrDefault(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getListOrDefault(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return defaultValue
+}
@@ -0,0 +1,370 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditSettingSet        = "settings.set"
+	AuditSettingDelete     = "settings.delete"
+	AuditReviewSet         = "review.set"
+	AuditPrivacyExport     = "privacy.export"
+	AuditPrivacyErase      = "privacy.erase"
+	AuditEncryptionRotate  = "encryption.rotate"
+	AuditEncryptionDecrypt = "encryption.decrypt"
+	AuditBackupCreate      = "backup.create"
+	AuditBackupRestore     = "backup.restore"
+	AuditRetentionPrune    = "retention.prune"
+	AuditMigrate           = "migrate"
+	AuditImport            = "import"
+	AuditExport            = "export"
+)
+
+// Actors of events nobody triggered directly
+const (
+	ActorRetention = "system:retention"
+	ActorBackup    = "system:backup"
+	ActorMigrate   = "system:migrate"
+)
+
+// defaultAuditLimit is the number of entries listed when no limit is set
+const defaultAuditLimit = 50
+
+// auditAttempts is how often an append is tried when another writer
+// appended at the same time
+const auditAttempts = 3
+
+// AuditEvent is an administrative or system change to record
+type AuditEvent struct {
+	Actor  string      // who made the change: an operator, a chat sender or a system:* component
+	Action string      // one of the Audit* actions
+	Target string      // what was changed, e.g. interaction:42
+	Before interface{} // state before the change, encoded as JSON; nil when there was none
+	After  interface{} // state after the change, encoded as JSON; nil when there is none
+}
+
+// AuditEntry is a stored audit event with its place in the hash chain
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string // an action, or a prefix such as settings for all settings.* actions
+	Target string // part of the target
+	From   time.Time
+	To     time.Time // exclusive
+	Limit  int       // newest entries returned, default 50
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	OK       bool   `json:"ok"` // every entry links to the one before it and matches its hash
+	Entries  int    `json:"entries"`
+	Head     string `json:"head,omitempty"`      // hash of the newest entry; keep it elsewhere to detect truncation
+	BrokenAt int64  `json:"broken_at,omitempty"` // first entry whose hash or link does not match
+	Problem  string `json:"problem,omitempty"`
+}
+
+// InteractionTarget names an interaction as an audit target
+func InteractionTarget(id int64) string {
+	return fmt.Sprintf("interaction:%d", id)
+}
+
+// SettingTarget names a setting of a chat or sender as an audit target. The
+// chat or sender ID is recorded by its subject hash, e.g.
+// chat:whatsapp/subject:3f1a…/mute, so the append-only log keeps no IDs.
+func (h SubjectHasher) SettingTarget(subject SettingSubject, key string) string {
+	return fmt.Sprintf("%s:%s/%s/%s", subject.Scope, subject.Channel, h.Target(subject.ID), key)
+}
+
+// Target names a data subject as an audit target by the hash its erasures
+// are recorded under, so the target outlives the erasure
+func (h SubjectHasher) Target(subject string) string {
+	return subjectTarget(h.Hash(subject))
+}
+
+// subjectTarget is the audit target of a subject hash
+func subjectTarget(hash string) string {
+	return "subject:" + hash
+}
+
+// schemaVersion is the state recorded for migrations
+type schemaVersion struct {
+	Version int `json:"version"`
+}
+
+// auditLogVersion is the migration creating the audit log
+const auditLogVersion = 14
+
+// AuditMigration records that the schema moved from one version to another.
+// Nothing is recorded when the schema ends before the audit log existed.
+func AuditMigration(ctx context.Context, m *Migrator, actor string, from, to int) (*AuditEntry, error) {
+	if to < auditLogVersion {
+		return nil, nil
+	}
+	return m.AppendAudit(ctx, AuditEvent{
+		Actor:  actor,
+		Action: AuditMigrate,
+		Target: "schema",
+		Before: schemaVersion{from},
+		After:  schemaVersion{to},
+	})
+}
+
+// reviewState is what the audit log keeps of a review. The corrected
+// response is recorded by its hash and the note is left out, as the log
+// cannot be erased.
+type reviewState struct {
+	Status      string `json:"status"`
+	Reviewer    string `json:"reviewer,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"` // hash of the corrected response of edited interactions
+}
+
+// auditReview returns the recorded state of a review, nil for none or pending
+func auditReview(rev *Review) *reviewState {
+	if rev == nil || rev.Status == ReviewPending {
+		return nil
+	}
+	state := &reviewState{Status: rev.Status, Reviewer: rev.Reviewer}
+	if rev.CorrectedResponse != "" {
+		state.ContentHash = PromptHash(rev.CorrectedResponse)
+	}
+	return state
+}
+
+// ReviewEvent returns the audit event of a review replacing an earlier one.
+// Either may be nil or pending, which is recorded as no review.
+func ReviewEvent(actor string, before, after *Review) AuditEvent {
+	ev := AuditEvent{Actor: actor, Action: AuditReviewSet}
+	if state := auditReview(before); state != nil {
+		ev.Before = state
+	}
+	if after != nil {
+		ev.Target = InteractionTarget(after.InteractionID)
+		if state := auditReview(after); state != nil {
+			ev.After = state
+		}
+	}
+	return ev
+}
+
+// auditColumns is the column list read by scanAudit
+const auditColumns = "id, occurred_at, actor, action, target, before_json, after_json, prev_hash, hash"
+
+// computeHash returns the hash of the entry over its content and the previous hash
+func (e *AuditEntry) computeHash() string {
+	fields, _ := json.Marshal([]string{
+		e.PrevHash,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		string(e.Before),
+		string(e.After),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditJSON encodes the state before or after a change, nil staying absent
+func auditJSON(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// AppendAudit records an event at the end of the audit log. Another process
+// appending at the same moment takes the same previous hash, which the
+// unique constraint rejects, so the append is retried on the new head.
+func (s *SQLStore) AppendAudit(ctx context.Context, ev AuditEvent) (*AuditEntry, error) {
+	return appendAudit(ctx, s.inTx, ev)
+}
+
+// AppendAudit records an event at the end of the audit log of a database
+// whose schema includes it
+func (m *Migrator) AppendAudit(ctx context.Context, ev AuditEvent) (*AuditEntry, error) {
+	return appendAudit(ctx, m.inTx, ev)
+}
+
+// appendAudit appends an event in transactions started by inTx
+func appendAudit(ctx context.Context, inTx func(context.Context, func(queryer) error) error, ev AuditEvent) (*AuditEntry, error) {
+	if ev.Actor == "" || ev.Action == "" {
+		return nil, errors.New("an audit event needs an actor and an action")
+	}
+	before, err := auditJSON(ev.Before)
+	if err != nil {
+		return nil, fmt.Errorf("encoding state before %s: %w", ev.Action, err)
+	}
+	after, err := auditJSON(ev.After)
+	if err != nil {
+		return nil, fmt.Errorf("encoding state after %s: %w", ev.Action, err)
+	}
+
+	const insert = `
+	INSERT INTO audit_log (occurred_at, actor, action, target, before_json, after_json, prev_hash, hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`
+
+	for attempt := 1; ; attempt++ {
+		entry := &AuditEntry{Actor: ev.Actor, Action: ev.Action, Target: ev.Target, Before: before, After: after}
+		err = inTx(ctx, func(q queryer) error {
+			err := q.QueryRowContext(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&entry.PrevHash)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("reading the audit log head: %w", err)
+			}
+			// Microseconds survive every backend, so the hash can be recomputed from the stored time
+			entry.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+			entry.Hash = entry.computeHash()
+
+			err = q.QueryRowContext(ctx, insert, entry.OccurredAt, entry.Actor, entry.Action, nullString(entry.Target),
+				nullString(string(entry.Before)), nullString(string(entry.After)), entry.PrevHash, entry.Hash).Scan(&entry.ID)
+			if err != nil {
+				return fmt.Errorf("appending to the audit log: %w", err)
+			}
+			return nil
+		})
+		if err == nil {
+			return entry, nil
+		}
+		if attempt == auditAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// ListAudit returns the newest audit entries matching the filter, newest first
+func (s *SQLStore) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var conds []string
+	var args []interface{}
+	if filter.Actor != "" {
+		conds = append(conds, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conds = append(conds, "(action = ? OR action LIKE ?)")
+		args = append(args, filter.Action, filter.Action+".%")
+	}
+	if filter.Target != "" {
+		conds = append(conds, "target LIKE ?")
+		args = append(args, "%"+filter.Target+"%")
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "occurred_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "occurred_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+	where := "1 = 1"
+	if len(conds) > 0 {
+		where = strings.Join(conds, " AND ")
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log WHERE "+where+
+		" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying the audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	err = scanAudit(rows, func(e *AuditEntry) error {
+		entries = append(entries, *e)
+		return nil
+	})
+	return entries, err
+}
+
+// VerifyAudit walks the whole audit log in order and checks that every entry
+// links to the one before it and that its hash matches its content
+func (s *SQLStore) VerifyAudit(ctx context.Context) (*AuditVerification, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying the audit log: %w", err)
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{OK: true}
+	err = scanAudit(rows, func(e *AuditEntry) error {
+		if !result.OK {
+			return nil
+		}
+		switch {
+		case e.PrevHash != result.Head:
+			result.OK, result.BrokenAt = false, e.ID
+			result.Problem = "the entry does not follow the one before it; entries were removed or reordered"
+		case e.computeHash() != e.Hash:
+			result.OK, result.BrokenAt = false, e.ID
+			result.Problem = "the entry does not match its hash; it was changed"
+		}
+		result.Entries++
+		result.Head = e.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanAudit reads rows selected with auditColumns, calling fn for each entry
+func scanAudit(rows *sql.Rows, fn func(*AuditEntry) error) error {
+	for rows.Next() {
+		var e AuditEntry
+		var target, before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.Actor, &e.Action, &target, &before, &after, &e.PrevHash, &e.Hash); err != nil {
+			return fmt.Errorf("scanning audit entry: %w", err)
+		}
+		e.OccurredAt = e.OccurredAt.UTC()
+		e.Target = target.String
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading the audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrBackupUnsupported is returned when backing up a database other than SQLite
+var ErrBackupUnsupported = errors.New("online backup is only supported for SQLite, use pg_dump for Postgres")
+
+// BackupTo writes a consistent copy of the live database to path, which must
+// not exist yet. Writers are only blocked while the copy is read.
+func (s *SQLStore) BackupTo(ctx context.Context, path string) error {
+	if s.driver != DriverSQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("copying database: %w", err)
+	}
+	return nil
+}
+
+// DatabaseCheck is the result of inspecting a SQLite database file
+type DatabaseCheck struct {
+	Problems     []string `json:"problems,omitempty"` // integrity check failures, empty when the file is sound
+	Version      int      `json:"version"`            // schema version of the file
+	Latest       int      `json:"latest"`             // schema version of this binary
+	Interactions int      `json:"interactions"`
+}
+
+// OK reports whether the file passed the integrity check
+func (c *DatabaseCheck) OK() bool {
+	return len(c.Problems) == 0
+}
+
+// CheckDatabase opens a SQLite database file read-only, runs an integrity
+// check and reads its schema version
+func CheckDatabase(ctx context.Context, path string) (*DatabaseCheck, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	defer conn.Close()
+
+	migrations, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		return nil, err
+	}
+	check := &DatabaseCheck{Latest: migrations[len(migrations)-1].Version}
+
+	rows, err := conn.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("checking integrity: %w", err)
+	}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading integrity check: %w", err)
+		}
+		if line != "ok" {
+			check.Problems = append(check.Problems, line)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading integrity check: %w", err)
+	}
+	if !check.OK() {
+		return check, nil
+	}
+
+	tables := make(map[string]bool)
+	rows, err = conn.QueryContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('schema_migrations', 'interactions')")
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("listing tables: %w", err)
+		}
+		tables[name] = true
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+
+	if !tables["interactions"] {
+		return nil, errors.New("not a bot database: it has no interactions table")
+	}
+	if tables["schema_migrations"] {
+		var version sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+			return nil, fmt.Errorf("reading schema version: %w", err)
+		}
+		check.Version = int(version.Int64)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM interactions").Scan(&check.Interactions); err != nil {
+		return nil, fmt.Errorf("counting interactions: %w", err)
+	}
+	return check, nil
+}
+
+// ErasuresIn reads the erasure records of the SQLite database file at path
+// without migrating it. Databases from before erasures were recorded have none.
+func ErasuresIn(ctx context.Context, path string) ([]Erasure, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	defer conn.Close()
+
+	var tables int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'erasures'").Scan(&tables); err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	if tables == 0 {
+		return nil, nil
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT "+erasureColumns+" FROM erasures ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying erasures: %w", err)
+	}
+	return scanErasures(rows)
+}
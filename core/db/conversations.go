@@ -0,0 +1,251 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// Channels the bot receives messages on
+const (
+	ChannelCLI      = "cli"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ChatRef identifies a chat by its channel and external ID
+type ChatRef struct {
+	Channel    string
+	ExternalID string
+	IsGroup    bool
+	Title      string
+}
+
+// ParticipantRef identifies a message sender by its external ID within a channel
+type ParticipantRef struct {
+	ExternalID  string
+	DisplayName string
+}
+
+// MessageRecord is a single stored chat message
+type MessageRecord struct {
+	ID                int64     `json:"id"`
+	ChatID            string    `json:"chat_id,omitempty"`        // external chat ID, filled in when reading
+	InteractionID     int64     `json:"interaction_id,omitempty"` // filled in when reading
+	SenderID          string    `json:"sender_id,omitempty"`      // external sender ID, filled in when reading
+	Role              string    `json:"role"`
+	Content           string    `json:"content"`
+	ExternalID        string    `json:"external_id,omitempty"` // e.g. the WhatsApp message ID
+	ReplyToExternalID string    `json:"reply_to_external_id,omitempty"`
+	QuotedContent     string    `json:"quoted_content,omitempty"`
+	SentAt            time.Time `json:"sent_at"`
+}
+
+// Chat is a stored conversation
+type Chat struct {
+	ID            int64     `json:"id"`
+	Channel       string    `json:"channel"`
+	ExternalID    string    `json:"external_id"`
+	IsGroup       bool      `json:"is_group"`
+	Title         string    `json:"title,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastMessageAt time.Time `json:"last_message_at"`
+	Messages      int       `json:"messages"`
+}
+
+// Exchange is an incoming message, the bot's reply and the interaction that
+// produced it, recorded together
+type Exchange struct {
+	Chat        ChatRef
+	Sender      ParticipantRef
+	Inbound     MessageRecord
+	Outbound    MessageRecord
+	Interaction InteractionRecord
+
+	// DeliveryError is set when the reply could not be sent and is recorded
+	// as a delivery failure of the interaction
+	DeliveryError string `json:",omitempty"`
+}
+
+// RecordExchange stores the chat, sender, interaction and both messages of an
+// exchange in a single transaction and fills in the generated IDs
+func (s *SQLStore) RecordExchange(ctx context.Context, ex *Exchange) error {
+	return s.inTx(ctx, func(q queryer) error {
+		return recordExchange(ctx, q, s.keys, ex)
+	})
+}
+
+// RecordExchanges stores several exchanges in a single transaction. Either
+// all of them are stored or, on error, none.
+func (s *SQLStore) RecordExchanges(ctx context.Context, exchanges []*Exchange) error {
+	return s.inTx(ctx, func(q queryer) error {
+		for _, ex := range exchanges {
+			if err := recordExchange(ctx, q, s.keys, ex); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func recordExchange(ctx context.Context, q queryer, keys *encryption.Keyring, ex *Exchange) error {
+	channelID, err := ensureChannel(ctx, q, ex.Chat.Channel)
+	if err != nil {
+		return err
+	}
+	chatID, err := ensureChat(ctx, q, channelID, ex.Chat)
+	if err != nil {
+		return err
+	}
+
+	var senderID sql.NullInt64
+	if ex.Sender.ExternalID != "" {
+		id, err := ensureParticipant(ctx, q, channelID, ex.Sender)
+		if err != nil {
+			return err
+		}
+		senderID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	ex.Interaction.Channel = ex.Chat.Channel
+	ex.Interaction.ChatID = ex.Chat.ExternalID
+	ex.Interaction.SenderID = ex.Sender.ExternalID
+	if err := insertInteraction(ctx, q, keys, &ex.Interaction); err != nil {
+		return err
+	}
+
+	ex.Inbound.Role = RoleUser
+	if err := insertMessage(ctx, q, keys, chatID, senderID, ex.Interaction.ID, &ex.Inbound); err != nil {
+		return err
+	}
+	ex.Outbound.Role = RoleAssistant
+	if err := insertMessage(ctx, q, keys, chatID, sql.NullInt64{}, ex.Interaction.ID, &ex.Outbound); err != nil {
+		return err
+	}
+
+	if ex.DeliveryError != "" {
+		failure := &FailureRecord{
+			CreatedAt:     ex.Outbound.SentAt,
+			Channel:       ex.Chat.Channel,
+			Stage:         FailureDelivery,
+			Model:         ex.Interaction.Model,
+			Error:         ex.DeliveryError,
+			InteractionID: ex.Interaction.ID,
+		}
+		if err := insertFailure(ctx, q, failure); err != nil {
+			return err
+		}
+	}
+
+	return touchChat(ctx, q, chatID, ex.Outbound.SentAt)
+}
+
+// touchChat moves the last message time of a chat forward to sentAt. Older
+// messages, e.g. imported history, leave it unchanged.
+func touchChat(ctx context.Context, q queryer, chatID int64, sentAt time.Time) error {
+	const touch = `
+	UPDATE chats SET last_message_at = ?
+	WHERE id = ? AND (last_message_at IS NULL OR last_message_at < ?)`
+	if _, err := q.ExecContext(ctx, touch, sentAt.UTC(), chatID, sentAt.UTC()); err != nil {
+		return fmt.Errorf("updating chat: %w", err)
+	}
+	return nil
+}
+
+// ensureChannel returns the ID of the named channel, creating it if needed
+func ensureChannel(ctx context.Context, q queryer, name string) (int64, error) {
+	const upsert = `
+	INSERT INTO channels (name) VALUES (?)
+	ON CONFLICT(name) DO UPDATE SET name = excluded.name
+	RETURNING id`
+
+	var id int64
+	if err := q.QueryRowContext(ctx, upsert, name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("upserting channel %s: %w", name, err)
+	}
+	return id, nil
+}
+
+// ensureChat returns the ID of a chat, creating it or refreshing its title
+func ensureChat(ctx context.Context, q queryer, channelID int64, chat ChatRef) (int64, error) {
+	const upsert = `
+	INSERT INTO chats (channel_id, external_id, is_group, title) VALUES (?, ?, ?, ?)
+	ON CONFLICT(channel_id, external_id) DO UPDATE SET
+		is_group = excluded.is_group,
+		title = COALESCE(excluded.title, chats.title)
+	RETURNING id`
+
+	var id int64
+	err := q.QueryRowContext(ctx, upsert, channelID, chat.ExternalID, chat.IsGroup, nullString(chat.Title)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("upserting chat %s: %w", chat.ExternalID, err)
+	}
+	return id, nil
+}
+
+// ensureParticipant returns the ID of a participant, keeping its display name current
+func ensureParticipant(ctx context.Context, q queryer, channelID int64, p ParticipantRef) (int64, error) {
+	const upsert = `
+	INSERT INTO participants (channel_id, external_id, display_name) VALUES (?, ?, ?)
+	ON CONFLICT(channel_id, external_id) DO UPDATE SET
+		display_name = COALESCE(excluded.display_name, participants.display_name),
+		updated_at = CURRENT_TIMESTAMP
+	RETURNING id`
+
+	var id int64
+	if err := q.QueryRowContext(ctx, upsert, channelID, p.ExternalID, nullString(p.DisplayName)).Scan(&id); err != nil {
+		return 0, fmt.Errorf("upserting participant %s: %w", p.ExternalID, err)
+	}
+	return id, nil
+}
+
+// insertMessage writes a message row, encrypting its content when keys are
+// given, and sets msg.ID
+func insertMessage(ctx context.Context, q queryer, keys *encryption.Keyring, chatID int64, participantID sql.NullInt64, interactionID int64, msg *MessageRecord) error {
+	const query = `
+	INSERT INTO messages (
+		chat_id, participant_id, interaction_id, role, content, key_id, external_id, reply_to_external_id, quoted_content, sent_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`
+
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+
+	content, err := sealValue(keys, labelContent, msg.Content)
+	if err != nil {
+		return err
+	}
+	var quoted sql.NullString
+	if msg.QuotedContent != "" {
+		if quoted.String, err = sealValue(keys, labelQuoted, msg.QuotedContent); err != nil {
+			return err
+		}
+		quoted.Valid = true
+	}
+
+	var interaction sql.NullInt64
+	if interactionID != 0 {
+		interaction = sql.NullInt64{Int64: interactionID, Valid: true}
+	}
+
+	err = q.QueryRowContext(ctx, query,
+		chatID, participantID, interaction, msg.Role, content, activeKeyID(keys), nullString(msg.ExternalID),
+		nullString(msg.ReplyToExternalID), quoted, msg.SentAt.UTC(),
+	).Scan(&msg.ID)
+	if err != nil {
+		return fmt.Errorf("inserting %s message: %w", msg.Role, err)
+	}
+	msg.InteractionID = interactionID
+	return nil
+}
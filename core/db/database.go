@@ -4,27 +4,176 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/encryption"
 
 	_ "modernc.org/sqlite"
 )
 
 // Store defines the interface for database operations
 type Store interface {
-	LogInteraction(ctx context.Context, prompt, response string) error
+	// Writes
+	LogInteraction(ctx context.Context, rec *InteractionRecord) error
+	RecordExchange(ctx context.Context, ex *Exchange) error
+	RecordExchanges(ctx context.Context, exchanges []*Exchange) error
+	AddFeedback(ctx context.Context, fb *FeedbackRecord) error
+	ReplaceFeedback(ctx context.Context, fb *FeedbackRecord) error
+	RecordFailure(ctx context.Context, f *FailureRecord) error
+	SetReview(ctx context.Context, rev *Review) error
+	SaveEmbeddings(ctx context.Context, embeddings []Embedding) error
+	SetSetting(ctx context.Context, rec *SettingRecord) error
+	DeleteSetting(ctx context.Context, subject SettingSubject, key string) error
+	AppendAudit(ctx context.Context, ev AuditEvent) (*AuditEntry, error)
+
+	// Reads
+	RecentHistory(ctx context.Context, chat ChatRef, limit int) ([]MessageRecord, error)
+	FindReply(ctx context.Context, chat ChatRef, externalID string) (*MessageRecord, error)
+	ListChats(ctx context.Context, opts ListChatsOptions) ([]Chat, error)
+	GetInteraction(ctx context.Context, id int64) (*InteractionRecord, error)
+	ListInteractions(ctx context.Context, filter InteractionFilter, page Page) (*InteractionPage, error)
+	CountInteractions(ctx context.Context, filter InteractionFilter) (int, error)
+	Search(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+	ListFeedback(ctx context.Context, interactionIDs []int64) ([]FeedbackRecord, error)
+	UsageStats(ctx context.Context, q StatsQuery) (*UsageStats, error)
+	InteractionsWithoutEmbedding(ctx context.Context, model string, afterID int64, limit int) ([]InteractionRecord, error)
+	GetEmbedding(ctx context.Context, interactionID int64, model string) ([]float32, error)
+	SimilarInteractions(ctx context.Context, q SimilarQuery) ([]SimilarResult, error)
+	ListSettings(ctx context.Context, subject SettingSubject) ([]SettingRecord, error)
+	ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+	VerifyAudit(ctx context.Context) (*AuditVerification, error)
+
+	// Privacy
+	ExportSubject(ctx context.Context, subject string) (*SubjectData, error)
+	EraseSubject(ctx context.Context, subject, requestedBy, reason string) (*Erasure, error)
+	Subjects() SubjectHasher
+
 	Close() error
 }
 
-// SQLiteStore implements the Store interface
-type SQLiteStore struct {
-	db *sql.DB
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// InteractionRecord describes a single prompt/response exchange together
+// with the configuration that produced it
+type InteractionRecord struct {
+	ID               int64     `json:"id"`
+	CreatedAt        time.Time `json:"created_at"` // defaults to the insert time when zero
+	Channel          string    `json:"channel,omitempty"`
+	ChatID           string    `json:"chat_id,omitempty"`   // external chat ID, e.g. the WhatsApp JID
+	SenderID         string    `json:"sender_id,omitempty"` // external sender ID
+	Prompt           string    `json:"prompt"`
+	Response         string    `json:"response"`
+	SystemPrompt     string    `json:"system_prompt,omitempty"`  // stored once in prompt_versions, referenced by hash
+	PromptVersion    string    `json:"prompt_version,omitempty"` // hash of SystemPrompt, filled in when reading
+	Model            string    `json:"model,omitempty"`
+	Provider         string    `json:"provider,omitempty"`
+	Temperature      float64   `json:"temperature"`
+	TopP             float64   `json:"top_p"`
+	MaxTokens        int       `json:"max_tokens,omitempty"`
+	Experiment       string    `json:"experiment,omitempty"`
+	Variant          string    `json:"variant,omitempty"`
+	LatencyMS        int64     `json:"latency_ms,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	Rating           int       `json:"rating,omitempty"` // net rating of the feedback, filled in when reading
+	Review           *Review   `json:"review,omitempty"` // operator review, filled in when reading; nil while pending
+}
+
+// SQLStore implements the Store interface on top of database/sql. Queries are
+// written once with ? placeholders and run on both SQLite and Postgres.
+type SQLStore struct {
+	db       *sql.DB
+	driver   string
+	keys     *encryption.Keyring // encrypts message content, nil for plaintext
+	subjects SubjectHasher       // hashes sender IDs kept after an erasure
+}
+
+// SQLiteStore is the name SQLStore had while SQLite was the only backend.
+//
+// Deprecated: use SQLStore.
+type SQLiteStore = SQLStore
+
+// Open connects to the configured database driver and brings the schema up to date
+func Open(cfg *config.Config) (*SQLStore, error) {
+	switch cfg.DBDriver {
+	case DriverSQLite:
+		return NewSQLiteStore(cfg)
+	case DriverPostgres:
+		return NewPostgresStore(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.DBDriver)
+	}
 }
 
 // NewSQLiteStore creates a new SQLite database connection with proper connection pooling
-func NewSQLiteStore(cfg *config.Config) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite", cfg.DBPath)
+// and brings the schema up to date
+func NewSQLiteStore(cfg *config.Config) (*SQLStore, error) {
+	db, err := openSQLite(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLStore(cfg, db, DriverSQLite)
+}
+
+// newSQLStore wraps an open database, loads the encryption keys and migrates
+// the schema, closing the database on failure
+func newSQLStore(cfg *config.Config, db *sql.DB, driver string) (*SQLStore, error) {
+	keys, err := encryption.FromConfig(cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store := &SQLStore{db: db, driver: driver, keys: keys, subjects: NewSubjectHasher(cfg.SubjectHashKey)}
+	if err := store.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// OpenMigrator opens the configured database without applying migrations so
+// the schema can be inspected or rolled back. Close the returned database when done.
+func OpenMigrator(cfg *config.Config) (*Migrator, *sql.DB, error) {
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch cfg.DBDriver {
+	case DriverSQLite:
+		db, err = openSQLite(cfg)
+	case DriverPostgres:
+		db, err = openPostgres(cfg)
+	default:
+		err = fmt.Errorf("unsupported database driver %q", cfg.DBDriver)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	migrator, err := NewMigrator(db, cfg.DBDriver)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if cfg.DBDriver == DriverSQLite {
+		if err := adoptLegacySchema(context.Background(), db, migrator); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+	return migrator, db, nil
+}
+
+// openSQLite opens and pings the database at the configured path
+func openSQLite(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(cfg.DBPath))
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
@@ -39,56 +188,170 @@ func NewSQLiteStore(cfg *config.Config) (*SQLiteStore, error) {
 		db.Close()
 		return nil, fmt.Errorf("connecting to database: %w", err)
 	}
+	return db, nil
+}
 
-	store := &SQLiteStore{db: db}
-	if err := store.initSchema(); err != nil {
-		db.Close()
-		return nil, err
+// sqliteDSN stores time values in SQLite's own sortable format, so they
+// compare correctly with CURRENT_TIMESTAMP defaults
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "_time_format=") {
+		return path
 	}
-
-	return store, nil
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_time_format=sqlite"
 }
 
-// initSchema ensures the required database schema exists
-func (s *SQLiteStore) initSchema() error {
-	createTable := `
-	CREATE TABLE IF NOT EXISTS interactions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_input TEXT NOT NULL,
-		llm_response TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	_, err := s.db.Exec(createTable)
+// migrate applies pending migrations and refuses to run against a database
+// migrated by a newer binary
+func (s *SQLStore) migrate(ctx context.Context) error {
+	migrator, err := NewMigrator(s.db, s.driver)
+	if err != nil {
+		return err
+	}
+	// Only SQLite databases predate schema_migrations
+	if s.driver == DriverSQLite {
+		if err := adoptLegacySchema(ctx, s.db, migrator); err != nil {
+			return err
+		}
+	}
+
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		return fmt.Errorf("creating interactions table: %w", err)
+		return err
+	}
+	for _, m := range applied {
+		log.Printf("Applied database migration %d_%s", m.Version, m.Name)
+	}
+	if len(applied) > 0 {
+		from, to := applied[0].Version-1, applied[len(applied)-1].Version
+		if _, err := AuditMigration(ctx, migrator, ActorMigrate, from, to); err != nil {
+			log.Printf("Failed to record the migration in the audit log: %v", err)
+		}
 	}
 	return nil
 }
 
-// LogInteraction stores a user interaction in the database
-func (s *SQLiteStore) LogInteraction(ctx context.Context, prompt, response string) error {
-	const query = `INSERT INTO interactions (user_input, llm_response) VALUES (?, ?)`
+// adoptLegacySchema baselines databases created before schema_migrations
+// existed, inferring their version from the columns of the interactions table
+func adoptLegacySchema(ctx context.Context, db *sql.DB, migrator *Migrator) error {
+	current, err := migrator.Current(ctx)
+	if err != nil || current > 0 {
+		return err
+	}
+
+	columns, err := tableColumns(ctx, db, "interactions")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	version := 1
+	switch {
+	case columns["experiment"]:
+		version = 3
+	case columns["prompt_version"]:
+		version = 2
+	}
+	log.Printf("Baselining existing database at schema version %d", version)
+	return migrator.Baseline(ctx, version)
+}
 
-	result, err := s.db.ExecContext(ctx, query, prompt, response)
+// tableColumns returns the column names of a table, or none if it doesn't exist
+func tableColumns(ctx context.Context, db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
 	if err != nil {
-		return fmt.Errorf("inserting interaction: %w", err)
+		return nil, fmt.Errorf("inspecting %s table: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scanning %s columns: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// LogInteraction stores a user interaction in the database and sets rec.ID
+func (s *SQLStore) LogInteraction(ctx context.Context, rec *InteractionRecord) error {
+	return insertInteraction(ctx, s.conn(), s.keys, rec)
+}
+
+// conn returns the database with placeholders adapted to the driver
+func (s *SQLStore) conn() queryer {
+	return bind(s.driver, s.db)
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *SQLStore) inTx(ctx context.Context, fn func(q queryer) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(bind(s.driver, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
 	}
+	return nil
+}
+
+// insertInteraction writes an interaction row, encrypting the prompt and
+// response when keys are given, and sets rec.ID
+func insertInteraction(ctx context.Context, q queryer, keys *encryption.Keyring, rec *InteractionRecord) error {
+	const query = `
+	INSERT INTO interactions (
+		timestamp, channel, chat_id, sender_id, user_input, llm_response, key_id, prompt_version, model, provider,
+		temperature, top_p, max_tokens, experiment, variant, latency_ms, prompt_tokens, completion_tokens
+	) VALUES (COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`
 
-	rows, err := result.RowsAffected()
+	version, err := ensurePromptVersion(ctx, q, rec.SystemPrompt)
 	if err != nil {
-		return fmt.Errorf("checking rows affected: %w", err)
+		return err
+	}
+	prompt, err := sealValue(keys, labelPrompt, rec.Prompt)
+	if err != nil {
+		return err
+	}
+	response, err := sealValue(keys, labelResponse, rec.Response)
+	if err != nil {
+		return err
 	}
 
-	if rows != 1 {
-		return fmt.Errorf("expected 1 row affected, got %d", rows)
+	err = q.QueryRowContext(ctx, query, nullTime(rec.CreatedAt),
+		nullString(rec.Channel), nullString(rec.ChatID), nullString(rec.SenderID), prompt, response, activeKeyID(keys),
+		nullString(version), nullString(rec.Model), nullString(rec.Provider), rec.Temperature, rec.TopP, rec.MaxTokens,
+		nullString(rec.Experiment), nullString(rec.Variant), rec.LatencyMS, rec.PromptTokens, rec.CompletionTokens,
+	).Scan(&rec.ID)
+	if err != nil {
+		return fmt.Errorf("inserting interaction: %w", err)
 	}
+	rec.PromptVersion = version
 
 	return nil
 }
 
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime stores zero times as NULL and others in UTC
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
 // Close closes the database connection
-func (s *SQLiteStore) Close() error {
+func (s *SQLStore) Close() error {
 	if err := s.db.Close(); err != nil {
 		return fmt.Errorf("closing database: %w", err)
 	}
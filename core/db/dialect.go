@@ -0,0 +1,90 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Supported database drivers, also used as the migrations directory names
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// bind wraps q so queries written with ? placeholders run on the given driver
+func bind(driver string, q queryer) queryer {
+	if driver != DriverPostgres {
+		return q
+	}
+	return numberedQueryer{q}
+}
+
+// numberedQueryer rewrites ? placeholders to the $1, $2, ... form Postgres expects
+type numberedQueryer struct {
+	q queryer
+}
+
+func (n numberedQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return n.q.ExecContext(ctx, numberPlaceholders(query), args...)
+}
+
+func (n numberedQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return n.q.QueryContext(ctx, numberPlaceholders(query), args...)
+}
+
+func (n numberedQueryer) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return n.q.QueryRowContext(ctx, numberPlaceholders(query), args...)
+}
+
+// numberPlaceholders replaces each ? outside string literals with $n
+func numberPlaceholders(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// utcDay returns an expression formatting the time column col as its UTC
+// date, YYYY-MM-DD
+func utcDay(driver, col string) string {
+	if driver == DriverPostgres {
+		return "to_char(" + col + " AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	}
+	return "strftime('%Y-%m-%d', " + col + ")"
+}
+
+// utcHour returns an expression formatting the time column col as its UTC
+// hour, 00 to 23
+func utcHour(driver, col string) string {
+	if driver == DriverPostgres {
+		return "to_char(" + col + " AT TIME ZONE 'UTC', 'HH24')"
+	}
+	return "strftime('%H', " + col + ")"
+}
@@ -0,0 +1,252 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// defaultSimilarLimit is the number of similar interactions returned when no limit is set
+const defaultSimilarLimit = 10
+
+// Embedding is the vector of an interaction's text under one embedding model
+type Embedding struct {
+	InteractionID int64
+	Model         string
+	Vector        []float32
+}
+
+// SimilarQuery finds the interactions whose embeddings are closest to a vector
+type SimilarQuery struct {
+	Model     string    // only embeddings of this model are compared
+	Vector    []float32 // embedding of the text searched for
+	Filter    InteractionFilter
+	ExcludeID int64   // leaves out the interaction the vector belongs to
+	MinScore  float64 // lowest cosine similarity returned
+	Limit     int
+}
+
+// SimilarResult is an interaction with its cosine similarity to the query
+type SimilarResult struct {
+	Interaction InteractionRecord
+	Score       float64
+}
+
+// SaveEmbeddings stores embeddings in one transaction, replacing those of
+// the same interaction and model
+func (s *SQLStore) SaveEmbeddings(ctx context.Context, embeddings []Embedding) error {
+	const query = `
+	INSERT INTO embeddings (interaction_id, model, dims, vector)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (interaction_id, model) DO UPDATE SET
+		dims = excluded.dims, vector = excluded.vector, created_at = CURRENT_TIMESTAMP`
+
+	return s.inTx(ctx, func(q queryer) error {
+		for _, e := range embeddings {
+			if e.Model == "" || len(e.Vector) == 0 {
+				return fmt.Errorf("embedding of interaction %d needs a model and a vector", e.InteractionID)
+			}
+			if _, err := q.ExecContext(ctx, query, e.InteractionID, e.Model, len(e.Vector), encodeVector(e.Vector)); err != nil {
+				return fmt.Errorf("inserting embedding of interaction %d: %w", e.InteractionID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// InteractionsWithoutEmbedding returns up to limit interactions after afterID
+// in ID order that have no embedding from the model yet
+func (s *SQLStore) InteractionsWithoutEmbedding(ctx context.Context, model string, afterID int64, limit int) ([]InteractionRecord, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	query := "SELECT " + interactionColumns + " FROM " + interactionSource + `
+		WHERE i.id > ? AND NOT EXISTS (SELECT 1 FROM embeddings e WHERE e.interaction_id = i.id AND e.model = ?)
+		ORDER BY i.id LIMIT ?`
+	rows, err := s.conn().QueryContext(ctx, query, afterID, model, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying interactions without embedding: %w", err)
+	}
+	defer rows.Close()
+
+	var records []InteractionRecord
+	for rows.Next() {
+		rec, err := s.scanInteraction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning interaction: %w", err)
+		}
+		records = append(records, *rec)
+	}
+	return records, rows.Err()
+}
+
+// GetEmbedding returns the vector of an interaction under the model or ErrNotFound
+func (s *SQLStore) GetEmbedding(ctx context.Context, interactionID int64, model string) ([]float32, error) {
+	var data []byte
+	err := s.conn().QueryRowContext(ctx, "SELECT vector FROM embeddings WHERE interaction_id = ? AND model = ?",
+		interactionID, model).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("embedding of interaction %d: %w", interactionID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying embedding of interaction %d: %w", interactionID, err)
+	}
+	return decodeVector(data)
+}
+
+// SimilarInteractions compares the query vector with every stored embedding
+// of the model matching the filter and returns the closest interactions,
+// most similar first
+func (s *SQLStore) SimilarInteractions(ctx context.Context, q SimilarQuery) ([]SimilarResult, error) {
+	if q.Model == "" || len(q.Vector) == 0 {
+		return nil, errors.New("a similarity search needs a model and a vector")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
+	queryNorm := norm(q.Vector)
+	if queryNorm == 0 {
+		return nil, nil
+	}
+
+	where, args := q.Filter.where()
+	query := "SELECT e.interaction_id, e.vector FROM embeddings e JOIN interactions i ON i.id = e.interaction_id" +
+		" WHERE e.model = ? AND e.dims = ? AND e.interaction_id <> ? AND " + where
+	args = append([]interface{}{q.Model, len(q.Vector), q.ExcludeID}, args...)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	// The best matches so far, worst on top so it is replaced first
+	best := &scoreHeap{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("scanning embedding: %w", err)
+		}
+		vector, err := decodeVector(data)
+		if err != nil {
+			return nil, fmt.Errorf("embedding of interaction %d: %w", id, err)
+		}
+		score := cosine(q.Vector, queryNorm, vector)
+		if score < q.MinScore {
+			continue
+		}
+		if best.Len() < limit {
+			heap.Push(best, scoredID{id: id, score: score})
+		} else if score > (*best)[0].score {
+			(*best)[0] = scoredID{id: id, score: score}
+			heap.Fix(best, 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading embeddings: %w", err)
+	}
+
+	scored := make([]scoredID, best.Len())
+	for i := len(scored) - 1; i >= 0; i-- {
+		scored[i] = heap.Pop(best).(scoredID)
+	}
+	ids := make([]int64, len(scored))
+	for i, sc := range scored {
+		ids[i] = sc.id
+	}
+	records, err := s.InteractionsByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]InteractionRecord, len(records))
+	for _, rec := range records {
+		byID[rec.ID] = rec
+	}
+
+	results := make([]SimilarResult, 0, len(scored))
+	for _, sc := range scored {
+		// An interaction deleted since the embeddings were read is left out
+		if rec, ok := byID[sc.id]; ok {
+			results = append(results, SimilarResult{Interaction: rec, Score: sc.score})
+		}
+	}
+	return results, nil
+}
+
+// scoredID is an interaction with its similarity to the query
+type scoredID struct {
+	id    int64
+	score float64
+}
+
+// scoreHeap is a min-heap of scores, ties broken towards newer interactions
+// so older ones rank higher
+type scoreHeap []scoredID
+
+func (h scoreHeap) Len() int { return len(h) }
+func (h scoreHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score < h[j].score
+	}
+	return h[i].id > h[j].id
+}
+func (h scoreHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scoreHeap) Push(x interface{}) { *h = append(*h, x.(scoredID)) }
+func (h *scoreHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// encodeVector stores a vector as little-endian float32 values
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+// decodeVector reads a vector written by encodeVector
+func decodeVector(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("vector of %d bytes is not a float32 array", len(data))
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector, nil
+}
+
+// norm returns the Euclidean length of a vector
+func norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+// cosine returns the cosine similarity of a query with a precomputed norm
+// and another vector of the same length
+func cosine(query []float32, queryNorm float64, v []float32) float64 {
+	var dot float64
+	for i, x := range v {
+		dot += float64(query[i]) * float64(x)
+	}
+	vNorm := norm(v)
+	if vNorm == 0 {
+		return 0
+	}
+	return dot / (queryNorm * vNorm)
+}
@@ -0,0 +1,230 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// Labels binding encrypted values to their column, so a value copied into
+// another column does not decrypt
+const (
+	labelPrompt     = "interactions.user_input"
+	labelResponse   = "interactions.llm_response"
+	labelContent    = "messages.content"
+	labelQuoted     = "messages.quoted_content"
+	labelComment    = "feedback.comment"
+	labelCorrection = "feedback.correction"
+)
+
+// ErrNoKeys is returned when encrypted content is read or rotated without keys
+var ErrNoKeys = errors.New("no encryption keys configured")
+
+// encryptedTable describes the content columns of a table that are encrypted
+type encryptedTable struct {
+	name    string
+	columns []string
+	labels  []string
+}
+
+// encryptedTables lists every table with encrypted content
+var encryptedTables = []encryptedTable{
+	{"interactions", []string{"user_input", "llm_response"}, []string{labelPrompt, labelResponse}},
+	{"messages", []string{"content", "quoted_content"}, []string{labelContent, labelQuoted}},
+	{"feedback", []string{"comment", "correction"}, []string{labelComment, labelCorrection}},
+}
+
+// activeKeyID is the key_id stored with new rows, NULL when encryption is off
+func activeKeyID(keys *encryption.Keyring) sql.NullString {
+	if keys == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: keys.ActiveKeyID(), Valid: true}
+}
+
+// sealValue encrypts a column value with the active key, or returns it
+// unchanged when encryption is off
+func sealValue(keys *encryption.Keyring, label, plaintext string) (string, error) {
+	if keys == nil {
+		return plaintext, nil
+	}
+	sealed, err := keys.Seal(label, plaintext)
+	if err != nil {
+		return "", fmt.Errorf("encrypting %s: %w", label, err)
+	}
+	return sealed, nil
+}
+
+// openValue decrypts a column value in place when the row has a key ID
+func openValue(keys *encryption.Keyring, keyID sql.NullString, label string, value *string) error {
+	if !keyID.Valid || *value == "" {
+		return nil
+	}
+	if keys == nil {
+		return fmt.Errorf("reading %s encrypted with key %s: %w", label, keyID.String, ErrNoKeys)
+	}
+	plaintext, err := keys.Open(keyID.String, label, *value)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", label, err)
+	}
+	*value = plaintext
+	return nil
+}
+
+// KeyUsage counts the rows of a table encrypted with one key
+type KeyUsage struct {
+	Table string `json:"table"`
+	KeyID string `json:"key_id,omitempty"` // empty for plaintext rows
+	Rows  int    `json:"rows"`
+}
+
+// EncryptionStatus reports how many rows of every encrypted table use each key
+func (s *SQLStore) EncryptionStatus(ctx context.Context) ([]KeyUsage, error) {
+	var usage []KeyUsage
+	for _, t := range encryptedTables {
+		rows, err := s.conn().QueryContext(ctx,
+			"SELECT key_id, COUNT(*) FROM "+t.name+" GROUP BY key_id ORDER BY key_id")
+		if err != nil {
+			return nil, fmt.Errorf("counting %s keys: %w", t.name, err)
+		}
+		for rows.Next() {
+			u := KeyUsage{Table: t.name}
+			var keyID sql.NullString
+			if err := rows.Scan(&keyID, &u.Rows); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scanning %s keys: %w", t.name, err)
+			}
+			u.KeyID = keyID.String
+			usage = append(usage, u)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s keys: %w", t.name, err)
+		}
+	}
+	return usage, nil
+}
+
+// RotationOptions controls a key rotation
+type RotationOptions struct {
+	BatchSize int // rows re-encrypted per transaction
+	// Decrypt stores the content in plaintext instead of under the active
+	// key, e.g. before encryption is switched off
+	Decrypt bool
+	// Progress is called after every batch with the table and rows done so far
+	Progress func(table string, done int)
+}
+
+// RotateKeys re-encrypts every row that is not stored under the active key,
+// including plaintext rows, in batches. Rows written during the rotation
+// already use the active key.
+func (s *SQLStore) RotateKeys(ctx context.Context, opts RotationOptions) (map[string]int, error) {
+	if s.keys == nil {
+		return nil, ErrNoKeys
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 500
+	}
+
+	target := activeKeyID(s.keys)
+	keys := s.keys
+	if opts.Decrypt {
+		target = sql.NullString{}
+		keys = nil
+	}
+
+	done := make(map[string]int)
+	for _, t := range encryptedTables {
+		var afterID int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return done, err
+			}
+			n, lastID, err := s.rotateBatch(ctx, t, keys, target, afterID, opts.BatchSize)
+			if err != nil {
+				return done, err
+			}
+			if n == 0 {
+				break
+			}
+			done[t.name] += n
+			afterID = lastID
+			if opts.Progress != nil {
+				opts.Progress(t.name, done[t.name])
+			}
+		}
+	}
+	return done, nil
+}
+
+// rotateBatch re-encrypts up to limit rows after afterID that are not under
+// the target key and returns how many it changed and the last ID seen
+func (s *SQLStore) rotateBatch(ctx context.Context, t encryptedTable, keys *encryption.Keyring, target sql.NullString, afterID int64, limit int) (int, int64, error) {
+	columns := t.columns[0] + ", " + t.columns[1]
+	where := "key_id IS NOT NULL"
+	args := []interface{}{afterID}
+	if target.Valid {
+		where = "(key_id IS NULL OR key_id <> ?)"
+		args = append(args, target.String)
+	}
+	args = append(args, limit)
+
+	var changed int
+	var lastID int64
+	err := s.inTx(ctx, func(q queryer) error {
+		rows, err := q.QueryContext(ctx,
+			"SELECT id, key_id, "+columns+" FROM "+t.name+" WHERE id > ? AND "+where+" ORDER BY id LIMIT ?", args...)
+		if err != nil {
+			return fmt.Errorf("selecting %s to rotate: %w", t.name, err)
+		}
+
+		type row struct {
+			id     int64
+			keyID  sql.NullString
+			values [2]sql.NullString
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.keyID, &r.values[0], &r.values[1]); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning %s to rotate: %w", t.name, err)
+			}
+			batch = append(batch, r)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s to rotate: %w", t.name, err)
+		}
+
+		update := "UPDATE " + t.name + " SET " + t.columns[0] + " = ?, " + t.columns[1] + " = ?, key_id = ? WHERE id = ?"
+		for _, r := range batch {
+			var values [2]sql.NullString
+			for i, v := range r.values {
+				if !v.Valid {
+					continue
+				}
+				if err := openValue(s.keys, r.keyID, t.labels[i], &v.String); err != nil {
+					return fmt.Errorf("%s %d: %w", t.name, r.id, err)
+				}
+				if v.String, err = sealValue(keys, t.labels[i], v.String); err != nil {
+					return err
+				}
+				values[i] = v
+			}
+			if _, err := q.ExecContext(ctx, update, values[0], values[1], target, r.id); err != nil {
+				return fmt.Errorf("updating %s %d: %w", t.name, r.id, err)
+			}
+			lastID = r.id
+		}
+		changed = len(batch)
+		return nil
+	})
+	return changed, lastID, err
+}
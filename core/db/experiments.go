@@ -0,0 +1,91 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// VariantStats aggregates the interactions of one experiment variant
+type VariantStats struct {
+	Variant          string
+	Interactions     int
+	Chats            int
+	AvgLatencyMS     float64
+	PromptTokens     int64
+	CompletionTokens int64
+	PositiveFeedback int // positive ratings of the variant's interactions
+	NegativeFeedback int // negative ratings of the variant's interactions
+}
+
+// NetFeedback returns the positive minus the negative ratings
+func (v VariantStats) NetFeedback() int {
+	return v.PositiveFeedback - v.NegativeFeedback
+}
+
+// AvgConversationLength returns the average number of interactions per chat
+func (v VariantStats) AvgConversationLength() float64 {
+	if v.Chats == 0 {
+		return 0
+	}
+	return float64(v.Interactions) / float64(v.Chats)
+}
+
+// ExperimentReport returns per-variant statistics for an experiment
+func (s *SQLStore) ExperimentReport(ctx context.Context, experiment string) ([]VariantStats, error) {
+	const query = `
+	SELECT variant, COUNT(*), COUNT(DISTINCT chat_id), AVG(latency_ms),
+		COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0)
+	FROM interactions
+	WHERE experiment = ?
+	GROUP BY variant
+	ORDER BY variant`
+
+	rows, err := s.conn().QueryContext(ctx, query, experiment)
+	if err != nil {
+		return nil, fmt.Errorf("querying experiment report: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []VariantStats
+	byVariant := make(map[string]int)
+	for rows.Next() {
+		var v VariantStats
+		var latency sql.NullFloat64
+		if err := rows.Scan(&v.Variant, &v.Interactions, &v.Chats, &latency, &v.PromptTokens, &v.CompletionTokens); err != nil {
+			return nil, fmt.Errorf("scanning variant stats: %w", err)
+		}
+		v.AvgLatencyMS = latency.Float64
+		byVariant[v.Variant] = len(stats)
+		stats = append(stats, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading variant stats: %w", err)
+	}
+
+	// Feedback is counted separately so ratings do not multiply the interactions
+	const feedbackQuery = `
+	SELECT i.variant, COUNT(CASE WHEN f.rating > 0 THEN 1 END), COUNT(CASE WHEN f.rating < 0 THEN 1 END)
+	FROM feedback f
+	JOIN interactions i ON i.id = f.interaction_id
+	WHERE i.experiment = ?
+	GROUP BY i.variant`
+
+	fbRows, err := s.conn().QueryContext(ctx, feedbackQuery, experiment)
+	if err != nil {
+		return nil, fmt.Errorf("querying experiment feedback: %w", err)
+	}
+	defer fbRows.Close()
+	for fbRows.Next() {
+		var variant string
+		var positive, negative int
+		if err := fbRows.Scan(&variant, &positive, &negative); err != nil {
+			return nil, fmt.Errorf("scanning variant feedback: %w", err)
+		}
+		if i, ok := byVariant[variant]; ok {
+			stats[i].PositiveFeedback, stats[i].NegativeFeedback = positive, negative
+		}
+	}
+	return stats, fbRows.Err()
+}
@@ -3,9 +3,11 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"time"
 )
 
 type Interaction struct {
@@ -13,27 +15,240 @@ type Interaction struct {
 	Completion string `json:"completion"`
 }
 
-// ExportAsJSONL exports all interactions to a JSONL file
-func (s *SQLiteStore) ExportAsJSONL(ctx context.Context, filename string) error {
-	rows, err := s.db.QueryContext(ctx, "SELECT user_input, llm_response FROM interactions")
+// Conversation is one training example: consecutive interactions of a chat
+// that share a system prompt, or a single interaction
+type Conversation struct {
+	ChatID       string
+	SystemPrompt string
+	Interactions []InteractionRecord
+}
+
+// Turn is a single message of a conversation
+type Turn struct {
+	Role    string
+	Content string
+}
+
+// Turns returns the user and assistant messages of the conversation in order
+func (c *Conversation) Turns() []Turn {
+	turns := make([]Turn, 0, 2*len(c.Interactions))
+	for _, rec := range c.Interactions {
+		turns = append(turns, Turn{Role: RoleUser, Content: rec.Prompt}, Turn{Role: RoleAssistant, Content: rec.Response})
+	}
+	return turns
+}
+
+// ExportEncoder writes conversations in one dataset format
+type ExportEncoder interface {
+	Encode(conv *Conversation) error
+	// Close flushes buffered output; it does not close the underlying writer
+	Close() error
+}
+
+// ExportFormat creates an encoder writing to w
+type ExportFormat func(w io.Writer, opts ExportOptions) ExportEncoder
+
+var exportFormats = map[string]ExportFormat{}
+
+// RegisterExportFormat makes a dataset format available to Export under name
+func RegisterExportFormat(name string, format ExportFormat) {
+	exportFormats[name] = format
+}
+
+// ExportFormats returns the names of the registered formats
+func ExportFormats() []string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportOptions controls what is exported and how
+type ExportOptions struct {
+	Format string
+	Filter InteractionFilter
+
+	// MultiTurn groups consecutive interactions of a chat into one
+	// conversation. A conversation ends after SessionGap without messages,
+	// when the system prompt changes or after MaxTurns interactions.
+	MultiTurn  bool
+	SessionGap time.Duration
+	MaxTurns   int
+
+	// OmitSystemPrompt leaves the system prompt out of formats that support it
+	OmitSystemPrompt bool
+
+	// Dedup removes exact and near-duplicate conversations
+	Dedup DedupOptions
+}
+
+// Default session settings for multi-turn exports
+const (
+	defaultSessionGap = 30 * time.Minute
+	defaultMaxTurns   = 20
+)
+
+// Export streams the interactions matching the filter from any store to w in
+// the chosen format and returns the number of conversations written
+func Export(ctx context.Context, store Store, w io.Writer, opts ExportOptions) (int, error) {
+	newEncoder, ok := exportFormats[opts.Format]
+	if !ok {
+		return 0, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+	if opts.SessionGap <= 0 {
+		opts.SessionGap = defaultSessionGap
+	}
+	if opts.MaxTurns <= 0 {
+		opts.MaxTurns = defaultMaxTurns
+	}
+	dedup, err := newDeduper(opts.Dedup)
 	if err != nil {
-		return fmt.Errorf("querying interactions: %w", err)
+		return 0, err
+	}
+	// Dropping every duplicated example needs the cluster sizes before the first is written
+	if dedup != nil && dedup.opts.Action == DedupDrop {
+		if err := eachConversation(ctx, store, opts, dedup.count); err != nil {
+			return 0, err
+		}
 	}
-	defer rows.Close()
 
+	enc := newEncoder(w, opts)
+	written := 0
+	err = eachConversation(ctx, store, opts, func(conv *Conversation) error {
+		if dedup != nil && !dedup.keep(conv) {
+			return nil
+		}
+		if err := enc.Encode(conv); err != nil {
+			return fmt.Errorf("writing conversation: %w", err)
+		}
+		written++
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return written, fmt.Errorf("flushing export: %w", err)
+	}
+	if dedup != nil && opts.Dedup.Report != nil {
+		*opts.Dedup.Report = dedup.report()
+	}
+	return written, nil
+}
+
+// eachConversation calls emit with the conversations built from the
+// interactions matching the filter, in the order they end
+func eachConversation(ctx context.Context, store Store, opts ExportOptions, emit func(conv *Conversation) error) error {
+	// Conversations still open, by chat
+	open := make(map[string]*Conversation)
+
+	err := eachInteractionPage(ctx, store, opts.Filter, func(items []InteractionRecord) error {
+		for _, rec := range items {
+			// Edited interactions are exported with their corrected response
+			if rec.Review != nil && rec.Review.Status == ReviewEdited {
+				rec.Response = rec.Review.CorrectedResponse
+			}
+			if !opts.MultiTurn || rec.ChatID == "" {
+				if err := emit(&Conversation{ChatID: rec.ChatID, SystemPrompt: rec.SystemPrompt, Interactions: []InteractionRecord{rec}}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			conv := open[rec.ChatID]
+			if conv != nil && !continues(conv, rec, opts) {
+				if err := emit(conv); err != nil {
+					return err
+				}
+				conv = nil
+			}
+			if conv == nil {
+				conv = &Conversation{ChatID: rec.ChatID, SystemPrompt: rec.SystemPrompt}
+				open[rec.ChatID] = conv
+			}
+			conv.Interactions = append(conv.Interactions, rec)
+		}
+
+		// Conversations idle for longer than the gap cannot continue, so
+		// write them now instead of holding every chat in memory
+		cutoff := items[len(items)-1].CreatedAt.Add(-opts.SessionGap)
+		return flushConversations(open, emit, func(conv *Conversation) bool {
+			return conv.Interactions[len(conv.Interactions)-1].CreatedAt.Before(cutoff)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return flushConversations(open, emit, func(*Conversation) bool { return true })
+}
+
+// exportPageSize is the number of interactions read per query while exporting
+const exportPageSize = 500
+
+// eachInteractionPage calls fn with every non-empty page of interactions
+// matching the filter, in ID order
+func eachInteractionPage(ctx context.Context, store Store, filter InteractionFilter, fn func(items []InteractionRecord) error) error {
+	page := Page{Limit: exportPageSize}
+	for {
+		result, err := store.ListInteractions(ctx, filter, page)
+		if err != nil {
+			return err
+		}
+		if len(result.Items) > 0 {
+			if err := fn(result.Items); err != nil {
+				return err
+			}
+		}
+		if result.NextAfterID == 0 {
+			return nil
+		}
+		page.AfterID = result.NextAfterID
+	}
+}
+
+// flushConversations emits and forgets the open conversations selected by
+// done, in the order they started
+func flushConversations(open map[string]*Conversation, emit func(*Conversation) error, done func(*Conversation) bool) error {
+	var finished []*Conversation
+	for chatID, conv := range open {
+		if done(conv) {
+			finished = append(finished, conv)
+			delete(open, chatID)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Interactions[0].ID < finished[j].Interactions[0].ID
+	})
+	for _, conv := range finished {
+		if err := emit(conv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// continues reports whether rec belongs to the open conversation
+func continues(conv *Conversation, rec InteractionRecord, opts ExportOptions) bool {
+	last := conv.Interactions[len(conv.Interactions)-1]
+	return len(conv.Interactions) < opts.MaxTurns &&
+		rec.SystemPrompt == conv.SystemPrompt &&
+		rec.CreatedAt.Sub(last.CreatedAt) <= opts.SessionGap
+}
+
+// ExportAsJSONL exports all interactions to a JSONL file in the completion
+// format. It is kept for callers written before Export.
+func (s *SQLStore) ExportAsJSONL(ctx context.Context, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("creating file: %w", err)
 	}
 	defer file.Close()
 
-	for rows.Next() {
-		var entry Interaction
-		if err := rows.Scan(&entry.Prompt, &entry.Completion); err != nil {
-			return fmt.Errorf("scanning row: %w", err)
-		}
-		line, _ := json.Marshal(entry)
-		fmt.Fprintln(file, string(line))
+	if _, err := Export(ctx, s, file, ExportOptions{Format: "completion"}); err != nil {
+		return err
 	}
-	return rows.Err()
+	return file.Close()
 }
@@ -0,0 +1,368 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Deduplication modes
+const (
+	DedupOff   = ""
+	DedupExact = "exact" // the same text after normalizing case, punctuation and spacing
+	DedupNear  = "near"  // exact duplicates and texts at least Similarity alike
+)
+
+// Deduplication actions, applied to every cluster of duplicates
+const (
+	DedupKeepOne = "keep-one" // keep the first example
+	DedupCap     = "cap"      // keep the first MaxPerCluster examples
+	DedupDrop    = "drop"     // drop every example that has duplicates
+)
+
+// Texts compared for deduplication
+const (
+	DedupByPrompt  = "prompt"  // the user messages
+	DedupByExample = "example" // the user and assistant messages
+)
+
+// defaultDedupSimilarity is the near-duplicate threshold when none is set
+const defaultDedupSimilarity = 0.8
+
+// DedupOptions controls the removal of duplicate examples from an export.
+// Duplicate examples form a cluster, represented by its first example in
+// export order.
+type DedupOptions struct {
+	Mode   string
+	Action string // defaults to DedupKeepOne
+	By     string // defaults to DedupByPrompt
+
+	// Similarity is the estimated Jaccard similarity of the texts'
+	// character shingles from which near mode treats them as duplicates,
+	// 0.8 when zero. Values below 0.5 miss many near duplicates.
+	Similarity float64
+
+	// MaxPerCluster is the number of examples kept per cluster by DedupCap
+	MaxPerCluster int
+
+	// Report is filled in by Export when set
+	Report *DedupReport
+}
+
+// DedupReport summarizes the duplicates found while exporting
+type DedupReport struct {
+	Examples int            `json:"examples"` // examples before deduplication
+	Kept     int            `json:"kept"`
+	Dropped  int            `json:"dropped"`
+	Clusters []DedupCluster `json:"clusters"` // clusters with more than one example, largest first
+}
+
+// DedupCluster is a group of duplicate examples
+type DedupCluster struct {
+	Size           int     `json:"size"`
+	Kept           int     `json:"kept"`
+	Exact          bool    `json:"exact"`           // all examples have the same normalized text
+	Text           string  `json:"text"`            // compared text of the first example, shortened
+	InteractionIDs []int64 `json:"interaction_ids"` // first interaction of every example
+}
+
+// MinHash parameters. Candidate clusters are found by locality-sensitive
+// hashing: texts sharing all rows of at least one band are compared.
+const (
+	minhashSize      = 64
+	minhashBands     = 16
+	minhashRows      = minhashSize / minhashBands
+	shingleSize      = 4   // characters per shingle
+	maxDedupTextSize = 200 // characters of the text kept in the report
+)
+
+// signature is the MinHash of a text's shingles
+type signature [minhashSize]uint64
+
+// minhashSeeds are the multipliers and offsets of the hash functions
+var minhashSeeds = func() (seeds [minhashSize][2]uint64) {
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range seeds {
+		state = mix64(state + 0x9E3779B97F4A7C15)
+		seeds[i][0] = state | 1 // odd, so the multiplication is a permutation
+		state = mix64(state + 0x9E3779B97F4A7C15)
+		seeds[i][1] = state
+	}
+	return seeds
+}()
+
+// dedupCluster is a cluster together with the signature of its first example
+type dedupCluster struct {
+	DedupCluster
+	index int
+	sig   signature
+}
+
+// deduper assigns exported conversations to clusters of duplicates and
+// decides which of them are written
+type deduper struct {
+	opts          DedupOptions
+	limit         int // examples kept per cluster, unless dropping
+	examples      int
+	kept          int
+	clusters      []*dedupCluster
+	exact         map[[sha256.Size]byte]*dedupCluster
+	bands         [minhashBands]map[uint64][]*dedupCluster
+	byInteraction map[int64]*dedupCluster // clusters found by count, by first interaction ID
+}
+
+// newDeduper validates the options and returns nil when deduplication is off
+func newDeduper(opts DedupOptions) (*deduper, error) {
+	switch opts.Mode {
+	case DedupOff:
+		return nil, nil
+	case DedupExact, DedupNear:
+	default:
+		return nil, fmt.Errorf("unknown dedup mode %q, expected exact or near", opts.Mode)
+	}
+	if opts.Action == "" {
+		opts.Action = DedupKeepOne
+	}
+	if opts.By == "" {
+		opts.By = DedupByPrompt
+	}
+	if opts.Similarity == 0 {
+		opts.Similarity = defaultDedupSimilarity
+	}
+
+	d := &deduper{
+		opts:          opts,
+		limit:         1,
+		exact:         make(map[[sha256.Size]byte]*dedupCluster),
+		byInteraction: make(map[int64]*dedupCluster),
+	}
+	switch opts.Action {
+	case DedupKeepOne, DedupDrop:
+	case DedupCap:
+		if opts.MaxPerCluster <= 0 {
+			return nil, errors.New("capping duplicates needs a positive number of examples per cluster")
+		}
+		d.limit = opts.MaxPerCluster
+	default:
+		return nil, fmt.Errorf("unknown dedup action %q, expected keep-one, cap or drop", opts.Action)
+	}
+	if opts.By != DedupByPrompt && opts.By != DedupByExample {
+		return nil, fmt.Errorf("unknown dedup text %q, expected prompt or example", opts.By)
+	}
+	if opts.Similarity <= 0 || opts.Similarity > 1 {
+		return nil, fmt.Errorf("dedup similarity %g is not between 0 and 1", opts.Similarity)
+	}
+	for i := range d.bands {
+		d.bands[i] = make(map[uint64][]*dedupCluster)
+	}
+	return d, nil
+}
+
+// count assigns a conversation to its cluster ahead of keep, so cluster
+// sizes are known before the first example is written
+func (d *deduper) count(conv *Conversation) error {
+	d.byInteraction[conv.Interactions[0].ID] = d.add(conv)
+	return nil
+}
+
+// keep reports whether a conversation is written
+func (d *deduper) keep(conv *Conversation) bool {
+	c, ok := d.byInteraction[conv.Interactions[0].ID]
+	if !ok {
+		c = d.add(conv)
+	}
+
+	d.examples++
+	keep := c.Kept < d.limit
+	if d.opts.Action == DedupDrop {
+		keep = c.Size == 1
+	}
+	if keep {
+		c.Kept++
+		d.kept++
+	}
+	return keep
+}
+
+// add finds the cluster of a conversation among the earlier ones, or starts
+// a new cluster
+func (d *deduper) add(conv *Conversation) *dedupCluster {
+	text := d.text(conv)
+	normalized := dedupNormalize(text)
+	key := sha256.Sum256([]byte(normalized))
+
+	c := d.exact[key]
+	if c == nil {
+		var sig signature
+		if d.opts.Mode == DedupNear {
+			sig = minhash(normalized)
+			if c = d.similar(sig); c != nil {
+				c.Exact = false
+			}
+		}
+		if c == nil {
+			c = d.newCluster(text, sig)
+		}
+		d.exact[key] = c
+	}
+
+	c.Size++
+	c.InteractionIDs = append(c.InteractionIDs, conv.Interactions[0].ID)
+	return c
+}
+
+// newCluster starts a cluster represented by text
+func (d *deduper) newCluster(text string, sig signature) *dedupCluster {
+	c := &dedupCluster{
+		DedupCluster: DedupCluster{Exact: true, Text: clipText(oneLineText(text), maxDedupTextSize)},
+		index:        len(d.clusters),
+		sig:          sig,
+	}
+	d.clusters = append(d.clusters, c)
+	if d.opts.Mode == DedupNear {
+		for band, key := range sig.bandKeys() {
+			d.bands[band][key] = append(d.bands[band][key], c)
+		}
+	}
+	return c
+}
+
+// similar returns the cluster most similar to the signature, preferring
+// older clusters, or nil when none reaches the threshold
+func (d *deduper) similar(sig signature) *dedupCluster {
+	var best *dedupCluster
+	bestSimilarity := d.opts.Similarity
+	seen := make(map[*dedupCluster]bool)
+	for band, key := range sig.bandKeys() {
+		for _, c := range d.bands[band][key] {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			s := sig.similarity(&c.sig)
+			if s > bestSimilarity || (s == bestSimilarity && (best == nil || c.index < best.index)) {
+				best, bestSimilarity = c, s
+			}
+		}
+	}
+	return best
+}
+
+// report returns the cluster sizes found so far
+func (d *deduper) report() DedupReport {
+	r := DedupReport{Examples: d.examples, Kept: d.kept, Dropped: d.examples - d.kept, Clusters: []DedupCluster{}}
+	var clusters []*dedupCluster
+	for _, c := range d.clusters {
+		if c.Size > 1 {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Size > clusters[j].Size
+	})
+	for _, c := range clusters {
+		r.Clusters = append(r.Clusters, c.DedupCluster)
+	}
+	return r
+}
+
+// text returns the text of a conversation that is compared for duplicates
+func (d *deduper) text(conv *Conversation) string {
+	parts := make([]string, 0, 2*len(conv.Interactions))
+	for _, rec := range conv.Interactions {
+		parts = append(parts, rec.Prompt)
+		if d.opts.By == DedupByExample {
+			parts = append(parts, rec.Response)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// dedupNormalize lowercases text and reduces it to its words, so texts
+// differing only in punctuation and spacing are equal. Text without any
+// letters or digits, like a lone emoji, is only lowercased and collapsed.
+func dedupNormalize(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return normalize(s)
+	}
+	return strings.Join(words, " ")
+}
+
+// minhash computes the signature of the character shingles of text
+func minhash(text string) signature {
+	var sig signature
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+
+	runes := []rune(text)
+	shingles := max(len(runes)-shingleSize+1, 1)
+	for i := 0; i < shingles; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i:min(i+shingleSize, len(runes))])))
+		shingle := h.Sum64()
+		for j, seed := range minhashSeeds {
+			if v := mix64(shingle*seed[0] + seed[1]); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig
+}
+
+// similarity estimates the Jaccard similarity of the shingles behind two signatures
+func (s *signature) similarity(other *signature) float64 {
+	same := 0
+	for i := range s {
+		if s[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / minhashSize
+}
+
+// bandKeys hashes every band of rows of the signature
+func (s *signature) bandKeys() [minhashBands]uint64 {
+	var keys [minhashBands]uint64
+	var buf [8 * minhashRows]byte
+	for band := range keys {
+		for row := 0; row < minhashRows; row++ {
+			binary.LittleEndian.PutUint64(buf[8*row:], s[band*minhashRows+row])
+		}
+		h := fnv.New64a()
+		h.Write(buf[:])
+		keys[band] = h.Sum64()
+	}
+	return keys
+}
+
+// mix64 scrambles the bits of x (the splitmix64 finalizer)
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// oneLineText collapses whitespace so text prints on a single line
+func oneLineText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// clipText shortens s to at most n characters
+func clipText(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,209 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterExportFormat("completion", newCompletionEncoder)
+	RegisterExportFormat("openai", newOpenAIEncoder)
+	RegisterExportFormat("sharegpt", newShareGPTEncoder)
+	RegisterExportFormat("alpaca", newAlpacaEncoder)
+	RegisterExportFormat("csv", newCSVEncoder)
+}
+
+// jsonlEncoder writes one JSON value per line
+type jsonlEncoder struct {
+	enc     *json.Encoder
+	convert func(conv *Conversation) interface{}
+}
+
+func newJSONLEncoder(w io.Writer, convert func(conv *Conversation) interface{}) *jsonlEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlEncoder{enc: enc, convert: convert}
+}
+
+func (e *jsonlEncoder) Encode(conv *Conversation) error {
+	return e.enc.Encode(e.convert(conv))
+}
+
+func (e *jsonlEncoder) Close() error {
+	return nil
+}
+
+// newCompletionEncoder writes the legacy prompt/completion pairs. A
+// multi-turn conversation is flattened into a transcript prompt.
+func newCompletionEncoder(w io.Writer, opts ExportOptions) ExportEncoder {
+	return newJSONLEncoder(w, func(conv *Conversation) interface{} {
+		last := conv.Interactions[len(conv.Interactions)-1]
+		prompt := last.Prompt
+		if len(conv.Interactions) > 1 {
+			prompt = transcript(conv.Turns()[:2*len(conv.Interactions)-2]) + "\n\nuser: " + last.Prompt
+		}
+		return Interaction{Prompt: prompt, Completion: last.Response}
+	})
+}
+
+// openAIMessage is a message of the OpenAI chat fine-tuning format
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// newOpenAIEncoder writes the OpenAI chat fine-tuning format
+func newOpenAIEncoder(w io.Writer, opts ExportOptions) ExportEncoder {
+	return newJSONLEncoder(w, func(conv *Conversation) interface{} {
+		messages := make([]openAIMessage, 0, 2*len(conv.Interactions)+1)
+		if conv.SystemPrompt != "" && !opts.OmitSystemPrompt {
+			messages = append(messages, openAIMessage{Role: RoleSystem, Content: conv.SystemPrompt})
+		}
+		for _, t := range conv.Turns() {
+			messages = append(messages, openAIMessage{Role: t.Role, Content: t.Content})
+		}
+		return struct {
+			Messages []openAIMessage `json:"messages"`
+		}{messages}
+	})
+}
+
+// shareGPTMessage is a message of the ShareGPT format
+type shareGPTMessage struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+// shareGPTRoles maps message roles to ShareGPT speakers
+var shareGPTRoles = map[string]string{
+	RoleSystem:    "system",
+	RoleUser:      "human",
+	RoleAssistant: "gpt",
+}
+
+// newShareGPTEncoder writes the ShareGPT conversations format
+func newShareGPTEncoder(w io.Writer, opts ExportOptions) ExportEncoder {
+	return newJSONLEncoder(w, func(conv *Conversation) interface{} {
+		messages := make([]shareGPTMessage, 0, 2*len(conv.Interactions)+1)
+		if conv.SystemPrompt != "" && !opts.OmitSystemPrompt {
+			messages = append(messages, shareGPTMessage{From: shareGPTRoles[RoleSystem], Value: conv.SystemPrompt})
+		}
+		for _, t := range conv.Turns() {
+			messages = append(messages, shareGPTMessage{From: shareGPTRoles[t.Role], Value: t.Content})
+		}
+		return struct {
+			Conversations []shareGPTMessage `json:"conversations"`
+		}{messages}
+	})
+}
+
+// alpacaRecord is an example of the Alpaca instruction format
+type alpacaRecord struct {
+	Instruction string `json:"instruction"`
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+	System      string `json:"system,omitempty"`
+}
+
+// newAlpacaEncoder writes the Alpaca instruction format. The last prompt is
+// the instruction and earlier turns of a multi-turn conversation become the
+// input.
+func newAlpacaEncoder(w io.Writer, opts ExportOptions) ExportEncoder {
+	return newJSONLEncoder(w, func(conv *Conversation) interface{} {
+		last := conv.Interactions[len(conv.Interactions)-1]
+		rec := alpacaRecord{
+			Instruction: last.Prompt,
+			Input:       transcript(conv.Turns()[:2*len(conv.Interactions)-2]),
+			Output:      last.Response,
+		}
+		if !opts.OmitSystemPrompt {
+			rec.System = conv.SystemPrompt
+		}
+		return rec
+	})
+}
+
+// transcript renders turns as "role: content" paragraphs
+func transcript(turns []Turn) string {
+	lines := make([]string, len(turns))
+	for i, t := range turns {
+		lines[i] = t.Role + ": " + t.Content
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// csvHeader lists the columns written by the CSV format
+var csvHeader = []string{
+	"conversation", "id", "created_at", "channel", "chat_id", "sender_id", "model", "provider",
+	"prompt_version", "experiment", "variant", "prompt_tokens", "completion_tokens", "latency_ms",
+	"rating", "system_prompt", "prompt", "response",
+}
+
+// csvEncoder writes one row per interaction, numbering the conversations
+type csvEncoder struct {
+	w       *csv.Writer
+	opts    ExportOptions
+	header  bool
+	counter int
+}
+
+// newCSVEncoder writes interactions with their metadata as CSV
+func newCSVEncoder(w io.Writer, opts ExportOptions) ExportEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), opts: opts}
+}
+
+func (e *csvEncoder) Encode(conv *Conversation) error {
+	if !e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	e.counter++
+	for _, rec := range conv.Interactions {
+		system := rec.SystemPrompt
+		if e.opts.OmitSystemPrompt {
+			system = ""
+		}
+		err := e.w.Write([]string{
+			strconv.Itoa(e.counter),
+			strconv.FormatInt(rec.ID, 10),
+			rec.CreatedAt.UTC().Format(time.RFC3339),
+			rec.Channel,
+			rec.ChatID,
+			rec.SenderID,
+			rec.Model,
+			rec.Provider,
+			rec.PromptVersion,
+			rec.Experiment,
+			rec.Variant,
+			strconv.Itoa(rec.PromptTokens),
+			strconv.Itoa(rec.CompletionTokens),
+			strconv.FormatInt(rec.LatencyMS, 10),
+			strconv.Itoa(rec.Rating),
+			system,
+			rec.Prompt,
+			rec.Response,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *csvEncoder) Close() error {
+	if !e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,297 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sources of preference pairs
+const (
+	PairFromRatings      = "ratings"      // a liked and a disliked response to the same prompt
+	PairFromRegeneration = "regeneration" // the user asked the same question again right away
+	PairFromCorrection   = "correction"   // an operator rewrote a disliked response
+)
+
+// PreferencePair is a chosen and a rejected response to the same prompt
+type PreferencePair struct {
+	SystemPrompt string
+	Prompt       string
+	Chosen       string
+	Rejected     string
+	Source       string
+	// Confidence is how sure we are that Chosen is preferred, from 0 to 1
+	Confidence float64
+	ChosenID   int64 // interaction of the chosen response, 0 for a correction
+	RejectedID int64
+}
+
+// PairOptions controls how preference pairs are built and written
+type PairOptions struct {
+	Filter InteractionFilter
+
+	// MinConfidence drops pairs with a lower confidence
+	MinConfidence float64
+
+	// RegenerationWindow is how soon the same prompt must be repeated in a
+	// chat to count as a regeneration of the previous answer
+	RegenerationWindow time.Duration
+
+	// Conversational writes prompt, chosen and rejected as message lists
+	// instead of plain strings
+	Conversational bool
+
+	OmitSystemPrompt bool
+}
+
+// Confidence of the pair sources. Ratings and regenerations gain confidence
+// with every vote agreeing with the pair: n net votes give 1 - 0.5^n.
+const (
+	correctionConfidence   = 1.0
+	regenerationConfidence = 0.5
+
+	defaultRegenerationWindow = 10 * time.Minute
+)
+
+// ratedInteraction is an interaction with the net rating of its feedback
+type ratedInteraction struct {
+	InteractionRecord
+	net int
+}
+
+// pairBuilder collects preference pairs while the interactions are read
+type pairBuilder struct {
+	opts PairOptions
+
+	// Rated interactions by prompt, for ratings pairs
+	rated map[string][]ratedInteraction
+	// Last interaction of every chat, for regeneration pairs
+	last  map[string]ratedInteraction
+	pairs map[[sha256.Size]byte]PreferencePair
+}
+
+// ExportPreferencePairs writes chosen/rejected pairs built from feedback,
+// regenerations and operator corrections as DPO JSONL and returns the
+// number of pairs written. Identical pairs are written once, with the
+// highest confidence found.
+func ExportPreferencePairs(ctx context.Context, store Store, w io.Writer, opts PairOptions) (int, error) {
+	if opts.RegenerationWindow <= 0 {
+		opts.RegenerationWindow = defaultRegenerationWindow
+	}
+
+	b := &pairBuilder{
+		opts:  opts,
+		rated: make(map[string][]ratedInteraction),
+		last:  make(map[string]ratedInteraction),
+		pairs: make(map[[sha256.Size]byte]PreferencePair),
+	}
+	err := eachInteractionPage(ctx, store, opts.Filter, func(items []InteractionRecord) error {
+		ids := make([]int64, len(items))
+		for i, rec := range items {
+			ids[i] = rec.ID
+		}
+		feedback, err := store.ListFeedback(ctx, ids)
+		if err != nil {
+			return err
+		}
+		b.addPage(items, feedback)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	b.pairRatings()
+
+	pairs := b.sorted()
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for i := range pairs {
+		if err := enc.Encode(b.encodable(&pairs[i])); err != nil {
+			return i, fmt.Errorf("writing pair: %w", err)
+		}
+	}
+	return len(pairs), nil
+}
+
+// addPage records the pairs found in one page of interactions
+func (b *pairBuilder) addPage(items []InteractionRecord, feedback []FeedbackRecord) {
+	net := make(map[int64]int)
+	for _, fb := range feedback {
+		net[fb.InteractionID] += fb.Rating
+	}
+
+	byID := make(map[int64]InteractionRecord, len(items))
+	for _, rec := range items {
+		byID[rec.ID] = rec
+		ri := ratedInteraction{InteractionRecord: rec, net: net[rec.ID]}
+		if ri.net != 0 {
+			key := promptKey(rec.SystemPrompt, rec.Prompt)
+			b.rated[key] = append(b.rated[key], ri)
+		}
+		if rec.ChatID != "" {
+			if prev, ok := b.last[rec.ChatID]; ok {
+				b.pairRegeneration(prev, ri)
+			}
+			b.last[rec.ChatID] = ri
+		}
+	}
+
+	for _, fb := range feedback {
+		if fb.Correction == "" {
+			continue
+		}
+		rec := byID[fb.InteractionID]
+		b.add(PreferencePair{
+			SystemPrompt: rec.SystemPrompt,
+			Prompt:       rec.Prompt,
+			Chosen:       fb.Correction,
+			Rejected:     rec.Response,
+			Source:       PairFromCorrection,
+			Confidence:   correctionConfidence,
+			RejectedID:   rec.ID,
+		})
+	}
+}
+
+// pairRegeneration pairs two consecutive interactions of a chat when the
+// prompt was repeated: the user was not happy with the first answer. Ratings
+// that prefer the first answer cancel the pair.
+func (b *pairBuilder) pairRegeneration(prev, next ratedInteraction) {
+	if next.CreatedAt.Sub(prev.CreatedAt) > b.opts.RegenerationWindow ||
+		promptKey(prev.SystemPrompt, prev.Prompt) != promptKey(next.SystemPrompt, next.Prompt) {
+		return
+	}
+
+	votes := next.net - prev.net
+	if votes < 0 {
+		return
+	}
+	confidence := regenerationConfidence
+	if votes > 0 {
+		confidence = agreement(votes + 1)
+	}
+	b.add(PreferencePair{
+		SystemPrompt: next.SystemPrompt,
+		Prompt:       next.Prompt,
+		Chosen:       next.Response,
+		Rejected:     prev.Response,
+		Source:       PairFromRegeneration,
+		Confidence:   confidence,
+		ChosenID:     next.ID,
+		RejectedID:   prev.ID,
+	})
+}
+
+// pairRatings pairs every liked response with every disliked response to
+// the same prompt
+func (b *pairBuilder) pairRatings() {
+	for _, group := range b.rated {
+		for _, chosen := range group {
+			if chosen.net <= 0 {
+				continue
+			}
+			for _, rejected := range group {
+				if rejected.net >= 0 {
+					continue
+				}
+				b.add(PreferencePair{
+					SystemPrompt: chosen.SystemPrompt,
+					Prompt:       chosen.Prompt,
+					Chosen:       chosen.Response,
+					Rejected:     rejected.Response,
+					Source:       PairFromRatings,
+					Confidence:   agreement(chosen.net - rejected.net),
+					ChosenID:     chosen.ID,
+					RejectedID:   rejected.ID,
+				})
+			}
+		}
+	}
+}
+
+// add keeps a pair unless it is below the confidence threshold, does not
+// prefer anything or duplicates a pair with a higher confidence
+func (b *pairBuilder) add(p PreferencePair) {
+	if p.Confidence < b.opts.MinConfidence || normalize(p.Chosen) == normalize(p.Rejected) {
+		return
+	}
+	key := sha256.Sum256([]byte(promptKey(p.SystemPrompt, p.Prompt) + "\x00" + normalize(p.Chosen) + "\x00" + normalize(p.Rejected)))
+	if existing, ok := b.pairs[key]; ok && existing.Confidence >= p.Confidence {
+		return
+	}
+	b.pairs[key] = p
+}
+
+// sorted returns the pairs ordered by the interactions they came from
+func (b *pairBuilder) sorted() []PreferencePair {
+	pairs := make([]PreferencePair, 0, len(b.pairs))
+	for _, p := range b.pairs {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].RejectedID != pairs[j].RejectedID {
+			return pairs[i].RejectedID < pairs[j].RejectedID
+		}
+		if pairs[i].ChosenID != pairs[j].ChosenID {
+			return pairs[i].ChosenID < pairs[j].ChosenID
+		}
+		return pairs[i].Chosen < pairs[j].Chosen
+	})
+	return pairs
+}
+
+// dpoRecord is a pair in the DPO JSONL format. Prompt, Chosen and Rejected
+// are strings, or message lists in the conversational format.
+type dpoRecord struct {
+	System     string      `json:"system,omitempty"`
+	Prompt     interface{} `json:"prompt"`
+	Chosen     interface{} `json:"chosen"`
+	Rejected   interface{} `json:"rejected"`
+	Source     string      `json:"source"`
+	Confidence float64     `json:"confidence"`
+}
+
+// encodable converts a pair to its DPO record
+func (b *pairBuilder) encodable(p *PreferencePair) dpoRecord {
+	rec := dpoRecord{Source: p.Source, Confidence: math.Round(p.Confidence*1000) / 1000}
+	system := p.SystemPrompt
+	if b.opts.OmitSystemPrompt {
+		system = ""
+	}
+
+	if !b.opts.Conversational {
+		rec.System, rec.Prompt, rec.Chosen, rec.Rejected = system, p.Prompt, p.Chosen, p.Rejected
+		return rec
+	}
+
+	var prompt []openAIMessage
+	if system != "" {
+		prompt = append(prompt, openAIMessage{Role: RoleSystem, Content: system})
+	}
+	rec.Prompt = append(prompt, openAIMessage{Role: RoleUser, Content: p.Prompt})
+	rec.Chosen = []openAIMessage{{Role: RoleAssistant, Content: p.Chosen}}
+	rec.Rejected = []openAIMessage{{Role: RoleAssistant, Content: p.Rejected}}
+	return rec
+}
+
+// agreement is the confidence given by n agreeing votes
+func agreement(n int) float64 {
+	return 1 - math.Pow(0.5, float64(n))
+}
+
+// promptKey identifies a prompt regardless of case and spacing
+func promptKey(systemPrompt, prompt string) string {
+	return normalize(systemPrompt) + "\x00" + normalize(prompt)
+}
+
+// normalize lowercases text and collapses whitespace
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
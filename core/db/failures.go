@@ -0,0 +1,66 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Failure stages
+const (
+	FailureLLM      = "llm"      // the LLM returned no response
+	FailureDelivery = "delivery" // the response could not be sent to the chat
+)
+
+// maxFailureError is the longest error message stored with a failure
+const maxFailureError = 500
+
+// FailureRecord is a request that did not end with a delivered response. It
+// holds no message content or sender IDs.
+type FailureRecord struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"` // defaults to the insert time when zero
+	Channel       string    `json:"channel,omitempty"`
+	Stage         string    `json:"stage"`
+	Model         string    `json:"model,omitempty"`
+	Error         string    `json:"error"`
+	InteractionID int64     `json:"interaction_id,omitempty"` // set for delivery failures
+}
+
+// RecordFailure stores a failed request and sets f.ID
+func (s *SQLStore) RecordFailure(ctx context.Context, f *FailureRecord) error {
+	return insertFailure(ctx, s.conn(), f)
+}
+
+func insertFailure(ctx context.Context, q queryer, f *FailureRecord) error {
+	if f.Stage == "" || f.Error == "" {
+		return errors.New("a failure needs a stage and an error")
+	}
+
+	message := f.Error
+	if len(message) > maxFailureError {
+		message = message[:maxFailureError]
+		for !utf8.ValidString(message) {
+			message = message[:len(message)-1]
+		}
+	}
+	var interactionID sql.NullInt64
+	if f.InteractionID != 0 {
+		interactionID = sql.NullInt64{Int64: f.InteractionID, Valid: true}
+	}
+
+	const query = `
+	INSERT INTO failures (created_at, channel, stage, model, error, interaction_id)
+	VALUES (COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?)
+	RETURNING id`
+	err := q.QueryRowContext(ctx, query, nullTime(f.CreatedAt), nullString(f.Channel), f.Stage,
+		nullString(f.Model), message, interactionID).Scan(&f.ID)
+	if err != nil {
+		return fmt.Errorf("inserting failure: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// Feedback ratings
+const (
+	RatingNegative = -1
+	RatingPositive = 1
+)
+
+// Feedback sources
+const (
+	FeedbackReaction = "reaction" // emoji reaction on the bot's reply
+	FeedbackCommand  = "command"  // /good or /bad in the chat
+	FeedbackOperator = "operator" // set by an operator reviewing interactions
+)
+
+// RatingFilter selects interactions by the net rating of their feedback
+type RatingFilter string
+
+// Rating filters
+const (
+	RatingAny      RatingFilter = ""
+	RatingLiked    RatingFilter = "positive" // net rating above zero
+	RatingDisliked RatingFilter = "negative" // net rating below zero
+	RatingUnrated  RatingFilter = "unrated"  // no feedback at all
+)
+
+// ParseRatingFilter validates a rating filter given on the command line
+func ParseRatingFilter(s string) (RatingFilter, error) {
+	switch r := RatingFilter(s); r {
+	case RatingAny, RatingLiked, RatingDisliked, RatingUnrated:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown rating filter %q, expected positive, negative or unrated", s)
+	}
+}
+
+// condition returns the SQL condition on interactions i for the filter. An
+// unknown filter matches nothing.
+func (r RatingFilter) condition() string {
+	const net = "(SELECT COALESCE(SUM(f.rating), 0) FROM feedback f WHERE f.interaction_id = i.id)"
+	switch r {
+	case RatingAny:
+		return ""
+	case RatingLiked:
+		return net + " > 0"
+	case RatingDisliked:
+		return net + " < 0"
+	case RatingUnrated:
+		return "NOT EXISTS (SELECT 1 FROM feedback f WHERE f.interaction_id = i.id)"
+	default:
+		return "1 = 0"
+	}
+}
+
+// FeedbackRecord is a rating given to an interaction
+type FeedbackRecord struct {
+	ID            int64     `json:"id"`
+	InteractionID int64     `json:"interaction_id"`
+	Rating        int       `json:"rating"` // RatingPositive or RatingNegative
+	Source        string    `json:"source"`
+	SenderID      string    `json:"sender_id,omitempty"` // who gave the feedback
+	Comment       string    `json:"comment,omitempty"`
+	Correction    string    `json:"correction,omitempty"` // better response written by an operator
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AddFeedback stores a rating for an interaction and sets fb.ID
+func (s *SQLStore) AddFeedback(ctx context.Context, fb *FeedbackRecord) error {
+	if err := fb.validate(); err != nil {
+		return err
+	}
+	return insertFeedback(ctx, s.conn(), s.keys, fb)
+}
+
+// ReplaceFeedback stores a sender's rating for an interaction in place of
+// the vote they gave it earlier. Reactions and commands are both the
+// sender's own vote, so each replaces the other and every sender has at most
+// one; other sources only replace their own feedback. A zero rating only
+// removes the earlier vote from the same source, e.g. when a reaction is
+// taken back.
+func (s *SQLStore) ReplaceFeedback(ctx context.Context, fb *FeedbackRecord) error {
+	if fb.SenderID == "" {
+		return errors.New("replacing feedback requires the sender")
+	}
+	if fb.Rating != 0 {
+		if err := fb.validate(); err != nil {
+			return err
+		}
+	}
+
+	sources := []interface{}{fb.Source}
+	if fb.Rating != 0 && isUserFeedback(fb.Source) {
+		sources = []interface{}{FeedbackReaction, FeedbackCommand}
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(sources)), ", ")
+	remove := "DELETE FROM feedback WHERE interaction_id = ? AND sender_id = ? AND source IN (" + in + ")"
+
+	return s.inTx(ctx, func(q queryer) error {
+		args := append([]interface{}{fb.InteractionID, fb.SenderID}, sources...)
+		if _, err := q.ExecContext(ctx, remove, args...); err != nil {
+			return fmt.Errorf("deleting earlier feedback: %w", err)
+		}
+		if fb.Rating == 0 {
+			return nil
+		}
+		return insertFeedback(ctx, q, s.keys, fb)
+	})
+}
+
+// isUserFeedback reports whether feedback from the source is a chat user's vote
+func isUserFeedback(source string) bool {
+	return source == FeedbackReaction || source == FeedbackCommand
+}
+
+// validate checks the rating, source and correction of new feedback
+func (fb *FeedbackRecord) validate() error {
+	if fb.Rating != RatingPositive && fb.Rating != RatingNegative {
+		return fmt.Errorf("invalid rating %d", fb.Rating)
+	}
+	if fb.Source == "" {
+		return errors.New("feedback source is required")
+	}
+	if fb.Correction != "" && fb.Rating != RatingNegative {
+		return errors.New("a correction requires a negative rating")
+	}
+	return nil
+}
+
+// insertFeedback stores feedback with its comment and correction sealed
+// when keys are set
+func insertFeedback(ctx context.Context, q queryer, keys *encryption.Keyring, fb *FeedbackRecord) error {
+	const query = `
+	INSERT INTO feedback (interaction_id, rating, source, sender_id, comment, correction, key_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id, created_at`
+
+	var comment, correction string
+	var err error
+	if fb.Comment != "" {
+		if comment, err = sealValue(keys, labelComment, fb.Comment); err != nil {
+			return err
+		}
+	}
+	if fb.Correction != "" {
+		if correction, err = sealValue(keys, labelCorrection, fb.Correction); err != nil {
+			return err
+		}
+	}
+
+	err = q.QueryRowContext(ctx, query, fb.InteractionID, fb.Rating, fb.Source,
+		nullString(fb.SenderID), nullString(comment), nullString(correction), activeKeyID(keys)).Scan(&fb.ID, &fb.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("inserting feedback: %w", err)
+	}
+	return nil
+}
+
+// ListFeedback returns the feedback given to the interactions in ID order
+func (s *SQLStore) ListFeedback(ctx context.Context, interactionIDs []int64) ([]FeedbackRecord, error) {
+	if len(interactionIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := inList(interactionIDs)
+	rows, err := s.conn().QueryContext(ctx,
+		"SELECT "+feedbackColumns+" FROM feedback f WHERE f.interaction_id IN ("+placeholders+") ORDER BY f.id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying feedback: %w", err)
+	}
+	defer rows.Close()
+	return scanFeedback(rows, s.keys)
+}
+
+// feedbackColumns is the column list read by scanFeedback
+const feedbackColumns = "f.id, f.interaction_id, f.rating, f.source, f.sender_id, f.comment, f.correction, f.key_id, f.created_at"
+
+// scanFeedback reads feedbackColumns rows and decrypts comments and corrections
+func scanFeedback(rows *sql.Rows, keys *encryption.Keyring) ([]FeedbackRecord, error) {
+	var records []FeedbackRecord
+	for rows.Next() {
+		var fb FeedbackRecord
+		var sender, comment, correction, keyID sql.NullString
+		if err := rows.Scan(&fb.ID, &fb.InteractionID, &fb.Rating, &fb.Source, &sender, &comment, &correction, &keyID, &fb.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning feedback: %w", err)
+		}
+		fb.SenderID = sender.String
+		fb.Comment = comment.String
+		fb.Correction = correction.String
+		if err := openValue(keys, keyID, labelComment, &fb.Comment); err != nil {
+			return nil, err
+		}
+		if err := openValue(keys, keyID, labelCorrection, &fb.Correction); err != nil {
+			return nil, err
+		}
+		records = append(records, fb)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,109 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// ImportResult counts what ImportExchanges stored
+type ImportResult struct {
+	Interactions int // exchanges stored with their interaction
+	Messages     int // messages stored, including those of the interactions
+	Duplicates   int // exchanges skipped because they were imported before
+}
+
+// ImportExchanges stores exchanges read from an external source in one
+// transaction. An exchange with only an inbound or only an outbound message
+// is stored as a single message without an interaction. Every message must
+// carry an external ID; exchanges whose messages already exist in the chat
+// are skipped, so importing the same data twice is harmless.
+func (s *SQLStore) ImportExchanges(ctx context.Context, exchanges []*Exchange) (*ImportResult, error) {
+	result := &ImportResult{}
+	err := s.inTx(ctx, func(q queryer) error {
+		*result = ImportResult{}
+		for _, ex := range exchanges {
+			imported, err := importExchange(ctx, q, s.keys, ex)
+			if err != nil {
+				return err
+			}
+			if !imported {
+				result.Duplicates++
+				continue
+			}
+			if ex.Interaction.ID != 0 {
+				result.Interactions++
+				result.Messages += 2
+			} else {
+				result.Messages++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importExchange stores one exchange unless it exists and reports whether it was stored
+func importExchange(ctx context.Context, q queryer, keys *encryption.Keyring, ex *Exchange) (bool, error) {
+	hasInbound, hasOutbound := ex.Inbound.Content != "", ex.Outbound.Content != ""
+	switch {
+	case !hasInbound && !hasOutbound:
+		return false, errors.New("imported exchange has no message")
+	case hasInbound && ex.Inbound.ExternalID == "", hasOutbound && ex.Outbound.ExternalID == "":
+		return false, errors.New("imported messages need an external ID")
+	}
+
+	channelID, err := ensureChannel(ctx, q, ex.Chat.Channel)
+	if err != nil {
+		return false, err
+	}
+	chatID, err := ensureChat(ctx, q, channelID, ex.Chat)
+	if err != nil {
+		return false, err
+	}
+
+	first := &ex.Inbound
+	if !hasInbound {
+		first = &ex.Outbound
+	}
+	var exists int
+	err = q.QueryRowContext(ctx, "SELECT 1 FROM messages WHERE chat_id = ? AND external_id = ?", chatID, first.ExternalID).Scan(&exists)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("checking for imported message: %w", err)
+	}
+
+	if hasInbound && hasOutbound {
+		return true, recordExchange(ctx, q, keys, ex)
+	}
+
+	if hasInbound {
+		var senderID sql.NullInt64
+		if ex.Sender.ExternalID != "" {
+			id, err := ensureParticipant(ctx, q, channelID, ex.Sender)
+			if err != nil {
+				return false, err
+			}
+			senderID = sql.NullInt64{Int64: id, Valid: true}
+		}
+		ex.Inbound.Role = RoleUser
+		err = insertMessage(ctx, q, keys, chatID, senderID, 0, &ex.Inbound)
+	} else {
+		ex.Outbound.Role = RoleAssistant
+		err = insertMessage(ctx, q, keys, chatID, sql.NullInt64{}, 0, &ex.Outbound)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, touchChat(ctx, q, chatID, first.SentAt)
+}
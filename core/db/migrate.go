@@ -0,0 +1,343 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the embedded migrations of a driver to a database
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the given driver's embedded migrations
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+// loadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql pairs in version order
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s migrations: %w", driver, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no name", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s has an invalid version", name)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous, missing version %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest returns the newest schema version known to this binary
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// ensureTable creates the schema_migrations bookkeeping table
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	const query = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := m.conn().ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// applied returns the applied migration versions and when they were applied
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.conn().QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("scanning schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Current returns the schema version of the database
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := m.conn().QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Check fails with ErrSchemaTooNew when the database is ahead of this binary
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, current, m.Latest())
+	}
+	return nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		statuses = append(statuses, MigrationStatus{Migration: mig, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+// migrationLockID is the Postgres advisory lock held while migrating
+const migrationLockID = 7_365_208_214
+
+// lock keeps other processes sharing a Postgres database from migrating at
+// the same time and returns a function releasing the lock. Instances started
+// together would otherwise all apply the same pending migrations. SQLite
+// databases are not shared between hosts, so they are not locked.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if m.driver != DriverPostgres {
+		return func() {}, nil
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("waiting for the migration lock: %w", err)
+	}
+	return func() {
+		// The lock belongs to the session, so a connection that could not
+		// release it is discarded rather than returned to the pool
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// Up applies all pending migrations, each in its own transaction, and
+// returns the migrations that were applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Read after taking the lock, another process may just have migrated
+	if err := m.Check(ctx); err != nil {
+		return nil, err
+	}
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations[current:] {
+		err := m.inTx(ctx, func(tx queryer) error {
+			if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.Version, mig.Name)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("applying migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of most recent migrations
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := m.Check(ctx); err != nil {
+		return nil, err
+	}
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := 0; i < steps && current > 0; i++ {
+		mig := m.migrations[current-1]
+		if mig.Down == "" {
+			return done, fmt.Errorf("migration %d_%s cannot be rolled back", mig.Version, mig.Name)
+		}
+
+		err := m.inTx(ctx, func(tx queryer) error {
+			if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("rolling back migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+		current--
+	}
+	return done, nil
+}
+
+// Baseline marks the first version migrations as applied without running
+// them. It is used for databases created before migrations existed.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	return m.inTx(ctx, func(tx queryer) error {
+		for _, mig := range m.migrations[:version] {
+			const query = "INSERT INTO schema_migrations (version, name) VALUES (?, ?) ON CONFLICT(version) DO NOTHING"
+			if _, err := tx.ExecContext(ctx, query, mig.Version, mig.Name); err != nil {
+				return fmt.Errorf("baselining migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// conn returns the database with placeholders adapted to the driver
+func (m *Migrator) conn() queryer {
+	return bind(m.driver, m.db)
+}
+
+func (m *Migrator) inTx(ctx context.Context, fn func(tx queryer) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	if err := fn(bind(m.driver, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS interactions (
+	id BIGSERIAL PRIMARY KEY,
+	user_input TEXT NOT NULL,
+	llm_response TEXT NOT NULL,
+	timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+ALTER TABLE interactions DROP COLUMN max_tokens;
+ALTER TABLE interactions DROP COLUMN top_p;
+ALTER TABLE interactions DROP COLUMN temperature;
+ALTER TABLE interactions DROP COLUMN provider;
+ALTER TABLE interactions DROP COLUMN model;
+ALTER TABLE interactions DROP COLUMN prompt_version;
+
+DROP TABLE prompt_versions;
@@ -0,0 +1,12 @@
+CREATE TABLE prompt_versions (
+	hash TEXT PRIMARY KEY,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE interactions ADD COLUMN prompt_version TEXT REFERENCES prompt_versions(hash);
+ALTER TABLE interactions ADD COLUMN model TEXT;
+ALTER TABLE interactions ADD COLUMN provider TEXT;
+ALTER TABLE interactions ADD COLUMN temperature DOUBLE PRECISION;
+ALTER TABLE interactions ADD COLUMN top_p DOUBLE PRECISION;
+ALTER TABLE interactions ADD COLUMN max_tokens INTEGER;
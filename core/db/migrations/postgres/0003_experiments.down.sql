@@ -0,0 +1,8 @@
+DROP INDEX idx_interactions_experiment;
+
+ALTER TABLE interactions DROP COLUMN completion_tokens;
+ALTER TABLE interactions DROP COLUMN prompt_tokens;
+ALTER TABLE interactions DROP COLUMN latency_ms;
+ALTER TABLE interactions DROP COLUMN variant;
+ALTER TABLE interactions DROP COLUMN experiment;
+ALTER TABLE interactions DROP COLUMN chat_id;
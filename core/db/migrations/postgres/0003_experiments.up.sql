@@ -0,0 +1,8 @@
+ALTER TABLE interactions ADD COLUMN chat_id TEXT;
+ALTER TABLE interactions ADD COLUMN experiment TEXT;
+ALTER TABLE interactions ADD COLUMN variant TEXT;
+ALTER TABLE interactions ADD COLUMN latency_ms BIGINT;
+ALTER TABLE interactions ADD COLUMN prompt_tokens INTEGER;
+ALTER TABLE interactions ADD COLUMN completion_tokens INTEGER;
+
+CREATE INDEX idx_interactions_experiment ON interactions (experiment, variant);
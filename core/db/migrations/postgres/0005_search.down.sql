@@ -0,0 +1,3 @@
+DROP INDEX idx_interactions_search;
+
+ALTER TABLE interactions DROP COLUMN search_vector;
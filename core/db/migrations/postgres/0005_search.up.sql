@@ -0,0 +1,6 @@
+ALTER TABLE interactions ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', user_input), 'A') ||
+	setweight(to_tsvector('english', llm_response), 'B')
+) STORED;
+
+CREATE INDEX idx_interactions_search ON interactions USING GIN (search_vector);
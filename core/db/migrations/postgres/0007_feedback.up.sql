@@ -0,0 +1,13 @@
+CREATE TABLE feedback (
+	id BIGSERIAL PRIMARY KEY,
+	interaction_id BIGINT NOT NULL REFERENCES interactions(id),
+	rating INTEGER NOT NULL,
+	source TEXT NOT NULL,
+	sender_id TEXT,
+	comment TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_feedback_interaction ON feedback (interaction_id);
+
+ALTER TABLE erasures ADD COLUMN feedback INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+ALTER TABLE interactions DROP COLUMN search_vector;
+
+ALTER TABLE interactions ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', user_input), 'A') ||
+	setweight(to_tsvector('english', llm_response), 'B')
+) STORED;
+
+CREATE INDEX idx_interactions_search ON interactions USING GIN (search_vector);
+
+ALTER TABLE messages DROP COLUMN key_id;
+ALTER TABLE interactions DROP COLUMN key_id;
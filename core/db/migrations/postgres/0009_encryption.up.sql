@@ -0,0 +1,15 @@
+-- ID of the key the content columns are encrypted with, NULL for plaintext
+ALTER TABLE interactions ADD COLUMN key_id TEXT;
+ALTER TABLE messages ADD COLUMN key_id TEXT;
+
+-- Only plaintext interactions are indexed for full-text search
+ALTER TABLE interactions DROP COLUMN search_vector;
+
+ALTER TABLE interactions ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	CASE WHEN key_id IS NULL THEN
+		setweight(to_tsvector('english', user_input), 'A') ||
+		setweight(to_tsvector('english', llm_response), 'B')
+	END
+) STORED;
+
+CREATE INDEX idx_interactions_search ON interactions USING GIN (search_vector);
@@ -0,0 +1,16 @@
+-- Failed requests: LLM calls without a response and responses that could not
+-- be delivered. Rows hold no message content or sender IDs.
+CREATE TABLE failures (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	channel TEXT,
+	stage TEXT NOT NULL,
+	model TEXT,
+	error TEXT NOT NULL,
+	interaction_id BIGINT REFERENCES interactions(id)
+);
+
+CREATE INDEX idx_failures_created_at ON failures (created_at);
+CREATE INDEX idx_failures_interaction ON failures (interaction_id);
+
+CREATE INDEX idx_messages_sent_at ON messages (sent_at);
@@ -0,0 +1,2 @@
+DROP INDEX idx_reviews_status;
+DROP TABLE reviews;
@@ -0,0 +1,13 @@
+-- Operator review of interactions for dataset curation. An interaction
+-- without a row is pending; the verdict is mirrored as operator feedback,
+-- which also holds the corrected response of edited interactions.
+CREATE TABLE reviews (
+	interaction_id BIGINT PRIMARY KEY REFERENCES interactions(id),
+	status TEXT NOT NULL,
+	reviewer TEXT NOT NULL,
+	note TEXT,
+	feedback_id BIGINT REFERENCES feedback(id),
+	reviewed_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_reviews_status ON reviews (status);
@@ -0,0 +1,10 @@
+-- Embedding vectors of interactions for similarity search, one per
+-- interaction and embedding model, stored as little-endian float32 arrays
+CREATE TABLE embeddings (
+	interaction_id BIGINT NOT NULL REFERENCES interactions(id),
+	model TEXT NOT NULL,
+	dims INTEGER NOT NULL,
+	vector BYTEA NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (interaction_id, model)
+);
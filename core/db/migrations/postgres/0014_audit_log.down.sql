@@ -0,0 +1,6 @@
+DROP TRIGGER audit_log_no_truncate ON audit_log;
+DROP TRIGGER audit_log_no_change ON audit_log;
+DROP FUNCTION audit_log_append_only();
+DROP INDEX idx_audit_log_action;
+DROP INDEX idx_audit_log_occurred_at;
+DROP TABLE audit_log;
@@ -0,0 +1,30 @@
+-- Append-only log of administrative and system events. Every entry holds
+-- the hash of the one before it and its own hash over both, so changed,
+-- removed or reordered entries break the chain. prev_hash is unique, which
+-- keeps concurrent writers from forking the chain.
+CREATE TABLE audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT,
+	before_json TEXT,
+	after_json TEXT,
+	prev_hash TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_log_occurred_at ON audit_log (occurred_at);
+CREATE INDEX idx_audit_log_action ON audit_log (action);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
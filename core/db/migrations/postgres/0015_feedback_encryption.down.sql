@@ -0,0 +1 @@
+ALTER TABLE feedback DROP COLUMN key_id;
@@ -0,0 +1,2 @@
+-- ID of the key comment and correction are encrypted with, NULL for plaintext
+ALTER TABLE feedback ADD COLUMN key_id TEXT;
@@ -0,0 +1,3 @@
+-- The note of a review is the comment of its feedback, which is encrypted
+-- with the other feedback columns; the plaintext copy is dropped
+ALTER TABLE reviews DROP COLUMN note;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS interactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_input TEXT NOT NULL,
+	llm_response TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,14 @@
+-- prompt_version is a foreign key column, which SQLite cannot drop in place
+CREATE TABLE interactions_previous (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_input TEXT NOT NULL,
+	llm_response TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO interactions_previous (id, user_input, llm_response, timestamp)
+SELECT id, user_input, llm_response, timestamp FROM interactions;
+
+DROP TABLE interactions;
+ALTER TABLE interactions_previous RENAME TO interactions;
+DROP TABLE prompt_versions;
@@ -0,0 +1,9 @@
+DROP INDEX idx_interactions_chat;
+
+ALTER TABLE interactions DROP COLUMN sender_id;
+ALTER TABLE interactions DROP COLUMN channel;
+
+DROP TABLE messages;
+DROP TABLE participants;
+DROP TABLE chats;
+DROP TABLE channels;
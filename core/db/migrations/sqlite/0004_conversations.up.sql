@@ -0,0 +1,49 @@
+CREATE TABLE channels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE chats (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel_id INTEGER NOT NULL REFERENCES channels(id),
+	external_id TEXT NOT NULL,
+	is_group INTEGER NOT NULL DEFAULT 0,
+	title TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_message_at DATETIME,
+	UNIQUE (channel_id, external_id)
+);
+
+CREATE TABLE participants (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel_id INTEGER NOT NULL REFERENCES channels(id),
+	external_id TEXT NOT NULL,
+	display_name TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (channel_id, external_id)
+);
+
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id INTEGER NOT NULL REFERENCES chats(id),
+	participant_id INTEGER REFERENCES participants(id),
+	interaction_id INTEGER REFERENCES interactions(id),
+	role TEXT NOT NULL,
+	content TEXT NOT NULL,
+	external_id TEXT,
+	reply_to_external_id TEXT,
+	quoted_content TEXT,
+	sent_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_messages_chat ON messages (chat_id, sent_at);
+CREATE INDEX idx_messages_interaction ON messages (interaction_id);
+CREATE UNIQUE INDEX idx_messages_external ON messages (chat_id, external_id) WHERE external_id IS NOT NULL;
+
+ALTER TABLE interactions ADD COLUMN channel TEXT;
+ALTER TABLE interactions ADD COLUMN sender_id TEXT;
+
+CREATE INDEX idx_interactions_chat ON interactions (chat_id, timestamp);
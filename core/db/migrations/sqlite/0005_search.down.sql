@@ -0,0 +1,4 @@
+DROP TRIGGER interactions_fts_update;
+DROP TRIGGER interactions_fts_delete;
+DROP TRIGGER interactions_fts_insert;
+DROP TABLE interactions_fts;
@@ -0,0 +1,27 @@
+CREATE VIRTUAL TABLE interactions_fts USING fts5(
+	user_input,
+	llm_response,
+	content = 'interactions',
+	content_rowid = 'id',
+	tokenize = 'porter unicode61'
+);
+
+CREATE TRIGGER interactions_fts_insert AFTER INSERT ON interactions BEGIN
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	VALUES (new.id, new.user_input, new.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_delete AFTER DELETE ON interactions BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	VALUES ('delete', old.id, old.user_input, old.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_update AFTER UPDATE OF user_input, llm_response ON interactions BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	VALUES ('delete', old.id, old.user_input, old.llm_response);
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	VALUES (new.id, new.user_input, new.llm_response);
+END;
+
+-- Index the interactions recorded before search existed
+INSERT INTO interactions_fts (interactions_fts) VALUES ('rebuild');
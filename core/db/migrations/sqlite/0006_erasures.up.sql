@@ -0,0 +1,13 @@
+CREATE TABLE erasures (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subject_hash TEXT NOT NULL,
+	requested_by TEXT NOT NULL,
+	reason TEXT,
+	interactions INTEGER NOT NULL,
+	messages INTEGER NOT NULL,
+	chats INTEGER NOT NULL,
+	participants INTEGER NOT NULL,
+	erased_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_erasures_subject ON erasures (subject_hash);
@@ -0,0 +1,3 @@
+ALTER TABLE erasures DROP COLUMN feedback;
+
+DROP TABLE feedback;
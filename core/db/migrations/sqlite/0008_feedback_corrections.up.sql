@@ -0,0 +1,2 @@
+-- A better response written by an operator for a badly rated interaction
+ALTER TABLE feedback ADD COLUMN correction TEXT;
@@ -0,0 +1,23 @@
+DROP TRIGGER interactions_fts_update;
+DROP TRIGGER interactions_fts_delete;
+DROP TRIGGER interactions_fts_insert;
+
+CREATE TRIGGER interactions_fts_insert AFTER INSERT ON interactions BEGIN
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	VALUES (new.id, new.user_input, new.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_delete AFTER DELETE ON interactions BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	VALUES ('delete', old.id, old.user_input, old.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_update AFTER UPDATE OF user_input, llm_response ON interactions BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	VALUES ('delete', old.id, old.user_input, old.llm_response);
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	VALUES (new.id, new.user_input, new.llm_response);
+END;
+
+ALTER TABLE messages DROP COLUMN key_id;
+ALTER TABLE interactions DROP COLUMN key_id;
@@ -0,0 +1,25 @@
+-- ID of the key the content columns are encrypted with, NULL for plaintext
+ALTER TABLE interactions ADD COLUMN key_id TEXT;
+ALTER TABLE messages ADD COLUMN key_id TEXT;
+
+-- Only plaintext interactions are indexed for full-text search
+DROP TRIGGER interactions_fts_update;
+DROP TRIGGER interactions_fts_delete;
+DROP TRIGGER interactions_fts_insert;
+
+CREATE TRIGGER interactions_fts_insert AFTER INSERT ON interactions WHEN new.key_id IS NULL BEGIN
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	VALUES (new.id, new.user_input, new.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_delete AFTER DELETE ON interactions WHEN old.key_id IS NULL BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	VALUES ('delete', old.id, old.user_input, old.llm_response);
+END;
+
+CREATE TRIGGER interactions_fts_update AFTER UPDATE OF user_input, llm_response, key_id ON interactions BEGIN
+	INSERT INTO interactions_fts (interactions_fts, rowid, user_input, llm_response)
+	SELECT 'delete', old.id, old.user_input, old.llm_response WHERE old.key_id IS NULL;
+	INSERT INTO interactions_fts (rowid, user_input, llm_response)
+	SELECT new.id, new.user_input, new.llm_response WHERE new.key_id IS NULL;
+END;
@@ -0,0 +1,2 @@
+DROP INDEX idx_messages_sent_at;
+DROP TABLE failures;
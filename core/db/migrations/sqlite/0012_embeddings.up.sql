@@ -0,0 +1,10 @@
+-- Embedding vectors of interactions for similarity search, one per
+-- interaction and embedding model, stored as little-endian float32 arrays
+CREATE TABLE embeddings (
+	interaction_id INTEGER NOT NULL REFERENCES interactions(id),
+	model TEXT NOT NULL,
+	dims INTEGER NOT NULL,
+	vector BLOB NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (interaction_id, model)
+);
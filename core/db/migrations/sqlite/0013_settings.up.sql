@@ -0,0 +1,13 @@
+-- Per-chat and per-user settings such as the reply language. A subject is
+-- the external ID of a chat or sender on a channel; settings without a row
+-- take their default from the configuration.
+CREATE TABLE settings (
+	scope TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	updated_by TEXT,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (scope, channel, subject, key)
+);
@@ -0,0 +1,5 @@
+DROP TRIGGER audit_log_no_delete;
+DROP TRIGGER audit_log_no_update;
+DROP INDEX idx_audit_log_action;
+DROP INDEX idx_audit_log_occurred_at;
+DROP TABLE audit_log;
@@ -0,0 +1,26 @@
+-- Append-only log of administrative and system events. Every entry holds
+-- the hash of the one before it and its own hash over both, so changed,
+-- removed or reordered entries break the chain. prev_hash is unique, which
+-- keeps concurrent writers from forking the chain.
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	occurred_at DATETIME NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT,
+	before_json TEXT,
+	after_json TEXT,
+	prev_hash TEXT NOT NULL UNIQUE,
+	hash TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_log_occurred_at ON audit_log (occurred_at);
+CREATE INDEX idx_audit_log_action ON audit_log (action);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -0,0 +1,5 @@
+ALTER TABLE reviews ADD COLUMN note TEXT;
+-- Only plaintext comments can be copied back, encrypted notes stay empty
+UPDATE reviews SET note = (
+	SELECT f.comment FROM feedback f WHERE f.id = reviews.feedback_id AND f.key_id IS NULL
+);
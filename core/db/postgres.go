@@ -0,0 +1,44 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang-llm-sqlite-bot/core/config"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// NewPostgresStore connects to the Postgres database at cfg.DBDSN and brings
+// the schema up to date. Several bot instances can share one database.
+func NewPostgresStore(cfg *config.Config) (*SQLStore, error) {
+	db, err := openPostgres(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLStore(cfg, db, DriverPostgres)
+}
+
+// openPostgres opens and pings the configured Postgres database
+func openPostgres(cfg *config.Config) (*sql.DB, error) {
+	if cfg.DBDSN == "" {
+		return nil, errors.New("DB_DSN is required for the postgres driver")
+	}
+
+	db, err := sql.Open("pgx", cfg.DBDSN)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,19 @@
+package db_test
+
+import (
+	"testing"
+
+	"golang-llm-sqlite-bot/core/db/storetest"
+)
+
+// TestPostgresStore runs the conformance suite against the database at
+// TEST_POSTGRES_DSN and is skipped when it is not set
+func TestPostgresStore(t *testing.T) {
+	storetest.Run(t, storetest.Postgres(storetest.Config()))
+}
+
+// TestPostgresStoreEncrypted runs the conformance suite with content
+// encrypted at rest and is skipped when TEST_POSTGRES_DSN is not set
+func TestPostgresStoreEncrypted(t *testing.T) {
+	storetest.Run(t, storetest.Postgres(storetest.Encrypted(storetest.Config())))
+}
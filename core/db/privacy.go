@@ -0,0 +1,528 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParticipantRecord is a stored message sender
+type ParticipantRecord struct {
+	ID          int64     `json:"id"`
+	Channel     string    `json:"channel"`
+	ExternalID  string    `json:"external_id"`
+	DisplayName string    `json:"display_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SubjectData is everything stored about one person: their participant
+// records, their private chats, the messages they sent or that belong to
+// their chats and interactions, the interactions they triggered and the
+// settings of them and their private chats
+type SubjectData struct {
+	Subject      string              `json:"subject"`
+	ExportedAt   time.Time           `json:"exported_at"`
+	Participants []ParticipantRecord `json:"participants"`
+	Chats        []Chat              `json:"chats"`
+	Messages     []MessageRecord     `json:"messages"`
+	Interactions []InteractionRecord `json:"interactions"`
+	Feedback     []FeedbackRecord    `json:"feedback"`
+	Settings     []SettingRecord     `json:"settings"`
+}
+
+// Empty reports whether nothing is stored about the subject
+func (d *SubjectData) Empty() bool {
+	return len(d.Participants) == 0 && len(d.Chats) == 0 && len(d.Messages) == 0 &&
+		len(d.Interactions) == 0 && len(d.Feedback) == 0 && len(d.Settings) == 0
+}
+
+// Erasure is the audit record of a right-to-erasure request. The subject is
+// kept only as a hash so the record itself holds no personal data.
+type Erasure struct {
+	ID           int64     `json:"id"`
+	SubjectHash  string    `json:"subject_hash"`
+	RequestedBy  string    `json:"requested_by"`
+	Reason       string    `json:"reason,omitempty"`
+	Interactions int       `json:"interactions"`
+	Messages     int       `json:"messages"`
+	Chats        int       `json:"chats"`
+	Participants int       `json:"participants"`
+	Feedback     int       `json:"feedback"`
+	ErasedAt     time.Time `json:"erased_at"`
+}
+
+// SubjectHasher hashes sender IDs for the records that outlive an erasure:
+// the erasures table, setting authors and the audit log. With a key the hash
+// is an HMAC, so it cannot be reversed by hashing every phone number; without
+// one it is the plain SHA-256 used before SUBJECT_HASH_KEY existed.
+type SubjectHasher struct {
+	key []byte
+}
+
+// NewSubjectHasher creates a hasher keyed with secret, or an unkeyed one when
+// secret is empty
+func NewSubjectHasher(secret string) SubjectHasher {
+	if secret == "" {
+		return SubjectHasher{}
+	}
+	return SubjectHasher{key: []byte(secret)}
+}
+
+// Keyed reports whether hashes are keyed with a secret
+func (h SubjectHasher) Keyed() bool {
+	return h.key != nil
+}
+
+// Hash returns the hash under which erasures of a subject are recorded
+func (h SubjectHasher) Hash(subject string) string {
+	user := canonicalSubject(subject)
+	if h.key == nil {
+		return PromptHash(user)
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(user))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashes lists the hashes a subject may be recorded under: its hash and,
+// when keyed, the unkeyed hash of records written before the key was set
+func (h SubjectHasher) hashes(subject string) []string {
+	if h.key == nil {
+		return []string{h.Hash(subject)}
+	}
+	return []string{h.Hash(subject), SubjectHasher{}.Hash(subject)}
+}
+
+// Subjects returns the hasher the store records sender IDs with
+func (s *SQLStore) Subjects() SubjectHasher {
+	return s.subjects
+}
+
+// canonicalSubject reduces a sender ID to the bare user part of a JID
+func canonicalSubject(subject string) string {
+	if i := strings.IndexAny(subject, "@:"); i >= 0 {
+		return subject[:i]
+	}
+	return subject
+}
+
+// subjectIDs lists the forms a sender ID may be stored in: as given, as a
+// bare phone number and as a full WhatsApp JID
+func subjectIDs(subject string) (string, []interface{}) {
+	user := canonicalSubject(subject)
+	forms := []string{subject}
+	for _, form := range []string{user, user + "@s.whatsapp.net"} {
+		if form != subject {
+			forms = append(forms, form)
+		}
+	}
+
+	args := make([]interface{}, len(forms))
+	for i, form := range forms {
+		args[i] = form
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(forms)), ", "), args
+}
+
+// subjectSelection builds the conditions selecting a subject's rows. The ID
+// arguments must be passed once for every use of the ID list in a condition.
+type subjectSelection struct {
+	in   string
+	args []interface{}
+}
+
+func newSubjectSelection(subject string) subjectSelection {
+	in, args := subjectIDs(subject)
+	return subjectSelection{in: in, args: args}
+}
+
+// repeat returns the ID arguments n times, for conditions using the list n times
+func (s subjectSelection) repeat(n int) []interface{} {
+	var args []interface{}
+	for i := 0; i < n; i++ {
+		args = append(args, s.args...)
+	}
+	return args
+}
+
+// participants selects the subject's participant IDs
+func (s subjectSelection) participants() string {
+	return "SELECT id FROM participants WHERE external_id IN (" + s.in + ")"
+}
+
+// privateChats selects the IDs of the subject's one-to-one chats
+func (s subjectSelection) privateChats() string {
+	return "SELECT id FROM chats WHERE NOT is_group AND external_id IN (" + s.in + ")"
+}
+
+// interactions is the condition on interactions i the subject triggered or
+// that belong to their private chats
+func (s subjectSelection) interactions() string {
+	return "(i.sender_id IN (" + s.in + ") OR (i.chat_id IN (" + s.in + ") AND i.chat_id NOT LIKE '%@g.us'))"
+}
+
+// messages is the condition on messages m sent by the subject, in their
+// private chats or belonging to their interactions. It uses the ID list four times.
+func (s subjectSelection) messages() string {
+	return "(m.participant_id IN (" + s.participants() + ")" +
+		" OR m.chat_id IN (" + s.privateChats() + ")" +
+		" OR m.interaction_id IN (SELECT i.id FROM interactions i WHERE " + s.interactions() + "))"
+}
+
+// feedback is the condition on feedback f given by the subject or on their
+// interactions. It uses the ID list three times.
+func (s subjectSelection) feedback() string {
+	return "(f.sender_id IN (" + s.in + ") OR f.interaction_id IN (SELECT i.id FROM interactions i WHERE " + s.interactions() + "))"
+}
+
+// settings is the condition on settings st of the subject or their private
+// chats, whose IDs are the subject's ID. Group chat settings are not theirs.
+func (s subjectSelection) settings() string {
+	return "(st.subject IN (" + s.in + ") AND st.subject NOT LIKE '%@g.us')"
+}
+
+// ExportSubject collects everything stored about a sender, identified by
+// their JID or phone number
+func (s *SQLStore) ExportSubject(ctx context.Context, subject string) (*SubjectData, error) {
+	if subject == "" {
+		return nil, errors.New("subject is required")
+	}
+	sel := newSubjectSelection(subject)
+	q := s.conn()
+	data := &SubjectData{Subject: subject, ExportedAt: time.Now().UTC()}
+
+	// Participants
+	rows, err := q.QueryContext(ctx, `
+	SELECT pa.id, ch.name, pa.external_id, pa.display_name, pa.created_at, pa.updated_at
+	FROM participants pa
+	JOIN channels ch ON ch.id = pa.channel_id
+	WHERE pa.id IN (`+sel.participants()+`)
+	ORDER BY pa.id`, sel.args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying participants: %w", err)
+	}
+	for rows.Next() {
+		var p ParticipantRecord
+		var name sql.NullString
+		if err := rows.Scan(&p.ID, &p.Channel, &p.ExternalID, &name, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning participant: %w", err)
+		}
+		p.DisplayName = name.String
+		data.Participants = append(data.Participants, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading participants: %w", err)
+	}
+
+	// Private chats
+	rows, err = q.QueryContext(ctx, `
+	SELECT c.id, ch.name, c.external_id, c.is_group, c.title, c.created_at, c.last_message_at,
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_id = c.id)
+	FROM chats c
+	JOIN channels ch ON ch.id = c.channel_id
+	WHERE c.id IN (`+sel.privateChats()+`)
+	ORDER BY c.id`, sel.args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying chats: %w", err)
+	}
+	for rows.Next() {
+		var c Chat
+		var title sql.NullString
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Channel, &c.ExternalID, &c.IsGroup, &title, &c.CreatedAt, &lastSeen, &c.Messages); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning chat: %w", err)
+		}
+		c.Title = title.String
+		c.LastMessageAt = lastSeen.Time
+		data.Chats = append(data.Chats, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading chats: %w", err)
+	}
+
+	// Messages
+	rows, err = q.QueryContext(ctx, "SELECT "+messageColumns+" FROM "+messageSource+
+		" WHERE "+sel.messages()+" ORDER BY m.sent_at, m.id", sel.repeat(4)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying messages: %w", err)
+	}
+	data.Messages, err = s.scanMessages(rows)
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading messages: %w", err)
+	}
+
+	// Interactions
+	rows, err = q.QueryContext(ctx, "SELECT "+interactionColumns+" FROM "+interactionSource+
+		" WHERE "+sel.interactions()+" ORDER BY i.id", sel.repeat(2)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying interactions: %w", err)
+	}
+	for rows.Next() {
+		rec, err := s.scanInteraction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning interaction: %w", err)
+		}
+		data.Interactions = append(data.Interactions, *rec)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading interactions: %w", err)
+	}
+
+	// Feedback
+	rows, err = q.QueryContext(ctx, "SELECT "+feedbackColumns+" FROM feedback f WHERE "+sel.feedback()+" ORDER BY f.id", sel.repeat(3)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying feedback: %w", err)
+	}
+	data.Feedback, err = scanFeedback(rows, s.keys)
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading feedback: %w", err)
+	}
+
+	// Settings
+	rows, err = q.QueryContext(ctx, "SELECT "+settingColumns+" FROM settings st WHERE "+sel.settings()+
+		" ORDER BY st.scope, st.channel, st.key", sel.args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying settings: %w", err)
+	}
+	defer rows.Close()
+	if data.Settings, err = scanSettings(rows); err != nil {
+		return nil, fmt.Errorf("reading settings: %w", err)
+	}
+	return data, nil
+}
+
+// EraseSubject hard-deletes everything ExportSubject returns for a sender in
+// one transaction and records an audit entry of the erasure
+func (s *SQLStore) EraseSubject(ctx context.Context, subject, requestedBy, reason string) (*Erasure, error) {
+	if subject == "" {
+		return nil, errors.New("subject is required")
+	}
+	if requestedBy == "" {
+		return nil, errors.New("the requester of an erasure must be recorded")
+	}
+
+	sel := newSubjectSelection(subject)
+	erasure := &Erasure{SubjectHash: s.subjects.Hash(subject), RequestedBy: requestedBy, Reason: reason}
+
+	err := s.inTx(ctx, func(q queryer) error {
+		deleteRows := func(what, query string, args []interface{}) (int, error) {
+			result, err := q.ExecContext(ctx, query, args...)
+			if err != nil {
+				return 0, fmt.Errorf("deleting %s: %w", what, err)
+			}
+			n, err := result.RowsAffected()
+			return int(n), err
+		}
+
+		var err error
+		// Reviews, feedback and messages reference the other tables, so they go first
+		if _, err = deleteRows("reviews",
+			"DELETE FROM reviews WHERE interaction_id IN (SELECT i.id FROM interactions i WHERE "+sel.interactions()+
+				") OR feedback_id IN (SELECT f.id FROM feedback f WHERE "+sel.feedback()+")", sel.repeat(5)); err != nil {
+			return err
+		}
+		if erasure.Feedback, err = deleteRows("feedback",
+			"DELETE FROM feedback WHERE id IN (SELECT f.id FROM feedback f WHERE "+sel.feedback()+")", sel.repeat(3)); err != nil {
+			return err
+		}
+		if erasure.Messages, err = deleteRows("messages",
+			"DELETE FROM messages WHERE id IN (SELECT m.id FROM messages m WHERE "+sel.messages()+")", sel.repeat(4)); err != nil {
+			return err
+		}
+		// Failures hold no personal data but reference the interactions
+		if _, err = deleteRows("failures",
+			"DELETE FROM failures WHERE interaction_id IN (SELECT i.id FROM interactions i WHERE "+sel.interactions()+")", sel.repeat(2)); err != nil {
+			return err
+		}
+		if _, err = deleteRows("embeddings",
+			"DELETE FROM embeddings WHERE interaction_id IN (SELECT i.id FROM interactions i WHERE "+sel.interactions()+")", sel.repeat(2)); err != nil {
+			return err
+		}
+		if erasure.Interactions, err = deleteRows("interactions",
+			"DELETE FROM interactions WHERE id IN (SELECT i.id FROM interactions i WHERE "+sel.interactions()+")", sel.repeat(2)); err != nil {
+			return err
+		}
+		if erasure.Chats, err = deleteRows("chats",
+			"DELETE FROM chats WHERE id IN ("+sel.privateChats()+")", sel.args); err != nil {
+			return err
+		}
+		if erasure.Participants, err = deleteRows("participants",
+			"DELETE FROM participants WHERE id IN ("+sel.participants()+")", sel.args); err != nil {
+			return err
+		}
+		if _, err = deleteRows("settings",
+			"DELETE FROM settings WHERE (scope, channel, subject, key) IN (SELECT st.scope, st.channel, st.subject, st.key FROM settings st WHERE "+
+				sel.settings()+")", sel.args); err != nil {
+			return err
+		}
+		// Group chat settings the subject changed stay, without their name or
+		// the subject hash chat commands record them by
+		authors := sel.repeat(1)
+		for _, hash := range s.subjects.hashes(subject) {
+			authors = append(authors, subjectTarget(hash))
+		}
+		if _, err = q.ExecContext(ctx, "UPDATE settings SET updated_by = NULL WHERE updated_by IN ("+
+			strings.TrimSuffix(strings.Repeat("?, ", len(authors)), ", ")+")", authors...); err != nil {
+			return fmt.Errorf("removing the subject from settings: %w", err)
+		}
+
+		const audit = `
+		INSERT INTO erasures (subject_hash, requested_by, reason, interactions, messages, chats, participants, feedback)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, erased_at`
+		err = q.QueryRowContext(ctx, audit, erasure.SubjectHash, erasure.RequestedBy, nullString(erasure.Reason),
+			erasure.Interactions, erasure.Messages, erasure.Chats, erasure.Participants, erasure.Feedback,
+		).Scan(&erasure.ID, &erasure.ErasedAt)
+		if err != nil {
+			return fmt.Errorf("recording erasure: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+// ListErasures returns the erasure audit records, newest first. A non-empty
+// subject restricts the list to erasures of that sender, including those
+// recorded before SUBJECT_HASH_KEY was set.
+func (s *SQLStore) ListErasures(ctx context.Context, subject string) ([]Erasure, error) {
+	query := "SELECT " + erasureColumns + " FROM erasures"
+	var args []interface{}
+	if subject != "" {
+		query += " WHERE subject_hash IN (?, ?)"
+		hashes := s.subjects.hashes(subject)
+		args = append(args, hashes[0], hashes[len(hashes)-1])
+	}
+	query += " ORDER BY id DESC"
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying erasures: %w", err)
+	}
+	return scanErasures(rows)
+}
+
+// erasureColumns are the columns scanErasures reads
+const erasureColumns = "id, subject_hash, requested_by, reason, interactions, messages, chats, participants, feedback, erased_at"
+
+// scanErasures reads and closes rows of erasureColumns
+func scanErasures(rows *sql.Rows) ([]Erasure, error) {
+	defer rows.Close()
+
+	var erasures []Erasure
+	for rows.Next() {
+		var e Erasure
+		var reason sql.NullString
+		if err := rows.Scan(&e.ID, &e.SubjectHash, &e.RequestedBy, &reason, &e.Interactions,
+			&e.Messages, &e.Chats, &e.Participants, &e.Feedback, &e.ErasedAt); err != nil {
+			return nil, fmt.Errorf("scanning erasure: %w", err)
+		}
+		e.Reason = reason.String
+		erasures = append(erasures, e)
+	}
+	return erasures, rows.Err()
+}
+
+// ReapplyErasures carries erasures over from another database, such as the
+// one a backup is about to replace, so restoring an old backup does not bring
+// erased senders back. Erasures this database already has are skipped. The
+// data of every sender matching one of the others is erased again, and the
+// erasure records are copied. It returns how many erasures were new to this
+// database and the erasures that removed data again.
+func (s *SQLStore) ReapplyErasures(ctx context.Context, erasures []Erasure) (int, []Erasure, error) {
+	known, err := s.ListErasures(ctx, "")
+	if err != nil {
+		return 0, nil, err
+	}
+	recorded := make(map[string]bool, len(known))
+	for _, e := range known {
+		recorded[e.SubjectHash+"@"+e.ErasedAt.UTC().Format(time.RFC3339)] = true
+	}
+	var pending []Erasure
+	bySubject := make(map[string]Erasure)
+	for _, e := range erasures {
+		if !recorded[e.SubjectHash+"@"+e.ErasedAt.UTC().Format(time.RFC3339)] {
+			pending = append(pending, e)
+			bySubject[e.SubjectHash] = e
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil, nil
+	}
+
+	// Only hashes are recorded, so every stored sender ID is hashed to find
+	// the erased ones
+	const subjects = `
+	SELECT external_id FROM participants
+	UNION SELECT sender_id FROM interactions WHERE sender_id IS NOT NULL
+	UNION SELECT sender_id FROM feedback WHERE sender_id IS NOT NULL
+	UNION SELECT external_id FROM chats WHERE NOT is_group`
+	rows, err := s.conn().QueryContext(ctx, subjects)
+	if err != nil {
+		return 0, nil, fmt.Errorf("querying senders: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("scanning sender: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, nil, fmt.Errorf("querying senders: %w", err)
+	}
+
+	var reapplied []Erasure
+	erased := make(map[string]bool)
+	for _, id := range ids {
+		for _, hash := range s.subjects.hashes(id) {
+			e, ok := bySubject[hash]
+			if !ok || erased[hash] {
+				continue
+			}
+			erased[hash] = true
+			reason := "re-applied after a restore"
+			if e.Reason != "" {
+				reason += ": " + e.Reason
+			}
+			erasure, err := s.EraseSubject(ctx, id, e.RequestedBy, reason)
+			if err != nil {
+				return 0, reapplied, fmt.Errorf("erasing subject %s again: %w", hash[:12], err)
+			}
+			reapplied = append(reapplied, *erasure)
+		}
+	}
+
+	const copyErasure = `
+	INSERT INTO erasures (subject_hash, requested_by, reason, interactions, messages, chats, participants, feedback, erased_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, e := range pending {
+		if _, err := s.conn().ExecContext(ctx, copyErasure, e.SubjectHash, e.RequestedBy, nullString(e.Reason),
+			e.Interactions, e.Messages, e.Chats, e.Participants, e.Feedback, e.ErasedAt.UTC()); err != nil {
+			return 0, reapplied, fmt.Errorf("copying erasure record: %w", err)
+		}
+	}
+	return len(pending), reapplied, nil
+}
@@ -0,0 +1,64 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// PromptVersion is a distinct system prompt seen by the bot
+type PromptVersion struct {
+	Hash         string
+	Content      string
+	CreatedAt    time.Time
+	Interactions int
+}
+
+// PromptHash returns the content hash identifying a system prompt version
+func PromptHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ensurePromptVersion records the prompt content under its hash and returns the hash
+func ensurePromptVersion(ctx context.Context, q queryer, content string) (string, error) {
+	if content == "" {
+		return "", nil
+	}
+
+	hash := PromptHash(content)
+	const query = `INSERT INTO prompt_versions (hash, content) VALUES (?, ?) ON CONFLICT(hash) DO NOTHING`
+	if _, err := q.ExecContext(ctx, query, hash, content); err != nil {
+		return "", fmt.Errorf("recording prompt version: %w", err)
+	}
+	return hash, nil
+}
+
+// ListPromptVersions returns all recorded prompt versions, newest first
+func (s *SQLStore) ListPromptVersions(ctx context.Context) ([]PromptVersion, error) {
+	const query = `
+	SELECT p.hash, p.content, p.created_at, COUNT(i.id)
+	FROM prompt_versions p
+	LEFT JOIN interactions i ON i.prompt_version = p.hash
+	GROUP BY p.hash, p.content, p.created_at
+	ORDER BY p.created_at DESC`
+
+	rows, err := s.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("querying prompt versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []PromptVersion
+	for rows.Next() {
+		var v PromptVersion
+		if err := rows.Scan(&v.Hash, &v.Content, &v.CreatedAt, &v.Interactions); err != nil {
+			return nil, fmt.Errorf("scanning prompt version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,383 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultPageSize is used when a page or list limit is not set
+const defaultPageSize = 100
+
+// InteractionFilter restricts interaction queries. Empty fields match everything.
+type InteractionFilter struct {
+	Channel       string
+	ChatID        string // external chat ID
+	SenderID      string // external sender ID
+	PromptVersion string // full hash or a unique prefix
+	Model         string
+	Provider      string
+	Experiment    string
+	Variant       string
+	From          time.Time // inclusive
+	To            time.Time // exclusive
+	Rating        RatingFilter
+	Review        ReviewFilter
+}
+
+// Page selects a window of results using keyset pagination on the ID
+type Page struct {
+	AfterID int64 // return items with a larger ID, 0 to start at the beginning
+	Limit   int
+}
+
+// InteractionPage is one page of interactions in ID order
+type InteractionPage struct {
+	Items []InteractionRecord
+	// NextAfterID is the AfterID of the following page, 0 when this is the last page
+	NextAfterID int64
+}
+
+// ListChatsOptions controls which chats are listed
+type ListChatsOptions struct {
+	Channel string
+	Limit   int
+	Offset  int
+}
+
+// where builds the SQL condition and arguments for the filter
+func (f InteractionFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+
+	if f.Channel != "" {
+		add("i.channel = ?", f.Channel)
+	}
+	if f.ChatID != "" {
+		add("i.chat_id = ?", f.ChatID)
+	}
+	if f.SenderID != "" {
+		add("i.sender_id = ?", f.SenderID)
+	}
+	if f.PromptVersion != "" {
+		add("i.prompt_version LIKE ?", f.PromptVersion+"%")
+	}
+	if f.Model != "" {
+		add("i.model = ?", f.Model)
+	}
+	if f.Provider != "" {
+		add("i.provider = ?", f.Provider)
+	}
+	if f.Experiment != "" {
+		add("i.experiment = ?", f.Experiment)
+	}
+	if f.Variant != "" {
+		add("i.variant = ?", f.Variant)
+	}
+	if !f.From.IsZero() {
+		add("i.timestamp >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("i.timestamp < ?", f.To.UTC())
+	}
+
+	if cond := f.Rating.condition(); cond != "" {
+		conds = append(conds, cond)
+	}
+	if cond := f.Review.condition(); cond != "" {
+		conds = append(conds, cond)
+	}
+
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// interactionColumns is the column list read by scanInteraction
+const interactionColumns = `
+	i.id, i.timestamp, i.channel, i.chat_id, i.sender_id, i.user_input, i.llm_response,
+	i.prompt_version, p.content, i.model, i.provider, i.temperature, i.top_p, i.max_tokens,
+	i.experiment, i.variant, i.latency_ms, i.prompt_tokens, i.completion_tokens, i.key_id,
+	(SELECT COALESCE(SUM(f.rating), 0) FROM feedback f WHERE f.interaction_id = i.id),
+	rv.status, rv.reviewer, rv.reviewed_at, rvf.comment, rvf.correction, rvf.key_id`
+
+// interactionSource joins the prompt content and the review for interactionColumns
+const interactionSource = `interactions i
+	LEFT JOIN prompt_versions p ON p.hash = i.prompt_version
+	LEFT JOIN reviews rv ON rv.interaction_id = i.id
+	LEFT JOIN feedback rvf ON rvf.id = rv.feedback_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanInteraction reads interactionColumns followed by any extra columns into
+// extra and decrypts the prompt and response
+func (s *SQLStore) scanInteraction(row rowScanner, extra ...interface{}) (*InteractionRecord, error) {
+	var (
+		rec                                                InteractionRecord
+		channel, chatID, senderID, version, prompt         sql.NullString
+		model, provider, experiment, variant, keyID        sql.NullString
+		temperature, topP                                  sql.NullFloat64
+		maxTokens, latency, promptTokens, completionTokens sql.NullInt64
+		reviewStatus, reviewer, reviewNote, correction     sql.NullString
+		reviewKeyID                                        sql.NullString
+		reviewedAt                                         sql.NullTime
+	)
+
+	dest := []interface{}{&rec.ID, &rec.CreatedAt, &channel, &chatID, &senderID, &rec.Prompt, &rec.Response,
+		&version, &prompt, &model, &provider, &temperature, &topP, &maxTokens,
+		&experiment, &variant, &latency, &promptTokens, &completionTokens, &keyID, &rec.Rating,
+		&reviewStatus, &reviewer, &reviewedAt, &reviewNote, &correction, &reviewKeyID}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if err := openValue(s.keys, keyID, labelPrompt, &rec.Prompt); err != nil {
+		return nil, err
+	}
+	if err := openValue(s.keys, keyID, labelResponse, &rec.Response); err != nil {
+		return nil, err
+	}
+	if err := openValue(s.keys, reviewKeyID, labelComment, &reviewNote.String); err != nil {
+		return nil, err
+	}
+	if err := openValue(s.keys, reviewKeyID, labelCorrection, &correction.String); err != nil {
+		return nil, err
+	}
+
+	rec.Channel = channel.String
+	rec.ChatID = chatID.String
+	rec.SenderID = senderID.String
+	rec.PromptVersion = version.String
+	rec.SystemPrompt = prompt.String
+	rec.Model = model.String
+	rec.Provider = provider.String
+	rec.Temperature = temperature.Float64
+	rec.TopP = topP.Float64
+	rec.MaxTokens = int(maxTokens.Int64)
+	rec.Experiment = experiment.String
+	rec.Variant = variant.String
+	rec.LatencyMS = latency.Int64
+	rec.PromptTokens = int(promptTokens.Int64)
+	rec.CompletionTokens = int(completionTokens.Int64)
+	if reviewStatus.Valid {
+		rec.Review = &Review{
+			InteractionID:     rec.ID,
+			Status:            reviewStatus.String,
+			CorrectedResponse: correction.String,
+			Reviewer:          reviewer.String,
+			Note:              reviewNote.String,
+			ReviewedAt:        reviewedAt.Time,
+		}
+	}
+	return &rec, nil
+}
+
+// GetInteraction returns a single interaction or ErrNotFound
+func (s *SQLStore) GetInteraction(ctx context.Context, id int64) (*InteractionRecord, error) {
+	query := "SELECT " + interactionColumns + " FROM " + interactionSource + " WHERE i.id = ?"
+
+	rec, err := s.scanInteraction(s.conn().QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("interaction %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying interaction %d: %w", id, err)
+	}
+	return rec, nil
+}
+
+// ListInteractions returns a page of interactions matching the filter in ID order
+func (s *SQLStore) ListInteractions(ctx context.Context, filter InteractionFilter, page Page) (*InteractionPage, error) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	where, args := filter.where()
+	query := "SELECT " + interactionColumns + " FROM " + interactionSource +
+		" WHERE " + where + " AND i.id > ? ORDER BY i.id LIMIT ?"
+	args = append(args, page.AfterID, limit+1)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying interactions: %w", err)
+	}
+	defer rows.Close()
+
+	result := &InteractionPage{}
+	for rows.Next() {
+		rec, err := s.scanInteraction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning interaction: %w", err)
+		}
+		result.Items = append(result.Items, *rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading interactions: %w", err)
+	}
+
+	// One extra row is fetched to know whether another page follows
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		result.NextAfterID = result.Items[limit-1].ID
+	}
+	return result, nil
+}
+
+// CountInteractions returns the number of interactions matching the filter
+func (s *SQLStore) CountInteractions(ctx context.Context, filter InteractionFilter) (int, error) {
+	where, args := filter.where()
+
+	var count int
+	if err := s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM interactions i WHERE "+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting interactions: %w", err)
+	}
+	return count, nil
+}
+
+// messageColumns is the column list read by scanMessages
+const messageColumns = `
+	m.id, c.external_id, m.interaction_id, pa.external_id, m.role, m.content, m.external_id,
+	m.reply_to_external_id, m.quoted_content, m.sent_at, m.key_id`
+
+// messageSource joins the chat and sender for messageColumns
+const messageSource = `messages m
+	JOIN chats c ON c.id = m.chat_id
+	LEFT JOIN participants pa ON pa.id = m.participant_id`
+
+// scanMessages reads messageColumns rows and decrypts their content
+func (s *SQLStore) scanMessages(rows *sql.Rows) ([]MessageRecord, error) {
+	var messages []MessageRecord
+	for rows.Next() {
+		var (
+			m                                  MessageRecord
+			interactionID                      sql.NullInt64
+			sender, externalID, replyTo, quote sql.NullString
+			keyID                              sql.NullString
+		)
+		if err := rows.Scan(&m.ID, &m.ChatID, &interactionID, &sender, &m.Role, &m.Content, &externalID,
+			&replyTo, &quote, &m.SentAt, &keyID); err != nil {
+			return nil, fmt.Errorf("scanning message: %w", err)
+		}
+		if err := openValue(s.keys, keyID, labelContent, &m.Content); err != nil {
+			return nil, err
+		}
+		if err := openValue(s.keys, keyID, labelQuoted, &quote.String); err != nil {
+			return nil, err
+		}
+		m.InteractionID = interactionID.Int64
+		m.SenderID = sender.String
+		m.ExternalID = externalID.String
+		m.ReplyToExternalID = replyTo.String
+		m.QuotedContent = quote.String
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// RecentHistory returns up to limit of the latest messages of a chat, oldest first
+func (s *SQLStore) RecentHistory(ctx context.Context, chat ChatRef, limit int) ([]MessageRecord, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	query := "SELECT " + messageColumns + " FROM " + messageSource + `
+	JOIN channels ch ON ch.id = c.channel_id
+	WHERE ch.name = ? AND c.external_id = ?
+	ORDER BY m.sent_at DESC, m.id DESC
+	LIMIT ?`
+
+	rows, err := s.conn().QueryContext(ctx, query, chat.Channel, chat.ExternalID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying chat history: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := s.scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("reading chat history: %w", err)
+	}
+
+	// Rows were fetched newest first to apply the limit
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// FindReply returns the bot's reply with the given external message ID in a
+// chat, or its latest reply when externalID is empty. It returns ErrNotFound
+// when there is no such reply.
+func (s *SQLStore) FindReply(ctx context.Context, chat ChatRef, externalID string) (*MessageRecord, error) {
+	query := "SELECT " + messageColumns + " FROM " + messageSource + `
+	JOIN channels ch ON ch.id = c.channel_id
+	WHERE ch.name = ? AND c.external_id = ? AND m.role = ? AND m.interaction_id IS NOT NULL
+		AND (? = '' OR m.external_id = ?)
+	ORDER BY m.sent_at DESC, m.id DESC
+	LIMIT 1`
+
+	rows, err := s.conn().QueryContext(ctx, query, chat.Channel, chat.ExternalID, RoleAssistant, externalID, externalID)
+	if err != nil {
+		return nil, fmt.Errorf("querying reply: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := s.scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("reading reply: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, ErrNotFound
+	}
+	return &messages[0], nil
+}
+
+// ListChats returns chats ordered by most recent activity
+func (s *SQLStore) ListChats(ctx context.Context, opts ListChatsOptions) ([]Chat, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	const query = `
+	SELECT c.id, ch.name, c.external_id, c.is_group, c.title, c.created_at, c.last_message_at,
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_id = c.id)
+	FROM chats c
+	JOIN channels ch ON ch.id = c.channel_id
+	WHERE ? = '' OR ch.name = ?
+	ORDER BY c.last_message_at IS NULL, c.last_message_at DESC, c.id DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := s.conn().QueryContext(ctx, query, opts.Channel, opts.Channel, limit, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("querying chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []Chat
+	for rows.Next() {
+		var (
+			c        Chat
+			title    sql.NullString
+			lastSeen sql.NullTime
+		)
+		if err := rows.Scan(&c.ID, &c.Channel, &c.ExternalID, &c.IsGroup, &title, &c.CreatedAt, &lastSeen, &c.Messages); err != nil {
+			return nil, fmt.Errorf("scanning chat: %w", err)
+		}
+		c.Title = title.String
+		c.LastMessageAt = lastSeen.Time
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
@@ -0,0 +1,211 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy bounds how long interactions are kept. Zero values keep
+// everything. Interactions without a chat are only removed by MaxAge.
+type RetentionPolicy struct {
+	MaxAge     time.Duration // delete interactions older than this
+	MaxPerChat int           // keep only this many of the newest interactions per chat
+}
+
+// Enabled reports whether the policy removes anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxPerChat > 0
+}
+
+// expiredQuery selects the interactions of a channel that violate the policy.
+// Interactions without a channel belong to the channel "". Interactions
+// without a chat, from the CLI or stored before chats were recorded, are not
+// one chat, so only MaxAge applies to them.
+func expiredQuery(channel string, policy RetentionPolicy, now time.Time) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if policy.MaxAge > 0 {
+		conds = append(conds, "created_at < ?")
+		args = append(args, now.Add(-policy.MaxAge).UTC())
+	}
+	if policy.MaxPerChat > 0 {
+		conds = append(conds, "(chat_id IS NOT NULL AND position > ?)")
+		args = append(args, policy.MaxPerChat)
+	}
+	if len(conds) == 0 {
+		conds = append(conds, "1 = 0")
+	}
+
+	query := `
+	SELECT id FROM (
+		SELECT id, chat_id, timestamp AS created_at, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY id DESC) AS position
+		FROM interactions
+		WHERE COALESCE(channel, '') = ?
+	) ranked
+	WHERE ` + strings.Join(conds, " OR ")
+	return query, append([]interface{}{channel}, args...)
+}
+
+// InteractionChannels returns the channels that have stored interactions
+func (s *SQLStore) InteractionChannels(ctx context.Context) ([]string, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT DISTINCT COALESCE(channel, '') FROM interactions ORDER BY 1")
+	if err != nil {
+		return nil, fmt.Errorf("querying interaction channels: %w", err)
+	}
+	defer rows.Close()
+
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return nil, fmt.Errorf("scanning channel: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// CountExpiredInteractions returns how many interactions of a channel violate the policy
+func (s *SQLStore) CountExpiredInteractions(ctx context.Context, channel string, policy RetentionPolicy, now time.Time) (int, error) {
+	query, args := expiredQuery(channel, policy, now)
+
+	var count int
+	if err := s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+query+") expired", args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting expired interactions: %w", err)
+	}
+	return count, nil
+}
+
+// ExpiredInteractionIDs returns up to limit of the oldest interactions of a
+// channel that violate the policy
+func (s *SQLStore) ExpiredInteractionIDs(ctx context.Context, channel string, policy RetentionPolicy, now time.Time, limit int) ([]int64, error) {
+	query, args := expiredQuery(channel, policy, now)
+
+	rows, err := s.conn().QueryContext(ctx, query+" ORDER BY id LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying expired interactions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning interaction ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// InteractionsByID returns the interactions with the given IDs in ID order
+func (s *SQLStore) InteractionsByID(ctx context.Context, ids []int64) ([]InteractionRecord, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	in, args := inList(ids)
+	query := "SELECT " + interactionColumns + " FROM " + interactionSource + " WHERE i.id IN (" + in + ") ORDER BY i.id"
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying interactions: %w", err)
+	}
+	defer rows.Close()
+
+	var records []InteractionRecord
+	for rows.Next() {
+		rec, err := s.scanInteraction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning interaction: %w", err)
+		}
+		records = append(records, *rec)
+	}
+	return records, rows.Err()
+}
+
+// DeleteInteractions removes interactions together with their messages,
+// reviews, feedback, embeddings and delivery failures and returns the number of interactions deleted
+func (s *SQLStore) DeleteInteractions(ctx context.Context, ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	in, args := inList(ids)
+	var deleted int64
+	err := s.inTx(ctx, func(q queryer) error {
+		if _, err := q.ExecContext(ctx, "DELETE FROM reviews WHERE interaction_id IN ("+in+")", args...); err != nil {
+			return fmt.Errorf("deleting reviews: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM feedback WHERE interaction_id IN ("+in+")", args...); err != nil {
+			return fmt.Errorf("deleting feedback: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM failures WHERE interaction_id IN ("+in+")", args...); err != nil {
+			return fmt.Errorf("deleting failures: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM embeddings WHERE interaction_id IN ("+in+")", args...); err != nil {
+			return fmt.Errorf("deleting embeddings: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM messages WHERE interaction_id IN ("+in+")", args...); err != nil {
+			return fmt.Errorf("deleting messages: %w", err)
+		}
+		result, err := q.ExecContext(ctx, "DELETE FROM interactions WHERE id IN ("+in+")", args...)
+		if err != nil {
+			return fmt.Errorf("deleting interactions: %w", err)
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return int(deleted), err
+}
+
+// Reclaim returns the space freed by deletions to the operating system. On
+// SQLite the database is switched to incremental auto-vacuum the first time,
+// which rewrites it once; Postgres leaves this to autovacuum.
+func (s *SQLStore) Reclaim(ctx context.Context) error {
+	if s.driver != DriverSQLite {
+		return nil
+	}
+
+	var mode int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("reading auto_vacuum mode: %w", err)
+	}
+
+	// 2 is INCREMENTAL; other modes need a full VACUUM to switch
+	if mode != 2 {
+		log.Printf("Enabling incremental auto-vacuum, rewriting the database once")
+
+		// The new mode only takes effect through VACUUM on the same connection
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("acquiring connection: %w", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return fmt.Errorf("enabling incremental auto-vacuum: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return fmt.Errorf("vacuuming database: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, "PRAGMA incremental_vacuum"); err != nil {
+		return fmt.Errorf("running incremental vacuum: %w", err)
+	}
+	return nil
+}
+
+// inList returns a placeholder list and arguments for an IN clause
+func inList(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -0,0 +1,153 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Review statuses. An interaction nobody has reviewed yet is pending.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved" // good as it is
+	ReviewRejected = "rejected" // not fit for training
+	ReviewEdited   = "edited"   // replaced by a corrected response
+)
+
+// Review is an operator's verdict on an interaction while curating datasets
+type Review struct {
+	InteractionID     int64     `json:"interaction_id"`
+	Status            string    `json:"status"`
+	CorrectedResponse string    `json:"corrected_response,omitempty"` // set for edited interactions
+	Reviewer          string    `json:"reviewer,omitempty"`
+	Note              string    `json:"note,omitempty"`
+	ReviewedAt        time.Time `json:"reviewed_at"`
+}
+
+// ReviewFilter selects interactions by their review status
+type ReviewFilter string
+
+// Review filters besides the statuses
+const (
+	ReviewAny      ReviewFilter = ""
+	ReviewAccepted ReviewFilter = "accepted" // approved or edited, fit for training
+)
+
+// ParseReviewFilter validates a review filter given on the command line
+func ParseReviewFilter(s string) (ReviewFilter, error) {
+	switch r := ReviewFilter(s); r {
+	case ReviewAny, ReviewAccepted, ReviewPending, ReviewApproved, ReviewRejected, ReviewEdited:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown review filter %q, expected pending, approved, rejected, edited or accepted", s)
+	}
+}
+
+// condition returns the SQL condition on interactions i for the filter. An
+// unknown filter matches nothing.
+func (r ReviewFilter) condition() string {
+	const reviewed = "EXISTS (SELECT 1 FROM reviews r WHERE r.interaction_id = i.id AND r.status "
+	switch r {
+	case ReviewAny:
+		return ""
+	case ReviewPending:
+		return "NOT EXISTS (SELECT 1 FROM reviews r WHERE r.interaction_id = i.id)"
+	case ReviewApproved, ReviewRejected, ReviewEdited:
+		return reviewed + "= '" + string(r) + "')"
+	case ReviewAccepted:
+		return reviewed + "IN ('" + ReviewApproved + "', '" + ReviewEdited + "'))"
+	default:
+		return "1 = 0"
+	}
+}
+
+// Validate checks that the review is complete for its status
+func (rev *Review) Validate() error {
+	switch rev.Status {
+	case ReviewPending:
+		return nil
+	case ReviewApproved, ReviewRejected, ReviewEdited:
+	default:
+		return fmt.Errorf("invalid review status %q", rev.Status)
+	}
+	if rev.Reviewer == "" {
+		return errors.New("a review needs the reviewer")
+	}
+	if rev.Status == ReviewEdited && rev.CorrectedResponse == "" {
+		return errors.New("an edited review needs the corrected response")
+	}
+	if rev.Status != ReviewEdited && rev.CorrectedResponse != "" {
+		return errors.New("only edited reviews have a corrected response")
+	}
+	return nil
+}
+
+// SetReview stores the verdict on an interaction in place of any earlier
+// one and sets rev.ReviewedAt. The verdict is also recorded as operator
+// feedback: approved rates the interaction positive, rejected and edited
+// negative. The note and the corrected response of an edited interaction
+// become the feedback's comment and correction, so they are encrypted with
+// it. Setting the status back to pending removes both.
+// It returns ErrNotFound when the interaction does not exist.
+func (s *SQLStore) SetReview(ctx context.Context, rev *Review) error {
+	if err := rev.Validate(); err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q queryer) error {
+		var found int
+		if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM interactions WHERE id = ?", rev.InteractionID).Scan(&found); err != nil {
+			return fmt.Errorf("querying interaction %d: %w", rev.InteractionID, err)
+		}
+		if found == 0 {
+			return fmt.Errorf("interaction %d: %w", rev.InteractionID, ErrNotFound)
+		}
+
+		// The review goes first, it references the feedback
+		var feedbackID sql.NullInt64
+		err := q.QueryRowContext(ctx, "SELECT feedback_id FROM reviews WHERE interaction_id = ?", rev.InteractionID).Scan(&feedbackID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("querying earlier review: %w", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM reviews WHERE interaction_id = ?", rev.InteractionID); err != nil {
+			return fmt.Errorf("deleting earlier review: %w", err)
+		}
+		if feedbackID.Valid {
+			if _, err := q.ExecContext(ctx, "DELETE FROM feedback WHERE id = ?", feedbackID.Int64); err != nil {
+				return fmt.Errorf("deleting earlier review feedback: %w", err)
+			}
+		}
+		if rev.Status == ReviewPending {
+			rev.ReviewedAt = time.Time{}
+			return nil
+		}
+
+		fb := &FeedbackRecord{
+			InteractionID: rev.InteractionID,
+			Rating:        RatingNegative,
+			Source:        FeedbackOperator,
+			SenderID:      rev.Reviewer,
+			Comment:       rev.Note,
+			Correction:    rev.CorrectedResponse,
+		}
+		if rev.Status == ReviewApproved {
+			fb.Rating = RatingPositive
+		}
+		if err := insertFeedback(ctx, q, s.keys, fb); err != nil {
+			return err
+		}
+
+		const insert = `
+		INSERT INTO reviews (interaction_id, status, reviewer, feedback_id)
+		VALUES (?, ?, ?, ?)
+		RETURNING reviewed_at`
+		err = q.QueryRowContext(ctx, insert, rev.InteractionID, rev.Status, rev.Reviewer, fb.ID).Scan(&rev.ReviewedAt)
+		if err != nil {
+			return fmt.Errorf("inserting review: %w", err)
+		}
+		return nil
+	})
+}
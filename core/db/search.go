@@ -0,0 +1,263 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Default markers placed around matched terms in snippets
+const (
+	DefaultHighlightStart = "["
+	DefaultHighlightEnd   = "]"
+)
+
+// SearchQuery is a full-text search over prompts and responses
+type SearchQuery struct {
+	// Text holds the words to look for. All words must match; a trailing *
+	// matches a prefix (refund* finds refunds and refunded).
+	Text   string
+	Filter InteractionFilter
+	Limit  int
+
+	// HighlightStart and HighlightEnd surround matched terms in the snippets
+	HighlightStart string
+	HighlightEnd   string
+}
+
+// SearchResult is an interaction matching a search, best matches first
+type SearchResult struct {
+	Interaction     InteractionRecord
+	PromptSnippet   string
+	ResponseSnippet string
+	Score           float64 // higher is more relevant
+}
+
+// snippetWords is the approximate length of a snippet
+const snippetWords = 16
+
+// Search finds interactions whose prompt or response match the query, ranked by relevance
+func (s *SQLStore) Search(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	terms := searchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, errors.New("search text is empty")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	start, end := q.HighlightStart, q.HighlightEnd
+	if start == "" && end == "" {
+		start, end = DefaultHighlightStart, DefaultHighlightEnd
+	}
+
+	// Encrypted content is not indexed, so it is searched by decrypting
+	if s.keys != nil {
+		return s.scanSearch(ctx, q.Filter, terms, limit, start, end)
+	}
+
+	where, filterArgs := q.Filter.where()
+
+	var query string
+	var args []interface{}
+	switch s.driver {
+	case DriverPostgres:
+		query = `
+		SELECT ` + interactionColumns + `,
+			ts_headline('english', i.user_input, q.query, ?),
+			ts_headline('english', i.llm_response, q.query, ?),
+			ts_rank(i.search_vector, q.query)
+		FROM ` + interactionSource + `
+		CROSS JOIN to_tsquery('english', ?) AS q(query)
+		WHERE i.search_vector @@ q.query AND ` + where + `
+		ORDER BY ts_rank(i.search_vector, q.query) DESC, i.id DESC
+		LIMIT ?`
+		options := headlineOptions(start, end)
+		args = append(args, options, options, postgresQuery(terms))
+
+	default:
+		query = `
+		SELECT ` + interactionColumns + `,
+			snippet(interactions_fts, 0, ?, ?, '...', ?),
+			snippet(interactions_fts, 1, ?, ?, '...', ?),
+			-bm25(interactions_fts)
+		FROM ` + interactionSource + `
+		JOIN interactions_fts ON interactions_fts.rowid = i.id
+		WHERE interactions_fts MATCH ? AND ` + where + `
+		ORDER BY bm25(interactions_fts), i.id DESC
+		LIMIT ?`
+		args = append(args, start, end, snippetWords, start, end, snippetWords, fts5Query(terms))
+	}
+	args = append(args, filterArgs...)
+	args = append(args, limit)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("searching interactions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		rec, err := s.scanInteraction(rows, &r.PromptSnippet, &r.ResponseSnippet, &r.Score)
+		if err != nil {
+			return nil, fmt.Errorf("scanning search result: %w", err)
+		}
+		r.Interaction = *rec
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading search results: %w", err)
+	}
+	return results, nil
+}
+
+// scanSearch searches by decrypting every interaction matching the filter.
+// Terms match the start of words, which stands in for the stemming of the
+// full-text indexes, and prompt matches weigh more than response matches.
+func (s *SQLStore) scanSearch(ctx context.Context, filter InteractionFilter, terms []searchTerm, limit int, start, end string) ([]SearchResult, error) {
+	var results []SearchResult
+	err := eachInteractionPage(ctx, s, filter, func(items []InteractionRecord) error {
+		for _, rec := range items {
+			promptSnippet, promptMatched, promptHits := matchWords(rec.Prompt, terms, start, end)
+			responseSnippet, responseMatched, responseHits := matchWords(rec.Response, terms, start, end)
+
+			all := true
+			for i := range terms {
+				all = all && (promptMatched[i] || responseMatched[i])
+			}
+			if !all {
+				continue
+			}
+			results = append(results, SearchResult{
+				Interaction:     rec,
+				PromptSnippet:   promptSnippet,
+				ResponseSnippet: responseSnippet,
+				Score:           float64(2*promptHits + responseHits),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Interaction.ID > results[j].Interaction.ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// matchWords finds the terms in text. It returns a snippet around the first
+// match with every match highlighted, which terms matched and the number of
+// matching words.
+func matchWords(text string, terms []searchTerm, start, end string) (string, []bool, int) {
+	words := strings.Fields(text)
+	matched := make([]bool, len(terms))
+	highlighted := make([]bool, len(words))
+	first, hits := -1, 0
+
+	for i, word := range words {
+		bare := strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+		for j, t := range terms {
+			if bare != "" && strings.HasPrefix(bare, strings.ToLower(t.word)) {
+				matched[j] = true
+				highlighted[i] = true
+			}
+		}
+		if highlighted[i] {
+			hits++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	from := max(first-snippetWords/4, 0)
+	to := min(from+snippetWords, len(words))
+	parts := make([]string, 0, to-from+2)
+	if from > 0 {
+		parts = append(parts, "...")
+	}
+	for i := from; i < to; i++ {
+		if highlighted[i] {
+			parts = append(parts, start+words[i]+end)
+		} else {
+			parts = append(parts, words[i])
+		}
+	}
+	if to < len(words) {
+		parts = append(parts, "...")
+	}
+	return strings.Join(parts, " "), matched, hits
+}
+
+// searchTerm is a single word of a search, optionally matching as a prefix
+type searchTerm struct {
+	word   string
+	prefix bool
+}
+
+// searchTerms splits free text into words, dropping query syntax so user
+// input can never produce an invalid query
+func searchTerms(text string) []searchTerm {
+	var terms []searchTerm
+	for _, field := range strings.Fields(text) {
+		prefix := strings.HasSuffix(field, "*")
+		word := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`"'*:&|!()\\`, r) {
+				return -1
+			}
+			return r
+		}, field)
+		if word != "" {
+			terms = append(terms, searchTerm{word: word, prefix: prefix})
+		}
+	}
+	return terms
+}
+
+// fts5Query quotes each term so FTS5 treats it as a plain string
+func fts5Query(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `"` + t.word + `"`
+		if t.prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// postgresQuery builds a to_tsquery expression requiring every term
+func postgresQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = "'" + t.word + "'"
+		if t.prefix {
+			parts[i] += ":*"
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// headlineOptions configures ts_headline to match the SQLite snippets
+func headlineOptions(start, end string) string {
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+	return fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=%d, MinWords=%d",
+		quote(start), quote(end), snippetWords, snippetWords/2)
+}
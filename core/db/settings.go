@@ -0,0 +1,241 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+)
+
+// Setting scopes
+const (
+	ScopeChat = "chat" // a conversation, shared by everyone in it
+	ScopeUser = "user" // a sender, in every chat on the channel
+)
+
+// Setting kinds
+const (
+	KindBool   = "bool"   // stored as true or false, accepts on/off and yes/no
+	KindString = "string" // text up to maxSettingLength characters, matching the setting's pattern if it has one
+	KindChoice = "choice" // one of the setting's choices
+)
+
+// Settings the bot knows
+const (
+	SettingLanguage = "language"
+	SettingPersona  = "persona"
+	SettingMute     = "mute"
+)
+
+// DefaultPersona is the persona using the configured system prompt
+const DefaultPersona = "default"
+
+// maxSettingLength limits free text values
+const maxSettingLength = 100
+
+// languagePattern accepts a language name or code of a few words, such as
+// "German" or "Brazilian Portuguese". The value ends up in the instructions
+// sent to the LLM, so anything that could read as an instruction is refused.
+var languagePattern = regexp.MustCompile(`^\p{L}+(?:[ -]\p{L}+){0,3}$`)
+
+// ErrInvalidSetting is returned for unknown settings and values a setting does not accept
+var ErrInvalidSetting = errors.New("invalid setting")
+
+// SettingDef describes a setting: its type, where it can be set and its default
+type SettingDef struct {
+	Key         string   `json:"key"`
+	Kind        string   `json:"kind"`
+	Scopes      []string `json:"scopes"`
+	Choices     []string `json:"choices,omitempty"`
+	Default     string   `json:"default"`
+	Description string   `json:"description"`
+
+	pattern *regexp.Regexp // non-empty string values must match
+	format  string         // describes the values the pattern accepts
+}
+
+// settingDefs are the known settings with their built-in defaults
+var settingDefs = []SettingDef{
+	{
+		Key:         SettingLanguage,
+		Kind:        KindString,
+		Scopes:      []string{ScopeChat, ScopeUser},
+		Description: "language of the replies, empty to answer in the language of the message",
+		pattern:     languagePattern,
+		format:      "a language name such as German",
+	},
+	{
+		Key:         SettingPersona,
+		Kind:        KindChoice,
+		Scopes:      []string{ScopeChat, ScopeUser},
+		Choices:     personaChoices(),
+		Default:     DefaultPersona,
+		Description: "personality of the assistant",
+	},
+	{
+		Key:         SettingMute,
+		Kind:        KindBool,
+		Scopes:      []string{ScopeChat},
+		Default:     "false",
+		Description: "stop answering messages in the chat",
+	},
+}
+
+// personaChoices lists the default persona and the configured ones
+func personaChoices() []string {
+	choices := make([]string, 0, len(config.Personas)+1)
+	for name := range config.Personas {
+		choices = append(choices, name)
+	}
+	sort.Strings(choices)
+	return append([]string{DefaultPersona}, choices...)
+}
+
+// Allows reports whether the setting can be set in the scope
+func (d SettingDef) Allows(scope string) bool {
+	for _, s := range d.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize validates a value for the setting and returns it in canonical form
+func (d SettingDef) Normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch d.Kind {
+	case KindBool:
+		switch strings.ToLower(value) {
+		case "true", "on", "yes", "1":
+			return "true", nil
+		case "false", "off", "no", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%w: %s must be on or off", ErrInvalidSetting, d.Key)
+	case KindChoice:
+		for _, choice := range d.Choices {
+			if strings.EqualFold(choice, value) {
+				return choice, nil
+			}
+		}
+		return "", fmt.Errorf("%w: %s must be one of %s", ErrInvalidSetting, d.Key, strings.Join(d.Choices, ", "))
+	default:
+		if len([]rune(value)) > maxSettingLength {
+			return "", fmt.Errorf("%w: %s is longer than %d characters", ErrInvalidSetting, d.Key, maxSettingLength)
+		}
+		if value != "" && d.pattern != nil && !d.pattern.MatchString(value) {
+			return "", fmt.Errorf("%w: %s must be %s", ErrInvalidSetting, d.Key, d.format)
+		}
+		return value, nil
+	}
+}
+
+// SettingSubject is the chat or sender settings belong to
+type SettingSubject struct {
+	Scope   string `json:"scope"`
+	Channel string `json:"channel"`
+	ID      string `json:"id"` // external chat or sender ID
+}
+
+// ChatSubject returns the subject of a chat's settings
+func ChatSubject(channel, chatID string) SettingSubject {
+	return SettingSubject{Scope: ScopeChat, Channel: channel, ID: chatID}
+}
+
+// UserSubject returns the subject of a sender's settings
+func UserSubject(channel, senderID string) SettingSubject {
+	return SettingSubject{Scope: ScopeUser, Channel: channel, ID: senderID}
+}
+
+// Validate checks that the subject is complete
+func (s SettingSubject) Validate() error {
+	if s.Scope != ScopeChat && s.Scope != ScopeUser {
+		return fmt.Errorf("%w: unknown scope %q, expected chat or user", ErrInvalidSetting, s.Scope)
+	}
+	if s.Channel == "" || s.ID == "" {
+		return fmt.Errorf("%w: settings need a channel and a %s ID", ErrInvalidSetting, s.Scope)
+	}
+	return nil
+}
+
+// SettingRecord is a stored setting of a chat or sender
+type SettingRecord struct {
+	Subject   SettingSubject `json:"subject"`
+	Key       string         `json:"key"`
+	Value     string         `json:"value"`
+	UpdatedBy string         `json:"updated_by,omitempty"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// settingColumns is the column list read by scanSettings
+const settingColumns = "st.scope, st.channel, st.subject, st.key, st.value, st.updated_by, st.updated_at"
+
+// SetSetting stores a setting of a chat or sender, replacing its earlier
+// value, and fills in UpdatedAt. Values are stored as given; Settings.Set
+// validates them first.
+func (s *SQLStore) SetSetting(ctx context.Context, rec *SettingRecord) error {
+	const query = `
+	INSERT INTO settings (scope, channel, subject, key, value, updated_by)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (scope, channel, subject, key) DO UPDATE SET
+		value = excluded.value, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+	RETURNING updated_at`
+
+	sub := rec.Subject
+	err := s.conn().QueryRowContext(ctx, query, sub.Scope, sub.Channel, sub.ID, rec.Key, rec.Value,
+		nullString(rec.UpdatedBy)).Scan(&rec.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("storing setting %s of %s %s: %w", rec.Key, sub.Scope, sub.ID, err)
+	}
+	return nil
+}
+
+// DeleteSetting removes a setting of a chat or sender so its default applies
+// again, or returns ErrNotFound when it is not set
+func (s *SQLStore) DeleteSetting(ctx context.Context, subject SettingSubject, key string) error {
+	result, err := s.conn().ExecContext(ctx, "DELETE FROM settings WHERE scope = ? AND channel = ? AND subject = ? AND key = ?",
+		subject.Scope, subject.Channel, subject.ID, key)
+	if err != nil {
+		return fmt.Errorf("deleting setting %s of %s %s: %w", key, subject.Scope, subject.ID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("setting %s of %s %s: %w", key, subject.Scope, subject.ID, ErrNotFound)
+	}
+	return nil
+}
+
+// ListSettings returns the stored settings of a chat or sender by key
+func (s *SQLStore) ListSettings(ctx context.Context, subject SettingSubject) ([]SettingRecord, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+settingColumns+
+		" FROM settings st WHERE st.scope = ? AND st.channel = ? AND st.subject = ? ORDER BY st.key",
+		subject.Scope, subject.Channel, subject.ID)
+	if err != nil {
+		return nil, fmt.Errorf("querying settings of %s %s: %w", subject.Scope, subject.ID, err)
+	}
+	defer rows.Close()
+	return scanSettings(rows)
+}
+
+// scanSettings reads rows selected with settingColumns
+func scanSettings(rows *sql.Rows) ([]SettingRecord, error) {
+	var records []SettingRecord
+	for rows.Next() {
+		var rec SettingRecord
+		var updatedBy sql.NullString
+		if err := rows.Scan(&rec.Subject.Scope, &rec.Subject.Channel, &rec.Subject.ID, &rec.Key, &rec.Value,
+			&updatedBy, &rec.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning setting: %w", err)
+		}
+		rec.UpdatedBy = updatedBy.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
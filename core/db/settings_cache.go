@@ -0,0 +1,297 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+)
+
+// maxCachedSubjects bounds the number of chats and senders whose settings are cached
+const maxCachedSubjects = 10000
+
+// SettingsStore is the storage Settings reads and writes
+type SettingsStore interface {
+	SetSetting(ctx context.Context, rec *SettingRecord) error
+	DeleteSetting(ctx context.Context, subject SettingSubject, key string) error
+	ListSettings(ctx context.Context, subject SettingSubject) ([]SettingRecord, error)
+	AppendAudit(ctx context.Context, ev AuditEvent) (*AuditEntry, error)
+	Subjects() SubjectHasher
+}
+
+// Settings validates and resolves chat and sender settings against their
+// defaults. Stored values are cached per subject until they change through
+// Settings or the cache TTL passes, which bounds how long changes made by
+// other processes take to show.
+type Settings struct {
+	store SettingsStore
+	defs  map[string]SettingDef
+	keys  []string // in definition order
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	cache map[SettingSubject]cachedSettings
+	gen   uint64 // bumped on every invalidation so reads racing a write are not cached
+}
+
+// cachedSettings are the stored values of one subject
+type cachedSettings struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// Value is the effective value of a setting and the scope it comes from,
+// empty for the default
+type Value struct {
+	Value string `json:"value"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// Values are effective settings by key
+type Values map[string]Value
+
+// String returns the value of a setting, empty when unknown
+func (v Values) String(key string) string {
+	return v[key].Value
+}
+
+// Bool returns the value of a bool setting
+func (v Values) Bool(key string) bool {
+	return v[key].Value == "true"
+}
+
+// NewSettings creates settings with the given defaults replacing the built-in
+// ones. Stored values are cached for ttl, or read every time when it is 0.
+func NewSettings(store SettingsStore, defaults map[string]string, ttl time.Duration) (*Settings, error) {
+	s := &Settings{store: store, defs: make(map[string]SettingDef), ttl: ttl, cache: make(map[SettingSubject]cachedSettings)}
+	for _, def := range settingDefs {
+		s.defs[def.Key] = def
+		s.keys = append(s.keys, def.Key)
+	}
+	for key, value := range defaults {
+		def, ok := s.defs[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown setting %q", ErrInvalidSetting, key)
+		}
+		value, err := def.Normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		def.Default = value
+		s.defs[key] = def
+	}
+	return s, nil
+}
+
+// SettingsFromConfig creates settings with the defaults in SETTINGS_DEFAULTS,
+// given as key=value entries
+func SettingsFromConfig(cfg *config.Config, store SettingsStore) (*Settings, error) {
+	defaults := make(map[string]string)
+	for _, entry := range cfg.SettingsDefaults {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("setting default %q is not key=value", entry)
+		}
+		defaults[strings.TrimSpace(key)] = value
+	}
+	return NewSettings(store, defaults, cfg.SettingsCacheTTL)
+}
+
+// Defs returns the known settings with their configured defaults
+func (s *Settings) Defs() []SettingDef {
+	defs := make([]SettingDef, len(s.keys))
+	for i, key := range s.keys {
+		defs[i] = s.defs[key]
+	}
+	return defs
+}
+
+// Def returns the definition of a setting
+func (s *Settings) Def(key string) (SettingDef, bool) {
+	def, ok := s.defs[key]
+	return def, ok
+}
+
+// Resolve returns the effective settings of the subjects, applying their
+// stored values over the defaults in order, so later subjects win. A message
+// resolves its chat and then its sender. On a storage error the defaults
+// are returned with the error.
+func (s *Settings) Resolve(ctx context.Context, subjects ...SettingSubject) (Values, error) {
+	values := make(Values)
+	if s == nil {
+		return values, nil
+	}
+	for _, def := range s.defs {
+		values[def.Key] = Value{Value: def.Default}
+	}
+
+	for _, subject := range subjects {
+		stored, err := s.stored(ctx, subject)
+		if err != nil {
+			return values, err
+		}
+		for key, value := range stored {
+			// Values of removed settings, of a scope a setting no longer
+			// allows, or that the setting no longer accepts are ignored
+			def, ok := s.defs[key]
+			if !ok || !def.Allows(subject.Scope) {
+				continue
+			}
+			if value, err := def.Normalize(value); err == nil {
+				values[key] = Value{Value: value, Scope: subject.Scope}
+			}
+		}
+	}
+	return values, nil
+}
+
+// Set validates and stores a setting of a chat or sender and returns the
+// stored record. Invalid settings and values are reported as ErrInvalidSetting.
+// The change is recorded in the audit log as made by updatedBy.
+func (s *Settings) Set(ctx context.Context, subject SettingSubject, key, value, updatedBy string) (*SettingRecord, error) {
+	def, err := s.check(subject, key)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = def.Normalize(value); err != nil {
+		return nil, err
+	}
+	before, err := s.current(ctx, subject, key)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &SettingRecord{Subject: subject, Key: key, Value: value, UpdatedBy: updatedBy}
+	defer s.Invalidate(subject)
+	if err := s.store.SetSetting(ctx, rec); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, updatedBy, AuditSettingSet, subject, key, before, &value)
+	return rec, nil
+}
+
+// Delete removes a setting of a chat or sender so its default applies again.
+// It returns ErrNotFound when the setting is not set. The change is recorded
+// in the audit log as made by deletedBy.
+func (s *Settings) Delete(ctx context.Context, subject SettingSubject, key, deletedBy string) error {
+	if _, err := s.check(subject, key); err != nil {
+		return err
+	}
+	before, err := s.current(ctx, subject, key)
+	if err != nil {
+		return err
+	}
+
+	defer s.Invalidate(subject)
+	if err := s.store.DeleteSetting(ctx, subject, key); err != nil {
+		return err
+	}
+	s.audit(ctx, deletedBy, AuditSettingDelete, subject, key, before, nil)
+	return nil
+}
+
+// current returns the stored value of a setting, nil when it is not set
+func (s *Settings) current(ctx context.Context, subject SettingSubject, key string) (*string, error) {
+	records, err := s.store.ListSettings(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if rec.Key == key {
+			return &rec.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+// audit records a setting change. The change already happened, so a
+// failure is logged rather than returned.
+func (s *Settings) audit(ctx context.Context, actor, action string, subject SettingSubject, key string, before, after *string) {
+	if actor == "" {
+		actor = "unknown"
+	}
+	ev := AuditEvent{Actor: actor, Action: action, Target: s.store.Subjects().SettingTarget(subject, key), Before: before, After: after}
+	if _, err := s.store.AppendAudit(ctx, ev); err != nil {
+		log.Printf("Failed to record %s of %s in the audit log: %v", action, ev.Target, err)
+	}
+}
+
+// Invalidate drops the cached settings of a subject
+func (s *Settings) Invalidate(subject SettingSubject) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, subject)
+	s.gen++
+}
+
+// InvalidateAll drops every cached setting, e.g. after subjects were erased
+func (s *Settings) InvalidateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = make(map[SettingSubject]cachedSettings)
+	s.gen++
+}
+
+// check validates the subject and that the setting exists in its scope
+func (s *Settings) check(subject SettingSubject, key string) (SettingDef, error) {
+	if err := subject.Validate(); err != nil {
+		return SettingDef{}, err
+	}
+	def, ok := s.defs[key]
+	if !ok {
+		return SettingDef{}, fmt.Errorf("%w: unknown setting %q", ErrInvalidSetting, key)
+	}
+	if !def.Allows(subject.Scope) {
+		return SettingDef{}, fmt.Errorf("%w: %s cannot be set per %s", ErrInvalidSetting, key, subject.Scope)
+	}
+	return def, nil
+}
+
+// stored returns the stored values of a subject from the cache or the store
+func (s *Settings) stored(ctx context.Context, subject SettingSubject) (map[string]string, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[subject]
+	gen := s.gen
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.values, nil
+	}
+
+	records, err := s.store.ListSettings(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(records))
+	for _, rec := range records {
+		values[rec.Key] = rec.Value
+	}
+
+	s.mu.Lock()
+	if s.ttl > 0 && s.gen == gen {
+		if len(s.cache) >= maxCachedSubjects {
+			s.evict(now)
+		}
+		s.cache[subject] = cachedSettings{values: values, expires: now.Add(s.ttl)}
+	}
+	s.mu.Unlock()
+	return values, nil
+}
+
+// evict drops expired entries, or the whole cache when none have expired.
+// The caller holds s.mu.
+func (s *Settings) evict(now time.Time) {
+	for subject, cached := range s.cache {
+		if !now.Before(cached.expires) {
+			delete(s.cache, subject)
+		}
+	}
+	if len(s.cache) >= maxCachedSubjects {
+		s.cache = make(map[SettingSubject]cachedSettings)
+	}
+}
@@ -0,0 +1,16 @@
+package db_test
+
+import (
+	"testing"
+
+	"golang-llm-sqlite-bot/core/db/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, storetest.SQLite(storetest.Config()))
+}
+
+// TestSQLiteStoreEncrypted runs the conformance suite with content encrypted at rest
+func TestSQLiteStoreEncrypted(t *testing.T) {
+	storetest.Run(t, storetest.SQLite(storetest.Encrypted(storetest.Config())))
+}
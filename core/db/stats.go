@@ -0,0 +1,504 @@
+// Package db provides database functionality for the LLM bot
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTopChats is the number of chats ranked when StatsQuery.TopChats is 0
+const defaultTopChats = 10
+
+// StatsQuery selects the period and channel usage statistics are computed for
+type StatsQuery struct {
+	From     time.Time // inclusive, zero means since the first record
+	To       time.Time // exclusive, zero means up to now
+	Channel  string    // empty includes all channels
+	TopChats int
+
+	// Prices in USD per million tokens, used for the cost columns
+	PromptPricePerMTok     float64
+	CompletionPricePerMTok float64
+}
+
+func (q StatsQuery) cost(promptTokens, completionTokens int64) float64 {
+	return (float64(promptTokens)*q.PromptPricePerMTok + float64(completionTokens)*q.CompletionPricePerMTok) / 1e6
+}
+
+// where returns the condition selecting the rows of the period and channel,
+// given the time and channel columns of the queried table
+func (q StatsQuery) where(timeCol, channelCol string) (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	var args []interface{}
+	if !q.From.IsZero() {
+		conds = append(conds, timeCol+" >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, timeCol+" < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.Channel != "" {
+		conds = append(conds, channelCol+" = ?")
+		args = append(args, q.Channel)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// UsageStats describes how the bot was used in a period. Days and hours are in UTC.
+type UsageStats struct {
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Channel  string       `json:"channel,omitempty"`
+	Totals   UsageTotals  `json:"totals"`
+	Daily    []DailyUsage `json:"daily"`
+	Hours    []HourUsage  `json:"hours"`
+	TopChats []ChatUsage  `json:"top_chats"`
+	Models   []ModelUsage `json:"models"`
+}
+
+// UsageTotals sums up the whole period
+type UsageTotals struct {
+	Inbound           int     `json:"inbound_messages"`
+	Outbound          int     `json:"outbound_messages"`
+	ActiveUsers       int     `json:"active_users"`
+	Chats             int     `json:"chats"`
+	Interactions      int     `json:"interactions"`
+	AvgLatencyMS      float64 `json:"avg_latency_ms"`
+	PromptTokens      int64   `json:"prompt_tokens"`
+	CompletionTokens  int64   `json:"completion_tokens"`
+	CostUSD           float64 `json:"cost_usd"`
+	LLMFailures       int     `json:"llm_failures"`
+	DeliveryFailures  int     `json:"delivery_failures"`
+	LLMErrorRate      float64 `json:"llm_error_rate"`      // failed LLM calls per LLM call
+	DeliveryErrorRate float64 `json:"delivery_error_rate"` // undelivered replies per reply
+	PositiveFeedback  int     `json:"positive_feedback"`
+	NegativeFeedback  int     `json:"negative_feedback"`
+}
+
+// DailyUsage is the usage of one UTC day
+type DailyUsage struct {
+	Day              string  `json:"day"` // YYYY-MM-DD
+	Inbound          int     `json:"inbound_messages"`
+	Outbound         int     `json:"outbound_messages"`
+	ActiveUsers      int     `json:"active_users"`
+	Interactions     int     `json:"interactions"`
+	AvgLatencyMS     float64 `json:"avg_latency_ms"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	LLMFailures      int     `json:"llm_failures"`
+	DeliveryFailures int     `json:"delivery_failures"`
+	PositiveFeedback int     `json:"positive_feedback"`
+	NegativeFeedback int     `json:"negative_feedback"`
+}
+
+// HourUsage counts the messages received in one UTC hour of the day over the period
+type HourUsage struct {
+	Hour    int `json:"hour"`
+	Inbound int `json:"inbound_messages"`
+}
+
+// ChatUsage is the activity of one chat in the period
+type ChatUsage struct {
+	Channel       string    `json:"channel"`
+	ExternalID    string    `json:"external_id"`
+	Title         string    `json:"title,omitempty"`
+	IsGroup       bool      `json:"is_group"`
+	Messages      int       `json:"messages"`
+	ActiveUsers   int       `json:"active_users"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// ModelUsage is the LLM usage of one model in the period
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	Interactions     int     `json:"interactions"`
+	AvgLatencyMS     float64 `json:"avg_latency_ms"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	PositiveFeedback int     `json:"positive_feedback"`
+	NegativeFeedback int     `json:"negative_feedback"`
+}
+
+// messageCounts, interactionCounts, failureCounts and feedbackCounts are the
+// aggregates of one group of the respective table
+type messageCounts struct {
+	inbound, outbound, users, chats int
+}
+
+type interactionCounts struct {
+	interactions                   int
+	latency                        float64
+	promptTokens, completionTokens int64
+}
+
+type failureCounts struct {
+	llm, delivery int
+}
+
+type feedbackCounts struct {
+	positive, negative int
+}
+
+// messagesSource joins messages to the channel of their chat
+const messagesSource = `messages m
+	JOIN chats c ON c.id = m.chat_id
+	JOIN channels ch ON ch.id = c.channel_id`
+
+// UsageStats reports message volume, active users, top chats, latency, token
+// usage and cost, failure rates, feedback and busiest hours for a period
+func (s *SQLStore) UsageStats(ctx context.Context, q StatsQuery) (*UsageStats, error) {
+	stats := &UsageStats{From: q.From, To: q.To, Channel: q.Channel}
+
+	messages, err := s.messageCounts(ctx, q, "")
+	if err != nil {
+		return nil, err
+	}
+	interactions, err := s.interactionCounts(ctx, q, "")
+	if err != nil {
+		return nil, err
+	}
+	failures, err := s.failureCounts(ctx, q, "")
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := s.feedbackCounts(ctx, q, "")
+	if err != nil {
+		return nil, err
+	}
+	m, i, f, fb := messages[""], interactions[""], failures[""], feedback[""]
+	stats.Totals = UsageTotals{
+		Inbound:          m.inbound,
+		Outbound:         m.outbound,
+		ActiveUsers:      m.users,
+		Chats:            m.chats,
+		Interactions:     i.interactions,
+		AvgLatencyMS:     i.latency,
+		PromptTokens:     i.promptTokens,
+		CompletionTokens: i.completionTokens,
+		CostUSD:          q.cost(i.promptTokens, i.completionTokens),
+		LLMFailures:      f.llm,
+		DeliveryFailures: f.delivery,
+		PositiveFeedback: fb.positive,
+		NegativeFeedback: fb.negative,
+	}
+	if calls := i.interactions + f.llm; calls > 0 {
+		stats.Totals.LLMErrorRate = float64(f.llm) / float64(calls)
+	}
+	if i.interactions > 0 {
+		stats.Totals.DeliveryErrorRate = float64(f.delivery) / float64(i.interactions)
+	}
+
+	if stats.Daily, err = s.dailyUsage(ctx, q); err != nil {
+		return nil, err
+	}
+	if stats.Hours, err = s.hourUsage(ctx, q); err != nil {
+		return nil, err
+	}
+	if stats.TopChats, err = s.topChats(ctx, q); err != nil {
+		return nil, err
+	}
+	if stats.Models, err = s.modelUsage(ctx, q); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// dailyUsage merges the per-day aggregates of the three tables. Quiet days
+// between the first and the last active day are included with zero counts.
+func (s *SQLStore) dailyUsage(ctx context.Context, q StatsQuery) ([]DailyUsage, error) {
+	messages, err := s.messageCounts(ctx, q, utcDay(s.driver, "m.sent_at"))
+	if err != nil {
+		return nil, err
+	}
+	interactions, err := s.interactionCounts(ctx, q, utcDay(s.driver, "i.timestamp"))
+	if err != nil {
+		return nil, err
+	}
+	failures, err := s.failureCounts(ctx, q, utcDay(s.driver, "f.created_at"))
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := s.feedbackCounts(ctx, q, utcDay(s.driver, "fb.created_at"))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for day := range messages {
+		days = append(days, day)
+	}
+	for day := range interactions {
+		days = append(days, day)
+	}
+	for day := range failures {
+		days = append(days, day)
+	}
+	for day := range feedback {
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, nil
+	}
+	sort.Strings(days)
+
+	first, err := time.Parse(time.DateOnly, days[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing day %q: %w", days[0], err)
+	}
+	last, err := time.Parse(time.DateOnly, days[len(days)-1])
+	if err != nil {
+		return nil, fmt.Errorf("parsing day %q: %w", days[len(days)-1], err)
+	}
+	var daily []DailyUsage
+	for t := first; !t.After(last); t = t.AddDate(0, 0, 1) {
+		day := t.Format(time.DateOnly)
+		m, i, f, fb := messages[day], interactions[day], failures[day], feedback[day]
+		daily = append(daily, DailyUsage{
+			Day:              day,
+			Inbound:          m.inbound,
+			Outbound:         m.outbound,
+			ActiveUsers:      m.users,
+			Interactions:     i.interactions,
+			AvgLatencyMS:     i.latency,
+			PromptTokens:     i.promptTokens,
+			CompletionTokens: i.completionTokens,
+			CostUSD:          q.cost(i.promptTokens, i.completionTokens),
+			LLMFailures:      f.llm,
+			DeliveryFailures: f.delivery,
+			PositiveFeedback: fb.positive,
+			NegativeFeedback: fb.negative,
+		})
+	}
+	return daily, nil
+}
+
+// hourUsage counts the received messages per UTC hour of the day
+func (s *SQLStore) hourUsage(ctx context.Context, q StatsQuery) ([]HourUsage, error) {
+	messages, err := s.messageCounts(ctx, q, utcHour(s.driver, "m.sent_at"))
+	if err != nil {
+		return nil, err
+	}
+
+	hours := make([]HourUsage, 24)
+	for hour := range hours {
+		hours[hour].Hour = hour
+	}
+	for key, counts := range messages {
+		hour, err := strconv.Atoi(key)
+		if err != nil || hour < 0 || hour > 23 {
+			return nil, fmt.Errorf("unexpected hour %q", key)
+		}
+		hours[hour].Inbound = counts.inbound
+	}
+	return hours, nil
+}
+
+// topChats ranks the chats by the number of messages in the period
+func (s *SQLStore) topChats(ctx context.Context, q StatsQuery) ([]ChatUsage, error) {
+	limit := q.TopChats
+	if limit <= 0 {
+		limit = defaultTopChats
+	}
+
+	where, args := q.where("m.sent_at", "ch.name")
+	query := `
+	SELECT ch.name, c.external_id, c.title, c.is_group, c.last_message_at,
+		COUNT(*), COUNT(DISTINCT CASE WHEN m.role = ? THEN m.participant_id END)
+	FROM ` + messagesSource + `
+	WHERE ` + where + `
+	GROUP BY c.id, ch.name, c.external_id, c.title, c.is_group, c.last_message_at
+	ORDER BY COUNT(*) DESC, c.id
+	LIMIT ?`
+	args = append([]interface{}{RoleUser}, append(args, limit)...)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying top chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []ChatUsage
+	for rows.Next() {
+		var (
+			c        ChatUsage
+			title    sql.NullString
+			lastSeen sql.NullTime
+		)
+		if err := rows.Scan(&c.Channel, &c.ExternalID, &title, &c.IsGroup, &lastSeen, &c.Messages, &c.ActiveUsers); err != nil {
+			return nil, fmt.Errorf("scanning chat usage: %w", err)
+		}
+		c.Title = title.String
+		c.LastMessageAt = lastSeen.Time
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+// modelUsage aggregates the interactions of the period per model, most used first
+func (s *SQLStore) modelUsage(ctx context.Context, q StatsQuery) ([]ModelUsage, error) {
+	interactions, err := s.interactionCounts(ctx, q, "COALESCE(i.model, '')")
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := s.feedbackCounts(ctx, q, "COALESCE(i.model, '')")
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]ModelUsage, 0, len(interactions))
+	for model, i := range interactions {
+		fb := feedback[model]
+		models = append(models, ModelUsage{
+			Model:            model,
+			Interactions:     i.interactions,
+			AvgLatencyMS:     i.latency,
+			PromptTokens:     i.promptTokens,
+			CompletionTokens: i.completionTokens,
+			CostUSD:          q.cost(i.promptTokens, i.completionTokens),
+			PositiveFeedback: fb.positive,
+			NegativeFeedback: fb.negative,
+		})
+	}
+	sort.Slice(models, func(a, b int) bool {
+		if models[a].Interactions != models[b].Interactions {
+			return models[a].Interactions > models[b].Interactions
+		}
+		return models[a].Model < models[b].Model
+	})
+	return models, nil
+}
+
+// groupedQuery selects key as the first column and groups by it. An empty
+// key aggregates the whole table into a single group named "".
+func groupedQuery(key, columns, source, where string) string {
+	if key == "" {
+		return "SELECT '', " + columns + " FROM " + source + " WHERE " + where
+	}
+	return "SELECT " + key + ", " + columns + " FROM " + source + " WHERE " + where + " GROUP BY " + key
+}
+
+// messageCounts aggregates the messages of the period, grouped by key
+func (s *SQLStore) messageCounts(ctx context.Context, q StatsQuery, key string) (map[string]messageCounts, error) {
+	where, args := q.where("m.sent_at", "ch.name")
+	query := groupedQuery(key, `
+		COALESCE(SUM(CASE WHEN m.role = ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN m.role = ? THEN 1 ELSE 0 END), 0),
+		COUNT(DISTINCT CASE WHEN m.role = ? THEN m.participant_id END),
+		COUNT(DISTINCT m.chat_id)`, messagesSource, where)
+	args = append([]interface{}{RoleUser, RoleAssistant, RoleUser}, args...)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying message stats: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]messageCounts)
+	for rows.Next() {
+		var (
+			group string
+			c     messageCounts
+		)
+		if err := rows.Scan(&group, &c.inbound, &c.outbound, &c.users, &c.chats); err != nil {
+			return nil, fmt.Errorf("scanning message stats: %w", err)
+		}
+		counts[group] = c
+	}
+	return counts, rows.Err()
+}
+
+// interactionCounts aggregates the interactions of the period, grouped by key
+func (s *SQLStore) interactionCounts(ctx context.Context, q StatsQuery, key string) (map[string]interactionCounts, error) {
+	where, args := q.where("i.timestamp", "i.channel")
+	query := groupedQuery(key, `
+		COUNT(*), AVG(i.latency_ms),
+		COALESCE(SUM(i.prompt_tokens), 0), COALESCE(SUM(i.completion_tokens), 0)`,
+		"interactions i", where)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying interaction stats: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]interactionCounts)
+	for rows.Next() {
+		var (
+			group   string
+			c       interactionCounts
+			latency sql.NullFloat64
+		)
+		if err := rows.Scan(&group, &c.interactions, &latency, &c.promptTokens, &c.completionTokens); err != nil {
+			return nil, fmt.Errorf("scanning interaction stats: %w", err)
+		}
+		c.latency = latency.Float64
+		counts[group] = c
+	}
+	return counts, rows.Err()
+}
+
+// failureCounts counts the failures of the period per stage, grouped by key
+func (s *SQLStore) failureCounts(ctx context.Context, q StatsQuery, key string) (map[string]failureCounts, error) {
+	where, args := q.where("f.created_at", "f.channel")
+	query := groupedQuery(key, `
+		COALESCE(SUM(CASE WHEN f.stage = ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN f.stage = ? THEN 1 ELSE 0 END), 0)`,
+		"failures f", where)
+	args = append([]interface{}{FailureLLM, FailureDelivery}, args...)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying failure stats: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]failureCounts)
+	for rows.Next() {
+		var (
+			group string
+			c     failureCounts
+		)
+		if err := rows.Scan(&group, &c.llm, &c.delivery); err != nil {
+			return nil, fmt.Errorf("scanning failure stats: %w", err)
+		}
+		counts[group] = c
+	}
+	return counts, rows.Err()
+}
+
+// feedbackCounts counts the ratings given in the period, grouped by key. The
+// channel and model are those of the rated interaction i.
+func (s *SQLStore) feedbackCounts(ctx context.Context, q StatsQuery, key string) (map[string]feedbackCounts, error) {
+	where, args := q.where("fb.created_at", "i.channel")
+	query := groupedQuery(key, `
+		COALESCE(SUM(CASE WHEN fb.rating > 0 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN fb.rating < 0 THEN 1 ELSE 0 END), 0)`,
+		"feedback fb JOIN interactions i ON i.id = fb.interaction_id", where)
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying feedback stats: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]feedbackCounts)
+	for rows.Next() {
+		var (
+			group string
+			c     feedbackCounts
+		)
+		if err := rows.Scan(&group, &c.positive, &c.negative); err != nil {
+			return nil, fmt.Errorf("scanning feedback stats: %w", err)
+		}
+		counts[group] = c
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,109 @@
+package storetest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// schemaSeq keeps Postgres schema names unique within a test binary
+var schemaSeq atomic.Int64
+
+// Config returns the configuration the suite runs with. It is built
+// explicitly rather than from the environment, so DB_*, ENCRYPTION_* and .env
+// values on the machine running the tests don't change what is tested.
+func Config() *config.Config {
+	return &config.Config{
+		MaxOpenConns:     4,
+		MaxIdleConns:     4,
+		ConnMaxLifetime:  5 * time.Minute,
+		SettingsCacheTTL: time.Minute,
+		SubjectHashKey:   "storetest",
+	}
+}
+
+// SQLite returns a factory for SQLite stores in fresh temporary files
+func SQLite(base *config.Config) Factory {
+	return func(t *testing.T) db.Store {
+		cfg := *base
+		cfg.DBDriver = db.DriverSQLite
+		cfg.DBPath = filepath.Join(t.TempDir(), "store.db")
+
+		store, err := db.NewSQLiteStore(&cfg)
+		if err != nil {
+			t.Fatalf("opening SQLite store: %v", err)
+		}
+		return store
+	}
+}
+
+// Postgres returns a factory for Postgres stores, each in its own schema of
+// the database at TEST_POSTGRES_DSN. The schema is dropped when the test ends.
+func Postgres(base *config.Config) Factory {
+	return func(t *testing.T) db.Store {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("TEST_POSTGRES_DSN is not set")
+		}
+
+		admin, err := sql.Open("pgx", dsn)
+		if err != nil {
+			t.Fatalf("opening Postgres: %v", err)
+		}
+		t.Cleanup(func() { admin.Close() })
+
+		schema := fmt.Sprintf("storetest_%d_%d", time.Now().UnixNano(), schemaSeq.Add(1))
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("creating schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("dropping schema %s: %v", schema, err)
+			}
+		})
+
+		cfg := *base
+		cfg.DBDriver = db.DriverPostgres
+		cfg.DBDSN = withSearchPath(dsn, schema)
+
+		store, err := db.NewPostgresStore(&cfg)
+		if err != nil {
+			t.Fatalf("opening Postgres store: %v", err)
+		}
+		return store
+	}
+}
+
+// testKey is the encryption key of stores created from Encrypted configs
+const testKey = "storetest:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+// Encrypted returns a copy of base whose stores encrypt content at rest with
+// a fixed test key, to run the suite with encryption on
+func Encrypted(base *config.Config) *config.Config {
+	cfg := *base
+	cfg.EncryptionKeys = []string{testKey}
+	cfg.EncryptionKeyFile = ""
+	cfg.EncryptionActiveKey = ""
+	return &cfg
+}
+
+// withSearchPath points every connection of dsn at the given schema. Both URL
+// and keyword/value connection strings are supported.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
@@ -0,0 +1,225 @@
+// Package embedding turns interactions into vectors for similarity search
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// Embedding providers
+const (
+	ProviderLocal  = "local"  // deterministic hashing embedder, no service needed
+	ProviderOpenAI = "openai" // any OpenAI-compatible /embeddings endpoint
+)
+
+// maxTextLength is the number of characters of an interaction that are
+// embedded, which keeps long exchanges within the model's input limit
+const maxTextLength = 8000
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	// Model names the embedding model. Vectors of different models are
+	// never compared.
+	Model() string
+	// Embed returns one vector per text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderFromConfig creates the embedder selected by EMBEDDINGS_PROVIDER,
+// or returns nil when none is set
+func EmbedderFromConfig(cfg *config.Config) (Embedder, error) {
+	switch cfg.EmbeddingsProvider {
+	case "":
+		return nil, nil
+	case ProviderLocal:
+		return NewLocal(cfg.EmbeddingsDimensions), nil
+	case ProviderOpenAI:
+		return NewOpenAI(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q, expected local or openai", cfg.EmbeddingsProvider)
+	}
+}
+
+// Text returns the text of an interaction that is embedded
+func Text(rec *db.InteractionRecord) string {
+	text := []rune(rec.Prompt + "\n\n" + rec.Response)
+	if len(text) > maxTextLength {
+		text = text[:maxTextLength]
+	}
+	return string(text)
+}
+
+// maxAttempts is how often embedding an interaction may fail on its own
+// before the populator stops trying it until restarted
+const maxAttempts = 3
+
+// Store is the storage the populator reads interactions from and writes embeddings to
+type Store interface {
+	InteractionsWithoutEmbedding(ctx context.Context, model string, afterID int64, limit int) ([]db.InteractionRecord, error)
+	SaveEmbeddings(ctx context.Context, embeddings []db.Embedding) error
+}
+
+// Populator embeds the interactions that have no embedding yet. It is not
+// safe for concurrent use.
+type Populator struct {
+	store     Store
+	embedder  Embedder
+	batchSize int
+	failures  map[int64]int // failed attempts by interaction
+}
+
+// NewPopulator creates a populator embedding batchSize interactions per request
+func NewPopulator(store Store, embedder Embedder, batchSize int) *Populator {
+	if batchSize < 1 {
+		batchSize = 32
+	}
+	return &Populator{store: store, embedder: embedder, batchSize: batchSize, failures: make(map[int64]int)}
+}
+
+// FromConfig creates a populator from the EMBEDDINGS_* settings, or returns
+// nil when embeddings are off or EMBEDDINGS_INTERVAL is not positive
+func FromConfig(cfg *config.Config, store Store) (*Populator, error) {
+	if cfg.EmbeddingsInterval <= 0 {
+		return nil, nil
+	}
+	embedder, err := EmbedderFromConfig(cfg)
+	if err != nil || embedder == nil {
+		return nil, err
+	}
+	return NewPopulator(store, embedder, cfg.EmbeddingsBatchSize), nil
+}
+
+// Embedder returns the embedder the populator uses
+func (p *Populator) Embedder() Embedder {
+	return p.embedder
+}
+
+// Run embeds the pending interactions once immediately and then at every
+// interval until ctx is cancelled
+func (p *Populator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.EmbedPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Embedding interactions failed: %v", err)
+		}
+		if n > 0 {
+			log.Printf("Embedded %d interactions with %s", n, p.embedder.Model())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EmbedPending embeds every interaction without an embedding from the
+// model, one batch at a time, and returns the number embedded. When a batch
+// fails its interactions are embedded one by one, so a single interaction
+// the embedder rejects cannot hold back the rest; it is skipped and retried
+// on later passes until it failed maxAttempts times. When nothing can be
+// embedded, the embedder is likely unavailable and the pass stops.
+func (p *Populator) EmbedPending(ctx context.Context) (int, error) {
+	model := p.embedder.Model()
+	embedded := 0
+	var afterID int64
+	for {
+		records, err := p.store.InteractionsWithoutEmbedding(ctx, model, afterID, p.batchSize)
+		if err != nil || len(records) == 0 {
+			return embedded, err
+		}
+		afterID = records[len(records)-1].ID
+
+		pending := records[:0]
+		for _, rec := range records {
+			if p.failures[rec.ID] < maxAttempts {
+				pending = append(pending, rec)
+			}
+		}
+
+		embeddings, err := p.embed(ctx, model, pending)
+		if err != nil {
+			var failed map[int64]error
+			embeddings, failed = p.embedEach(ctx, model, pending)
+			if len(embeddings) == 0 && embedded == 0 {
+				return embedded, err
+			}
+			p.skip(failed)
+		}
+		if len(embeddings) > 0 {
+			if err := p.store.SaveEmbeddings(ctx, embeddings); err != nil {
+				return embedded, err
+			}
+			embedded += len(embeddings)
+		}
+
+		if len(records) < p.batchSize {
+			return embedded, nil
+		}
+	}
+}
+
+// embed embeds the interactions in one request
+func (p *Populator) embed(ctx context.Context, model string, records []db.InteractionRecord) ([]db.Embedding, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	texts := make([]string, len(records))
+	for i := range records {
+		texts[i] = Text(&records[i])
+	}
+	vectors, err := p.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("embedding interactions %d to %d: %w", records[0].ID, records[len(records)-1].ID, err)
+	}
+	if len(vectors) != len(records) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(records))
+	}
+
+	embeddings := make([]db.Embedding, len(records))
+	for i, rec := range records {
+		embeddings[i] = db.Embedding{InteractionID: rec.ID, Model: model, Vector: vectors[i]}
+	}
+	return embeddings, nil
+}
+
+// embedEach embeds the interactions one request each and returns the
+// embeddings and the errors of the interactions that failed, by ID
+func (p *Populator) embedEach(ctx context.Context, model string, records []db.InteractionRecord) ([]db.Embedding, map[int64]error) {
+	var embeddings []db.Embedding
+	failed := make(map[int64]error)
+	for _, rec := range records {
+		if ctx.Err() != nil {
+			return embeddings, nil
+		}
+		single, err := p.embed(ctx, model, []db.InteractionRecord{rec})
+		if err != nil {
+			failed[rec.ID] = err
+			continue
+		}
+		embeddings = append(embeddings, single...)
+	}
+	return embeddings, failed
+}
+
+// skip counts a failed attempt of each interaction the embedder rejected
+// while it embedded others
+func (p *Populator) skip(failed map[int64]error) {
+	for id, err := range failed {
+		p.failures[id]++
+		if p.failures[id] >= maxAttempts {
+			log.Printf("Giving up embedding interaction %d after %d attempts: %v", id, maxAttempts, err)
+		} else {
+			log.Printf("Skipping interaction %d that could not be embedded: %v", id, err)
+		}
+	}
+}
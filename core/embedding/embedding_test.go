@@ -0,0 +1,175 @@
+package embedding_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/db/storetest"
+	"golang-llm-sqlite-bot/core/embedding"
+)
+
+// memoryStore keeps interactions and their embeddings in memory
+type memoryStore struct {
+	records []db.InteractionRecord
+	saved   map[int64][]float32
+}
+
+func newMemoryStore(prompts ...string) *memoryStore {
+	s := &memoryStore{saved: make(map[int64][]float32)}
+	for _, prompt := range prompts {
+		s.add(prompt)
+	}
+	return s
+}
+
+func (s *memoryStore) add(prompt string) {
+	s.records = append(s.records, db.InteractionRecord{ID: int64(len(s.records) + 1), Prompt: prompt, Response: "ok"})
+}
+
+func (s *memoryStore) InteractionsWithoutEmbedding(ctx context.Context, model string, afterID int64, limit int) ([]db.InteractionRecord, error) {
+	var records []db.InteractionRecord
+	for _, rec := range s.records {
+		if _, ok := s.saved[rec.ID]; !ok && rec.ID > afterID && len(records) < limit {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+func (s *memoryStore) SaveEmbeddings(ctx context.Context, embeddings []db.Embedding) error {
+	for _, e := range embeddings {
+		s.saved[e.InteractionID] = e.Vector
+	}
+	return nil
+}
+
+// flakyEmbedder rejects every request containing a poisoned text, or every
+// request while it is down
+type flakyEmbedder struct {
+	*embedding.Local
+	down     bool
+	poisoned int // requests that contained a poisoned text
+}
+
+func (e *flakyEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.down {
+		return nil, errors.New("service unavailable")
+	}
+	for _, text := range texts {
+		if strings.Contains(text, "poison") {
+			e.poisoned++
+			return nil, errors.New("input rejected")
+		}
+	}
+	return e.Local.Embed(ctx, texts)
+}
+
+func TestEmbedPendingSkipsFailures(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore("one", "poison", "three", "four", "five")
+	embedder := &flakyEmbedder{Local: embedding.NewLocal(16)}
+	p := embedding.NewPopulator(store, embedder, 2)
+
+	n, err := p.EmbedPending(ctx)
+	if err != nil {
+		t.Fatalf("EmbedPending: %v", err)
+	}
+	if n != 4 || len(store.saved) != 4 {
+		t.Errorf("embedded %d interactions, saved %d, want 4 each", n, len(store.saved))
+	}
+	if _, ok := store.saved[2]; ok {
+		t.Error("the rejected interaction was saved")
+	}
+
+	// Each pass retries the rejected interaction until it failed often enough
+	for pass := 0; pass < 4; pass++ {
+		store.add(fmt.Sprintf("new %d", pass))
+		if _, err := p.EmbedPending(ctx); err != nil {
+			t.Fatalf("EmbedPending pass %d: %v", pass, err)
+		}
+	}
+	if len(store.saved) != 8 {
+		t.Errorf("saved %d interactions, want every one but the rejected", len(store.saved))
+	}
+	// One batch and one single request per attempt
+	if embedder.poisoned != 6 {
+		t.Errorf("the rejected interaction was sent %d times, want 6", embedder.poisoned)
+	}
+}
+
+func TestEmbedPendingEmbedderDown(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore("one", "two", "three")
+	embedder := &flakyEmbedder{Local: embedding.NewLocal(16), down: true}
+	p := embedding.NewPopulator(store, embedder, 2)
+
+	if n, err := p.EmbedPending(ctx); err == nil || n != 0 {
+		t.Fatalf("EmbedPending with the embedder down = %d, %v, want an error", n, err)
+	}
+
+	// Failures while nothing could be embedded do not count against the interactions
+	for pass := 0; pass < 3; pass++ {
+		p.EmbedPending(ctx)
+	}
+	embedder.down = false
+	n, err := p.EmbedPending(ctx)
+	if err != nil {
+		t.Fatalf("EmbedPending: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("embedded %d interactions once the embedder is back, want 3", n)
+	}
+}
+
+func TestSimilarInteractions(t *testing.T) {
+	ctx := context.Background()
+	cfg := storetest.Config()
+	cfg.DBDriver = db.DriverSQLite
+	cfg.DBPath = filepath.Join(t.TempDir(), "similar.db")
+	store, err := db.NewSQLiteStore(cfg)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	defer store.Close()
+
+	prompts := []string{"How do I get a refund for my order?", "What's the weather in Berlin?", "Refund my order please", "Tell me a joke"}
+	ids := make(map[string]int64)
+	for _, prompt := range prompts {
+		rec := &db.InteractionRecord{Prompt: prompt, Response: "ok", SystemPrompt: "test"}
+		if err := store.LogInteraction(ctx, rec); err != nil {
+			t.Fatalf("LogInteraction: %v", err)
+		}
+		ids[prompt] = rec.ID
+	}
+
+	local := embedding.NewLocal(0)
+	n, err := embedding.NewPopulator(store, local, 3).EmbedPending(ctx)
+	if err != nil {
+		t.Fatalf("EmbedPending: %v", err)
+	}
+	if n != len(prompts) {
+		t.Fatalf("embedded %d interactions, want %d", n, len(prompts))
+	}
+
+	vector, err := store.GetEmbedding(ctx, ids[prompts[0]], local.Model())
+	if err != nil {
+		t.Fatalf("GetEmbedding: %v", err)
+	}
+	results, err := store.SimilarInteractions(ctx, db.SimilarQuery{
+		Model:     local.Model(),
+		Vector:    vector,
+		ExcludeID: ids[prompts[0]],
+		Limit:     2,
+	})
+	if err != nil {
+		t.Fatalf("SimilarInteractions: %v", err)
+	}
+	if len(results) != 2 || results[0].Interaction.Prompt != "Refund my order please" || results[0].Score <= results[1].Score {
+		t.Errorf("similar interactions = %+v, want the other refund request first", results)
+	}
+}
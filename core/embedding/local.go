@@ -0,0 +1,84 @@
+// Package embedding turns interactions into vectors for similarity search
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// defaultLocalDimensions is the vector size of the local embedder when none is set
+const defaultLocalDimensions = 256
+
+// Local is a deterministic embedder that needs no service. It hashes the
+// words of a text and their character trigrams into a fixed number of
+// dimensions, so texts sharing vocabulary end up close. It does not
+// understand meaning and suits tests and offline use.
+type Local struct {
+	dims int
+}
+
+// NewLocal creates a local embedder with the given vector size, 256 when not positive
+func NewLocal(dims int) *Local {
+	if dims <= 0 {
+		dims = defaultLocalDimensions
+	}
+	return &Local{dims: dims}
+}
+
+// Model names the embedder by its vector size, since vectors of different sizes are not comparable
+func (l *Local) Model() string {
+	return fmt.Sprintf("local-hash-%d", l.dims)
+}
+
+// Embed returns a unit vector per text; text without words gets a zero vector
+func (l *Local) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = l.embed(text)
+	}
+	return vectors, nil
+}
+
+func (l *Local) embed(text string) []float32 {
+	vector := make([]float64, l.dims)
+	add := func(feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks the sign so colliding features tend to cancel out
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vector[sum%uint64(l.dims)] += weight
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		add("w:"+word, 1)
+		// Trigrams let inflections of a word share part of their weight
+		padded := []rune("^" + word + "$")
+		for i := 0; i+3 <= len(padded); i++ {
+			add("t:"+string(padded[i:i+3]), 0.5)
+		}
+	}
+
+	var sum float64
+	for _, v := range vector {
+		sum += v * v
+	}
+	result := make([]float32, l.dims)
+	if sum == 0 {
+		return result
+	}
+	length := math.Sqrt(sum)
+	for i, v := range vector {
+		result[i] = float32(v / length)
+	}
+	return result
+}
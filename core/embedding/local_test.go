@@ -0,0 +1,73 @@
+package embedding_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"golang-llm-sqlite-bot/core/embedding"
+)
+
+// cosine returns the cosine similarity of two vectors of the same size
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func TestLocalEmbed(t *testing.T) {
+	local := embedding.NewLocal(64)
+	if local.Model() != "local-hash-64" {
+		t.Errorf("Model = %q, want local-hash-64", local.Model())
+	}
+	if embedding.NewLocal(0).Model() != "local-hash-256" {
+		t.Errorf("default Model = %q, want local-hash-256", embedding.NewLocal(0).Model())
+	}
+
+	texts := []string{"How do I get a refund?", "how do i get a REFUND", "Refunds take a week", "What's the weather in Berlin?", "?!"}
+	vectors, err := local.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != len(texts) {
+		t.Fatalf("got %d vectors for %d texts", len(vectors), len(texts))
+	}
+	for i, v := range vectors[:4] {
+		if len(v) != 64 {
+			t.Fatalf("vector %d has %d dimensions, want 64", i, len(v))
+		}
+		if got := cosine(v, v); math.Abs(got-1) > 1e-5 {
+			t.Errorf("vector %d is not a unit vector", i)
+		}
+	}
+	for _, x := range vectors[4] {
+		if x != 0 {
+			t.Fatalf("text without words got %v, want a zero vector", vectors[4])
+		}
+	}
+
+	// Case and punctuation do not matter, shared words and inflections do
+	if got := cosine(vectors[0], vectors[1]); math.Abs(got-1) > 1e-5 {
+		t.Errorf("similarity of the same words = %f, want 1", got)
+	}
+	if related, unrelated := cosine(vectors[0], vectors[2]), cosine(vectors[0], vectors[3]); related <= unrelated {
+		t.Errorf("similarity to a related text %f, want more than to an unrelated one %f", related, unrelated)
+	}
+
+	again, err := local.Embed(context.Background(), texts[:1])
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	for i := range again[0] {
+		if again[0][i] != vectors[0][i] {
+			t.Fatal("embedding the same text twice gave different vectors")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package embedding turns interactions into vectors for similarity search
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// OpenAI calls an OpenAI-compatible /embeddings endpoint, such as the ones
+// of OpenAI, Ollama or vLLM
+type OpenAI struct {
+	client *resty.Client
+	url    string
+	model  string
+	dims   int
+}
+
+// embeddingsResponse is the body returned by the endpoint
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// NewOpenAI creates a client for the configured endpoint with retry middleware
+func NewOpenAI(cfg *config.Config) *OpenAI {
+	client := resty.New().
+		SetRetryCount(3).
+		SetRetryWaitTime(1 * time.Second).
+		SetRetryMaxWaitTime(5 * time.Second).
+		SetTimeout(cfg.RequestTimeout).
+		AddRetryCondition(func(r *resty.Response, err error) bool {
+			return err != nil || r.StatusCode() >= 500 || r.StatusCode() == 429
+		})
+	if cfg.EmbeddingsAPIKey != "" {
+		client.SetHeader("Authorization", fmt.Sprintf("Bearer %s", cfg.EmbeddingsAPIKey))
+	}
+
+	return &OpenAI{
+		client: client,
+		url:    cfg.EmbeddingsURL,
+		model:  cfg.EmbeddingsModel,
+		dims:   cfg.EmbeddingsDimensions,
+	}
+}
+
+// Model names the model, with the requested size when one is set
+func (o *OpenAI) Model() string {
+	if o.dims > 0 {
+		return fmt.Sprintf("%s-%d", o.model, o.dims)
+	}
+	return o.model
+}
+
+// Embed sends all texts in one request
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := map[string]interface{}{
+		"model": o.model,
+		"input": texts,
+	}
+	if o.dims > 0 {
+		body["dimensions"] = o.dims
+	}
+
+	var result embeddingsResponse
+	resp, err := o.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		SetResult(&result).
+		Post(o.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request embeddings: %w", err)
+	}
+	if !resp.IsSuccess() {
+		return nil, fmt.Errorf("embeddings request failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding returned for unknown input %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	return vectors, nil
+}
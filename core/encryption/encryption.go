@@ -0,0 +1,221 @@
+// Package encryption provides envelope encryption for stored message content
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/config"
+)
+
+// KeySize is the length of master keys and data keys (AES-256)
+const KeySize = 32
+
+// sealedVersion is the first byte of every sealed value
+const sealedVersion byte = 1
+
+// Keyring holds the master keys by ID. New values are sealed with the active
+// key; the others stay available to open values sealed before a rotation.
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	order  []string
+	active string
+}
+
+// NewKeyring creates a keyring from master keys given as id:base64 entries.
+// The active key seals new values; empty selects the last entry.
+func NewKeyring(entries []string, active string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, errors.New("encryption key entry must be id:base64key")
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %s is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding encryption key %s: %w", id, err)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %s has %d bytes, want %d", id, len(key), KeySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		k.order = append(k.order, id)
+	}
+	if len(k.order) == 0 {
+		return nil, errors.New("no encryption keys given")
+	}
+
+	k.active = active
+	if k.active == "" {
+		k.active = k.order[len(k.order)-1]
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return nil, fmt.Errorf("active encryption key %s is not configured", k.active)
+	}
+	return k, nil
+}
+
+// FromConfig loads the keys from ENCRYPTION_KEY_FILE and ENCRYPTION_KEYS.
+// It returns nil when no keys are configured and content is stored in plaintext.
+func FromConfig(cfg *config.Config) (*Keyring, error) {
+	entries := append([]string(nil), cfg.EncryptionKeys...)
+	if cfg.EncryptionKeyFile != "" {
+		fileEntries, err := readKeyFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(fileEntries, entries...)
+	}
+	if len(entries) == 0 {
+		if cfg.EncryptionActiveKey != "" {
+			return nil, fmt.Errorf("ENCRYPTION_ACTIVE_KEY is %s but no keys are configured", cfg.EncryptionActiveKey)
+		}
+		return nil, nil
+	}
+	return NewKeyring(entries, cfg.EncryptionActiveKey)
+}
+
+// readKeyFile reads id:base64key lines, skipping blank lines and # comments
+func readKeyFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening key file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	return entries, nil
+}
+
+// GenerateKey returns a new random master key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generating key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ActiveKeyID returns the ID of the key sealing new values
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// KeyIDs returns the IDs of all configured keys
+func (k *Keyring) KeyIDs() []string {
+	return append([]string(nil), k.order...)
+}
+
+// Seal encrypts plaintext with a fresh data key, which is itself encrypted
+// with the active master key. The label binds the value to where it is stored,
+// e.g. the column name, and must be given again to open it.
+func (k *Keyring) Seal(label, plaintext string) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("generating data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	master := k.keys[k.active]
+
+	// version | wrap nonce | wrapped data key | data nonce | ciphertext
+	out := []byte{sealedVersion}
+	out, err = seal(master, out, dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+	out, err = seal(data, out, []byte(plaintext), []byte(k.active+"\x00"+label))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open decrypts a value sealed with the given key and label
+func (k *Keyring) Open(keyID, label, sealed string) (string, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("encryption key %s is not configured", keyID)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) == 0 || raw[0] != sealedVersion {
+		return "", errors.New("malformed encrypted value")
+	}
+	raw = raw[1:]
+
+	wrappedLen := master.NonceSize() + KeySize + master.Overhead()
+	if len(raw) < wrappedLen {
+		return "", errors.New("malformed encrypted value")
+	}
+	dataKey, err := open(master, raw[:wrappedLen], []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("unwrapping data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, raw[wrappedLen:], []byte(keyID+"\x00"+label))
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// seal appends a random nonce and the ciphertext of plaintext to dst
+func seal(aead cipher.AEAD, dst, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+// open decrypts a nonce followed by its ciphertext
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,124 @@
+// Package eval provides an evaluation harness for comparing bot configurations
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Case is a single evaluation test case
+type Case struct {
+	ID          string   `json:"id"`
+	Input       string   `json:"input"`
+	Exact       string   `json:"exact,omitempty"`
+	Contains    []string `json:"contains,omitempty"`
+	NotContains []string `json:"not_contains,omitempty"`
+	Regex       []string `json:"regex,omitempty"`
+	Reference   string   `json:"reference,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+// Check is the outcome of a single deterministic check
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// LoadCases reads test cases from a JSON array or a JSONL file
+func LoadCases(path string) ([]*Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading cases: %w", err)
+	}
+
+	var cases []*Case
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &cases); err != nil {
+			return nil, fmt.Errorf("parsing cases: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var c Case
+			if err := json.Unmarshal(text, &c); err != nil {
+				return nil, fmt.Errorf("parsing case on line %d: %w", line, err)
+			}
+			cases = append(cases, &c)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading cases: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool, len(cases))
+	for i, c := range cases {
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("case-%d", i+1)
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("duplicate case id %q", c.ID)
+		}
+		seen[c.ID] = true
+
+		if strings.TrimSpace(c.Input) == "" {
+			return nil, fmt.Errorf("case %s has no input", c.ID)
+		}
+		for _, expr := range c.Regex {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("case %s has invalid regex %q: %w", c.ID, expr, err)
+			}
+			c.patterns = append(c.patterns, re)
+		}
+	}
+	return cases, nil
+}
+
+// Score runs the deterministic checks of the case against an output
+func (c *Case) Score(output string) []Check {
+	var checks []Check
+	normalized := strings.ToLower(output)
+
+	if c.Exact != "" {
+		checks = append(checks, Check{
+			Name:   "exact",
+			Passed: strings.EqualFold(strings.TrimSpace(output), strings.TrimSpace(c.Exact)),
+		})
+	}
+	for _, want := range c.Contains {
+		checks = append(checks, Check{
+			Name:   "contains",
+			Passed: strings.Contains(normalized, strings.ToLower(want)),
+			Detail: want,
+		})
+	}
+	for _, unwanted := range c.NotContains {
+		checks = append(checks, Check{
+			Name:   "not_contains",
+			Passed: !strings.Contains(normalized, strings.ToLower(unwanted)),
+			Detail: unwanted,
+		})
+	}
+	for _, re := range c.patterns {
+		checks = append(checks, Check{
+			Name:   "regex",
+			Passed: re.MatchString(output),
+			Detail: re.String(),
+		})
+	}
+	return checks
+}
@@ -0,0 +1,81 @@
+// Package eval provides an evaluation harness for comparing bot configurations
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// JudgePrompt is the system prompt given to the judge model
+const JudgePrompt = `You are a strict evaluator of chatbot answers.
+You receive a question, a reference answer and a candidate answer.
+Rate how well the candidate answer matches the facts and intent of the reference
+on a scale from 0 (wrong or unhelpful) to 10 (fully correct and helpful).
+Respond with JSON only, in the form {"score": <0-10>, "reason": "<one sentence>"}.`
+
+// Verdict is the judge model's assessment of an answer
+type Verdict struct {
+	Score  float64 `json:"score"` // normalized to 0..1
+	Reason string  `json:"reason"`
+}
+
+// Judge scores answers against reference answers with an LLM
+type Judge struct {
+	client    llm.Client
+	threshold float64
+}
+
+// NewJudge creates a judge. The client should be configured with JudgePrompt
+// as its system prompt. Answers scoring below threshold (0..1) fail.
+func NewJudge(client llm.Client, threshold float64) *Judge {
+	return &Judge{
+		client:    client,
+		threshold: threshold,
+	}
+}
+
+// Evaluate asks the judge model to score an answer against the case reference
+func (j *Judge) Evaluate(ctx context.Context, c *Case, answer string) (*Verdict, llm.Usage, error) {
+	prompt := fmt.Sprintf("Question:\n%s\n\nReference answer:\n%s\n\nCandidate answer:\n%s",
+		c.Input, c.Reference, answer)
+
+	completion, err := j.client.Complete(ctx, prompt)
+	if err != nil {
+		return nil, llm.Usage{}, fmt.Errorf("getting judge response: %w", err)
+	}
+
+	verdict, err := parseVerdict(completion.Content)
+	if err != nil {
+		return nil, completion.Usage, err
+	}
+	return verdict, completion.Usage, nil
+}
+
+// Passed reports whether a verdict meets the judge threshold
+func (j *Judge) Passed(v *Verdict) bool {
+	return v.Score >= j.threshold
+}
+
+// parseVerdict extracts the JSON verdict from the judge response, tolerating
+// surrounding prose or code fences
+func parseVerdict(content string) (*Verdict, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("judge response has no JSON verdict: %q", content)
+	}
+
+	var v Verdict
+	if err := json.Unmarshal([]byte(content[start:end+1]), &v); err != nil {
+		return nil, fmt.Errorf("parsing judge verdict: %w", err)
+	}
+	if v.Score < 0 || v.Score > 10 {
+		return nil, fmt.Errorf("judge score %v out of range", v.Score)
+	}
+	v.Score /= 10
+	return &v, nil
+}
@@ -0,0 +1,148 @@
+// Package eval provides an evaluation harness for comparing bot configurations
+package eval
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// CaseDiff describes a case whose outcome differs between two runs
+type CaseDiff struct {
+	ID      string `json:"id"`
+	Input   string `json:"input"`
+	BaseOK  bool   `json:"base_passed"`
+	OtherOK bool   `json:"other_passed"`
+	Base    string `json:"base_output"`
+	Other   string `json:"other_output"`
+}
+
+// Comparison is the report comparing a candidate run against a baseline run
+type Comparison struct {
+	Base         *RunResult `json:"base"`
+	Other        *RunResult `json:"other,omitempty"`
+	Regressions  []CaseDiff `json:"regressions,omitempty"`
+	Improvements []CaseDiff `json:"improvements,omitempty"`
+}
+
+// Compare builds a comparison report. other may be nil for a single run.
+func Compare(base, other *RunResult) *Comparison {
+	cmp := &Comparison{Base: base, Other: other}
+	if other == nil {
+		return cmp
+	}
+
+	byID := make(map[string]*CaseResult, len(other.Results))
+	for _, res := range other.Results {
+		byID[res.ID] = res
+	}
+
+	for _, b := range base.Results {
+		o, ok := byID[b.ID]
+		if !ok || b.Passed == o.Passed {
+			continue
+		}
+		diff := CaseDiff{
+			ID:      b.ID,
+			Input:   b.Input,
+			BaseOK:  b.Passed,
+			OtherOK: o.Passed,
+			Base:    outputOrError(b),
+			Other:   outputOrError(o),
+		}
+		if b.Passed {
+			cmp.Regressions = append(cmp.Regressions, diff)
+		} else {
+			cmp.Improvements = append(cmp.Improvements, diff)
+		}
+	}
+	return cmp
+}
+
+// WriteText renders the comparison as a human readable report
+func (c *Comparison) WriteText(w io.Writer) error {
+	runs := []*RunResult{c.Base}
+	if c.Other != nil {
+		runs = append(runs, c.Other)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tMODEL\tPASSED\tPASS RATE\tERRORS\tJUDGE\tAVG MS\tP95 MS\tTOKENS\tCOST USD")
+	for _, run := range runs {
+		s := run.Summary
+		judge := "-"
+		if s.AvgJudgeScore > 0 {
+			judge = fmt.Sprintf("%.2f", s.AvgJudgeScore)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%.1f%%\t%d\t%s\t%d\t%d\t%d\t%.6f\n",
+			run.Profile, run.Model, s.Passed, s.Cases, s.PassRate*100, s.Errors, judge,
+			s.AvgLatencyMS, s.P95LatencyMS, s.PromptTokens+s.CompletionTokens, s.Cost)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if c.Other == nil {
+		fmt.Fprintln(w, "\nFailed cases:")
+		for _, res := range c.Base.Results {
+			if !res.Passed {
+				fmt.Fprintf(w, "- %s: %s\n", res.ID, failureReason(res))
+			}
+		}
+		return nil
+	}
+
+	delta := c.Other.Summary.PassRate - c.Base.Summary.PassRate
+	fmt.Fprintf(w, "\nPass rate change: %+.1f points, %d regressions, %d improvements\n",
+		delta*100, len(c.Regressions), len(c.Improvements))
+
+	writeDiffs(w, "Regressions", c.Base.Profile, c.Other.Profile, c.Regressions)
+	writeDiffs(w, "Improvements", c.Base.Profile, c.Other.Profile, c.Improvements)
+	return nil
+}
+
+func writeDiffs(w io.Writer, title, baseName, otherName string, diffs []CaseDiff) {
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, d := range diffs {
+		fmt.Fprintf(w, "\n[%s] %s\n", d.ID, truncate(d.Input, 200))
+		fmt.Fprintf(w, "  %s: %s\n", baseName, truncate(d.Base, 400))
+		fmt.Fprintf(w, "  %s: %s\n", otherName, truncate(d.Other, 400))
+	}
+}
+
+// failureReason summarizes why a case failed
+func failureReason(res *CaseResult) string {
+	if res.Error != "" {
+		return "error: " + res.Error
+	}
+
+	var reasons []string
+	for _, check := range res.Checks {
+		if !check.Passed {
+			reasons = append(reasons, strings.TrimSpace(check.Name+" "+check.Detail))
+		}
+	}
+	if res.Judge != nil {
+		reasons = append(reasons, fmt.Sprintf("judge %.2f: %s", res.Judge.Score, res.Judge.Reason))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func outputOrError(res *CaseResult) string {
+	if res.Error != "" {
+		return "error: " + res.Error
+	}
+	return res.Output
+}
+
+func truncate(s string, n int) string {
+	r := []rune(strings.Join(strings.Fields(s), " "))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n]) + "..."
+}
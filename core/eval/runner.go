@@ -0,0 +1,265 @@
+// Package eval provides an evaluation harness for comparing bot configurations
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// Profile describes a bot configuration under evaluation
+type Profile struct {
+	Name         string
+	Model        string
+	SystemPrompt string
+	Pricing      llm.Pricing
+}
+
+// profileFile is the JSON shape of a profile on disk. Empty fields inherit
+// from the environment configuration.
+type profileFile struct {
+	Name                   string   `json:"name"`
+	Model                  string   `json:"model"`
+	SystemPrompt           string   `json:"system_prompt"`
+	SystemPromptFile       string   `json:"system_prompt_file"`
+	PromptPricePerMTok     *float64 `json:"prompt_price_per_mtok"`
+	CompletionPricePerMTok *float64 `json:"completion_price_per_mtok"`
+}
+
+// BaseProfile returns the profile of the environment configuration
+func BaseProfile(cfg *config.Config) *Profile {
+	return &Profile{
+		Name:         "current",
+		Model:        cfg.ModelName,
+		SystemPrompt: cfg.SystemPrompt,
+		Pricing: llm.Pricing{
+			PromptPerMTok:     cfg.PromptPricePerMTok,
+			CompletionPerMTok: cfg.CompletionPricePerMTok,
+		},
+	}
+}
+
+// LoadProfile reads a profile from a JSON file on top of the base configuration
+func LoadProfile(path string, cfg *config.Config) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading profile: %w", err)
+	}
+
+	var pf profileFile
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("parsing profile %s: %w", path, err)
+	}
+
+	p := BaseProfile(cfg)
+	p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if pf.Name != "" {
+		p.Name = pf.Name
+	}
+	if pf.Model != "" {
+		p.Model = pf.Model
+	}
+	if pf.SystemPromptFile != "" {
+		promptPath := pf.SystemPromptFile
+		if !filepath.IsAbs(promptPath) {
+			promptPath = filepath.Join(filepath.Dir(path), promptPath)
+		}
+		prompt, err := os.ReadFile(promptPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading system prompt: %w", err)
+		}
+		p.SystemPrompt = strings.TrimSpace(string(prompt))
+	}
+	if pf.SystemPrompt != "" {
+		p.SystemPrompt = pf.SystemPrompt
+	}
+	if pf.PromptPricePerMTok != nil {
+		p.Pricing.PromptPerMTok = *pf.PromptPricePerMTok
+	}
+	if pf.CompletionPricePerMTok != nil {
+		p.Pricing.CompletionPerMTok = *pf.CompletionPricePerMTok
+	}
+	return p, nil
+}
+
+// Apply returns a copy of cfg using the profile's model and prompt
+func (p *Profile) Apply(cfg *config.Config) *config.Config {
+	c := *cfg
+	c.ModelName = p.Model
+	c.SystemPrompt = p.SystemPrompt
+	return &c
+}
+
+// CaseResult is the outcome of running a single case
+type CaseResult struct {
+	ID         string    `json:"id"`
+	Input      string    `json:"input"`
+	Output     string    `json:"output"`
+	Passed     bool      `json:"passed"`
+	Checks     []Check   `json:"checks,omitempty"`
+	Judge      *Verdict  `json:"judge,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Usage      llm.Usage `json:"usage"`
+	JudgeUsage llm.Usage `json:"judge_usage"`
+	Cost       float64   `json:"cost"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Summary aggregates the results of a run
+type Summary struct {
+	Cases            int     `json:"cases"`
+	Passed           int     `json:"passed"`
+	Errors           int     `json:"errors"`
+	PassRate         float64 `json:"pass_rate"`
+	AvgJudgeScore    float64 `json:"avg_judge_score,omitempty"`
+	AvgLatencyMS     int64   `json:"avg_latency_ms"`
+	P95LatencyMS     int64   `json:"p95_latency_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	JudgeTokens      int     `json:"judge_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// RunResult holds the results of evaluating one profile
+type RunResult struct {
+	Profile string        `json:"profile"`
+	Model   string        `json:"model"`
+	Summary Summary       `json:"summary"`
+	Results []*CaseResult `json:"results"`
+}
+
+// Runner evaluates profiles against a set of cases
+type Runner struct {
+	judge       *Judge
+	concurrency int
+}
+
+// NewRunner creates an evaluation runner. judge may be nil to only run the
+// deterministic checks.
+func NewRunner(judge *Judge, concurrency int) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{
+		judge:       judge,
+		concurrency: concurrency,
+	}
+}
+
+// Run evaluates all cases with the client configured for the profile
+func (r *Runner) Run(ctx context.Context, client llm.Client, profile *Profile, cases []*Case) *RunResult {
+	results := make([]*CaseResult, len(cases))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, r.concurrency)
+	for i, c := range cases {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c *Case) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = r.runCase(ctx, client, profile, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return &RunResult{
+		Profile: profile.Name,
+		Model:   profile.Model,
+		Summary: summarize(results),
+		Results: results,
+	}
+}
+
+func (r *Runner) runCase(ctx context.Context, client llm.Client, profile *Profile, c *Case) *CaseResult {
+	res := &CaseResult{
+		ID:    c.ID,
+		Input: c.Input,
+	}
+
+	start := time.Now()
+	completion, err := client.Complete(ctx, c.Input)
+	res.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Output = completion.Content
+	res.Usage = completion.Usage
+	res.Cost = profile.Pricing.Cost(completion.Usage)
+	res.Checks = c.Score(completion.Content)
+
+	// A case without any expectation only verifies that the bot answers
+	res.Passed = true
+	for _, check := range res.Checks {
+		if !check.Passed {
+			res.Passed = false
+		}
+	}
+
+	if r.judge != nil && c.Reference != "" {
+		verdict, usage, err := r.judge.Evaluate(ctx, c, completion.Content)
+		res.JudgeUsage = usage
+		if err != nil {
+			res.Error = err.Error()
+			res.Passed = false
+			return res
+		}
+		res.Judge = verdict
+		if !r.judge.Passed(verdict) {
+			res.Passed = false
+		}
+	}
+
+	return res
+}
+
+func summarize(results []*CaseResult) Summary {
+	s := Summary{Cases: len(results)}
+	if len(results) == 0 {
+		return s
+	}
+
+	var totalLatency int64
+	var judged int
+	var judgeTotal float64
+	latencies := make([]int64, 0, len(results))
+	for _, res := range results {
+		if res.Passed {
+			s.Passed++
+		}
+		if res.Error != "" {
+			s.Errors++
+		}
+		if res.Judge != nil {
+			judged++
+			judgeTotal += res.Judge.Score
+		}
+		totalLatency += res.LatencyMS
+		latencies = append(latencies, res.LatencyMS)
+		s.PromptTokens += res.Usage.PromptTokens
+		s.CompletionTokens += res.Usage.CompletionTokens
+		s.JudgeTokens += res.JudgeUsage.TotalTokens
+		s.Cost += res.Cost
+	}
+
+	s.PassRate = float64(s.Passed) / float64(s.Cases)
+	s.AvgLatencyMS = totalLatency / int64(len(results))
+	if judged > 0 {
+		s.AvgJudgeScore = judgeTotal / float64(judged)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s.P95LatencyMS = latencies[(len(latencies)*95+99)/100-1]
+	return s
+}
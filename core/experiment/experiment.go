@@ -0,0 +1,253 @@
+// Package experiment provides A/B experiments over prompts, models and sampling parameters
+package experiment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/llm"
+)
+
+// buckets is the resolution of traffic fractions
+const buckets = 10000
+
+// Variant is one arm of an experiment
+type Variant struct {
+	Name    string
+	Weight  int
+	Request llm.Request // prompt, model and params used for chats in this variant
+	Pricing llm.Pricing
+}
+
+// Experiment splits a fraction of chats across weighted variants
+type Experiment struct {
+	Name     string
+	Traffic  float64
+	Variants []*Variant
+
+	totalWeight int
+}
+
+// Assignment is the experiment variant a chat has been placed in
+type Assignment struct {
+	Experiment string
+	Variant    *Variant
+}
+
+// Set holds the enabled experiments in priority order
+type Set struct {
+	experiments []*Experiment
+}
+
+// fileVariant is the JSON shape of a variant. Empty fields inherit from the
+// environment configuration.
+type fileVariant struct {
+	Name                   string   `json:"name"`
+	Weight                 int      `json:"weight"`
+	SystemPrompt           string   `json:"system_prompt"`
+	SystemPromptFile       string   `json:"system_prompt_file"`
+	Model                  string   `json:"model"`
+	Temperature            *float64 `json:"temperature"`
+	TopP                   *float64 `json:"top_p"`
+	MaxTokens              *int     `json:"max_tokens"`
+	PromptPricePerMTok     *float64 `json:"prompt_price_per_mtok"`
+	CompletionPricePerMTok *float64 `json:"completion_price_per_mtok"`
+}
+
+type fileExperiment struct {
+	Name     string        `json:"name"`
+	Enabled  bool          `json:"enabled"`
+	Traffic  *float64      `json:"traffic"`
+	Variants []fileVariant `json:"variants"`
+}
+
+// Load reads experiment definitions from a JSON file. Disabled experiments
+// are skipped; a chat takes part in the first enabled experiment it is
+// enrolled in.
+func Load(path string, cfg *config.Config) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading experiments: %w", err)
+	}
+
+	var defs []fileExperiment
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("parsing experiments: %w", err)
+	}
+
+	set := &Set{}
+	seen := make(map[string]bool)
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, errors.New("experiment without a name")
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("duplicate experiment %q", def.Name)
+		}
+		seen[def.Name] = true
+		if !def.Enabled {
+			continue
+		}
+
+		exp, err := buildExperiment(def, filepath.Dir(path), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("experiment %s: %w", def.Name, err)
+		}
+		set.experiments = append(set.experiments, exp)
+	}
+	return set, nil
+}
+
+func buildExperiment(def fileExperiment, dir string, cfg *config.Config) (*Experiment, error) {
+	exp := &Experiment{Name: def.Name, Traffic: 1}
+	if def.Traffic != nil {
+		exp.Traffic = *def.Traffic
+	}
+	if exp.Traffic < 0 || exp.Traffic > 1 {
+		return nil, fmt.Errorf("traffic %v must be between 0 and 1", exp.Traffic)
+	}
+	if len(def.Variants) < 2 {
+		return nil, errors.New("at least two variants are required")
+	}
+
+	names := make(map[string]bool)
+	for _, fv := range def.Variants {
+		if fv.Name == "" {
+			return nil, errors.New("variant without a name")
+		}
+		if names[fv.Name] {
+			return nil, fmt.Errorf("duplicate variant %q", fv.Name)
+		}
+		names[fv.Name] = true
+		if fv.Weight < 0 {
+			return nil, fmt.Errorf("variant %s has a negative weight", fv.Name)
+		}
+
+		v, err := buildVariant(fv, dir, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", fv.Name, err)
+		}
+		exp.Variants = append(exp.Variants, v)
+		exp.totalWeight += v.Weight
+	}
+
+	if exp.totalWeight == 0 {
+		return nil, errors.New("all variants have zero weight")
+	}
+	return exp, nil
+}
+
+func buildVariant(fv fileVariant, dir string, cfg *config.Config) (*Variant, error) {
+	v := &Variant{
+		Name:   fv.Name,
+		Weight: fv.Weight,
+		Request: llm.Request{
+			SystemPrompt: cfg.SystemPrompt,
+			Model:        cfg.ModelName,
+			Params: &llm.Params{
+				Temperature: cfg.Temperature,
+				TopP:        cfg.TopP,
+				MaxTokens:   cfg.MaxTokens,
+			},
+		},
+		Pricing: llm.Pricing{
+			PromptPerMTok:     cfg.PromptPricePerMTok,
+			CompletionPerMTok: cfg.CompletionPricePerMTok,
+		},
+	}
+
+	if fv.SystemPromptFile != "" {
+		path := fv.SystemPromptFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		prompt, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading system prompt: %w", err)
+		}
+		v.Request.SystemPrompt = strings.TrimSpace(string(prompt))
+	}
+	if fv.SystemPrompt != "" {
+		v.Request.SystemPrompt = fv.SystemPrompt
+	}
+	if fv.Model != "" {
+		v.Request.Model = fv.Model
+	}
+	if fv.Temperature != nil {
+		v.Request.Params.Temperature = *fv.Temperature
+	}
+	if fv.TopP != nil {
+		v.Request.Params.TopP = *fv.TopP
+	}
+	if fv.MaxTokens != nil {
+		v.Request.Params.MaxTokens = *fv.MaxTokens
+	}
+	if fv.PromptPricePerMTok != nil {
+		v.Pricing.PromptPerMTok = *fv.PromptPricePerMTok
+	}
+	if fv.CompletionPricePerMTok != nil {
+		v.Pricing.CompletionPerMTok = *fv.CompletionPricePerMTok
+	}
+	return v, nil
+}
+
+// Assign returns the variant for a chat. Assignment is sticky: the same chat
+// always lands in the same variant as long as the definitions don't change.
+func (s *Set) Assign(chatID string) (*Assignment, bool) {
+	if s == nil || chatID == "" {
+		return nil, false
+	}
+
+	for _, exp := range s.experiments {
+		if bucket(exp.Name, "traffic", chatID) >= int(exp.Traffic*buckets) {
+			continue
+		}
+
+		point := bucket(exp.Name, "variant", chatID) % exp.totalWeight
+		for _, v := range exp.Variants {
+			if point < v.Weight {
+				return &Assignment{Experiment: exp.Name, Variant: v}, true
+			}
+			point -= v.Weight
+		}
+	}
+	return nil, false
+}
+
+// Experiment returns the enabled experiment with the given name
+func (s *Set) Experiment(name string) (*Experiment, bool) {
+	if s == nil {
+		return nil, false
+	}
+	for _, exp := range s.experiments {
+		if exp.Name == name {
+			return exp, true
+		}
+	}
+	return nil, false
+}
+
+// Experiments returns the enabled experiments in priority order
+func (s *Set) Experiments() []*Experiment {
+	if s == nil {
+		return nil
+	}
+	return s.experiments
+}
+
+// bucket hashes the chat into [0, buckets) independently per experiment and purpose
+func bucket(experiment, salt, chatID string) int {
+	h := fnv.New64a()
+	h.Write([]byte(experiment))
+	h.Write([]byte{0})
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(chatID))
+	return int(h.Sum64() % buckets)
+}
@@ -0,0 +1,230 @@
+// Package importer reads conversations kept outside the bot, such as old
+// JSONL exports and WhatsApp chat exports, into the store
+package importer
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// Input formats
+const (
+	FormatJSONL    = "jsonl"
+	FormatWhatsApp = "whatsapp"
+)
+
+// Store is the storage imported exchanges are written to
+type Store interface {
+	ImportExchanges(ctx context.Context, exchanges []*db.Exchange) (*db.ImportResult, error)
+}
+
+// Options controls how a file is mapped into the store
+type Options struct {
+	// Format of the file, detected from the extension when empty
+	Format string
+
+	// Channel of imported chats, default "import" for JSONL and "whatsapp"
+	// for WhatsApp exports. JSONL records naming a channel keep it.
+	Channel string
+
+	// ChatID attributes the imported messages to this chat. Without it the
+	// chat comes from the records, the other participant of a private
+	// WhatsApp chat or the file name.
+	ChatID string
+
+	// BotName is the WhatsApp display name of the bot; its messages become
+	// responses and everyone else's become prompts
+	BotName string
+
+	// Participants maps WhatsApp display names to sender IDs (JIDs)
+	Participants map[string]string
+
+	// DateOrder of WhatsApp timestamps: "dmy", "mdy", "ymd" or empty to
+	// detect it from the file
+	DateOrder string
+
+	// Location of WhatsApp timestamps, default local time
+	Location *time.Location
+
+	// BatchSize is the number of exchanges stored per transaction
+	BatchSize int
+
+	// Keys opens sealed retention archive records, nil when none are expected
+	Keys *encryption.Keyring
+}
+
+// ParseError is an input line that could not be read
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Report is the outcome of importing a file
+type Report struct {
+	File         string
+	Format       string
+	Records      int // exchanges read from the file
+	Interactions int
+	Messages     int
+	Duplicates   int
+	Skipped      int // media placeholders and system notices
+	Errors       []ParseError
+	Warnings     []string
+}
+
+// Import reads a file and stores its conversations. Parse errors are
+// collected in the report; only storage errors abort the import.
+func Import(ctx context.Context, store Store, path string, opts Options) (*Report, error) {
+	if opts.Format == "" {
+		opts.Format = detectFormat(path)
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 200
+	}
+
+	r, closeFile, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile()
+
+	report := &Report{File: path, Format: opts.Format}
+	var exchanges []*db.Exchange
+	switch opts.Format {
+	case FormatJSONL:
+		exchanges, err = parseJSONL(r, path, opts, report)
+	case FormatWhatsApp:
+		exchanges, err = parseWhatsApp(r, path, opts, report)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+	if err != nil {
+		return report, fmt.Errorf("reading %s: %w", path, err)
+	}
+	report.Records = len(exchanges)
+
+	for start := 0; start < len(exchanges); start += opts.BatchSize {
+		end := min(start+opts.BatchSize, len(exchanges))
+		result, err := store.ImportExchanges(ctx, exchanges[start:end])
+		if err != nil {
+			return report, err
+		}
+		report.Interactions += result.Interactions
+		report.Messages += result.Messages
+		report.Duplicates += result.Duplicates
+	}
+	return report, nil
+}
+
+// detectFormat guesses the format from the file name
+func detectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".zip":
+		return FormatWhatsApp
+	default:
+		return FormatJSONL
+	}
+}
+
+// open opens a file for reading. WhatsApp exports shared as a zip archive
+// are read from the chat text file inside.
+func open(path string) (io.Reader, func() error, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening archive: %w", err)
+		}
+		for _, f := range archive.File {
+			if strings.EqualFold(filepath.Ext(f.Name), ".txt") {
+				rc, err := f.Open()
+				if err != nil {
+					archive.Close()
+					return nil, nil, fmt.Errorf("opening %s in archive: %w", f.Name, err)
+				}
+				return rc, func() error {
+					rc.Close()
+					return archive.Close()
+				}, nil
+			}
+		}
+		archive.Close()
+		return nil, nil, fmt.Errorf("no chat text file in %s", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening import file: %w", err)
+	}
+	return file, file.Close, nil
+}
+
+// externalID derives a stable message ID from the content, so importing the
+// same data again finds the messages stored the first time
+func externalID(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return "import-" + hex.EncodeToString(sum[:12])
+}
+
+// exchangeIDs sets the external IDs of an exchange's messages
+func exchangeIDs(ex *db.Exchange) {
+	at := ""
+	if !ex.Inbound.SentAt.IsZero() {
+		at = ex.Inbound.SentAt.UTC().Format(time.RFC3339)
+	} else if !ex.Outbound.SentAt.IsZero() {
+		at = ex.Outbound.SentAt.UTC().Format(time.RFC3339)
+	}
+	key := []string{ex.Chat.Channel, ex.Chat.ExternalID, ex.Sender.ExternalID, at, ex.Inbound.Content, ex.Outbound.Content}
+	if ex.Inbound.Content != "" {
+		ex.Inbound.ExternalID = externalID(append(key, db.RoleUser)...)
+	}
+	if ex.Outbound.Content != "" {
+		ex.Outbound.ExternalID = externalID(append(key, db.RoleAssistant)...)
+	}
+}
+
+// fileChatID is the chat of records that do not name one
+func fileChatID(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return "import:" + name
+}
+
+// phoneNumber matches display names that are phone numbers, as WhatsApp
+// shows senders missing from the address book
+var phoneNumber = regexp.MustCompile(`^\+?[\d\s()-]{7,}$`)
+
+// senderID returns the external ID of a WhatsApp sender. Phone numbers
+// become JIDs so privacy requests find the imported messages.
+func senderID(name string, participants map[string]string) string {
+	if id, ok := participants[name]; ok {
+		return id
+	}
+	if phoneNumber.MatchString(name) {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, name)
+		return digits + "@s.whatsapp.net"
+	}
+	return "name:" + name
+}
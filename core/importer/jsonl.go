@@ -0,0 +1,236 @@
+// Package importer reads conversations kept outside the bot, such as old
+// JSONL exports and WhatsApp chat exports, into the store
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/retention"
+)
+
+// jsonlRecord is any of the JSONL shapes the bot has written: the legacy
+// prompt/completion export, interaction archives and privacy bundles, and the
+// openai, sharegpt and alpaca dataset formats
+type jsonlRecord struct {
+	Prompt     string `json:"prompt"`
+	Completion string `json:"completion"`
+	Response   string `json:"response"`
+
+	Instruction string `json:"instruction"`
+	Input       string `json:"input"`
+	Output      string `json:"output"`
+	System      string `json:"system"`
+
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Conversations []struct {
+		From  string `json:"from"`
+		Value string `json:"value"`
+	} `json:"conversations"`
+
+	SystemPrompt     string    `json:"system_prompt"`
+	CreatedAt        time.Time `json:"created_at"`
+	Channel          string    `json:"channel"`
+	ChatID           string    `json:"chat_id"`
+	SenderID         string    `json:"sender_id"`
+	Model            string    `json:"model"`
+	Provider         string    `json:"provider"`
+	Temperature      float64   `json:"temperature"`
+	TopP             float64   `json:"top_p"`
+	MaxTokens        int       `json:"max_tokens"`
+	Experiment       string    `json:"experiment"`
+	Variant          string    `json:"variant"`
+	LatencyMS        int64     `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+
+	// Set on retention archive records sealed with an encryption key
+	KeyID  string `json:"key_id"`
+	Sealed string `json:"sealed"`
+}
+
+// open replaces a sealed archive record with its decrypted content
+func (rec *jsonlRecord) open(opts Options) error {
+	if rec.Sealed == "" {
+		return nil
+	}
+	if opts.Keys == nil {
+		return fmt.Errorf("archive records are encrypted with key %s: %w", rec.KeyID, db.ErrNoKeys)
+	}
+	data, err := opts.Keys.Open(rec.KeyID, retention.ArchiveLabel, rec.Sealed)
+	if err != nil {
+		return err
+	}
+	*rec = jsonlRecord{}
+	return json.Unmarshal([]byte(data), rec)
+}
+
+// shareGPTRoles maps ShareGPT speakers to message roles
+var shareGPTRoles = map[string]string{
+	"system": db.RoleSystem,
+	"human":  db.RoleUser,
+	"user":   db.RoleUser,
+	"gpt":    db.RoleAssistant,
+}
+
+// maxJSONLLine is the longest record accepted
+const maxJSONLLine = 16 << 20
+
+// parseJSONL reads one record per line
+func parseJSONL(r io.Reader, path string, opts Options, report *Report) ([]*db.Exchange, error) {
+	var exchanges []*db.Exchange
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxJSONLLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec jsonlRecord
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			report.Errors = append(report.Errors, ParseError{Line: line, Err: err})
+			continue
+		}
+		if err := rec.open(opts); err != nil {
+			// Without keys no sealed record can be read, so stop at the first
+			if errors.Is(err, db.ErrNoKeys) {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			report.Errors = append(report.Errors, ParseError{Line: line, Err: err})
+			continue
+		}
+		parsed, err := rec.exchanges(path, opts)
+		if err != nil {
+			report.Errors = append(report.Errors, ParseError{Line: line, Err: err})
+			continue
+		}
+		exchanges = append(exchanges, parsed...)
+	}
+	return exchanges, scanner.Err()
+}
+
+// exchanges maps a record to the exchanges it contains
+func (rec *jsonlRecord) exchanges(path string, opts Options) ([]*db.Exchange, error) {
+	chat := db.ChatRef{Channel: rec.Channel, ExternalID: rec.ChatID}
+	if chat.Channel == "" {
+		chat.Channel = opts.Channel
+	}
+	if chat.Channel == "" {
+		chat.Channel = "import"
+	}
+	if opts.ChatID != "" {
+		chat.ExternalID = opts.ChatID
+	}
+	if chat.ExternalID == "" {
+		chat.ExternalID = fileChatID(path)
+	}
+
+	system := rec.SystemPrompt
+	if system == "" {
+		system = rec.System
+	}
+
+	var turns []db.Turn
+	switch {
+	case len(rec.Messages) > 0:
+		for _, m := range rec.Messages {
+			turns = append(turns, db.Turn{Role: m.Role, Content: m.Content})
+		}
+	case len(rec.Conversations) > 0:
+		for _, m := range rec.Conversations {
+			role, ok := shareGPTRoles[m.From]
+			if !ok {
+				return nil, errors.New("unknown sharegpt speaker " + m.From)
+			}
+			turns = append(turns, db.Turn{Role: role, Content: m.Value})
+		}
+	case rec.Instruction != "":
+		prompt := rec.Instruction
+		if rec.Input != "" {
+			prompt += "\n\n" + rec.Input
+		}
+		turns = []db.Turn{{Role: db.RoleUser, Content: prompt}, {Role: db.RoleAssistant, Content: rec.Output}}
+	case rec.Prompt != "":
+		response := rec.Response
+		if response == "" {
+			response = rec.Completion
+		}
+		turns = []db.Turn{{Role: db.RoleUser, Content: rec.Prompt}, {Role: db.RoleAssistant, Content: response}}
+	default:
+		return nil, errors.New("no prompt, messages or conversations in record")
+	}
+
+	var exchanges []*db.Exchange
+	var pending *db.Exchange
+	flush := func() {
+		if pending != nil {
+			exchangeIDs(pending)
+			exchanges = append(exchanges, pending)
+			pending = nil
+		}
+	}
+	for _, t := range turns {
+		if strings.TrimSpace(t.Content) == "" {
+			continue
+		}
+		switch t.Role {
+		case db.RoleSystem:
+			system = t.Content
+		case db.RoleUser:
+			flush()
+			pending = rec.newExchange(chat, system)
+			pending.Inbound.Content = t.Content
+		case db.RoleAssistant:
+			if pending == nil {
+				pending = rec.newExchange(chat, system)
+			}
+			pending.Outbound.Content = t.Content
+			pending.Interaction.Prompt = pending.Inbound.Content
+			pending.Interaction.Response = t.Content
+			flush()
+		default:
+			return nil, errors.New("unknown message role " + t.Role)
+		}
+	}
+	flush()
+
+	if len(exchanges) == 0 {
+		return nil, errors.New("record has no messages")
+	}
+	return exchanges, nil
+}
+
+// newExchange starts an exchange carrying the record's metadata
+func (rec *jsonlRecord) newExchange(chat db.ChatRef, system string) *db.Exchange {
+	return &db.Exchange{
+		Chat:     chat,
+		Sender:   db.ParticipantRef{ExternalID: rec.SenderID},
+		Inbound:  db.MessageRecord{SentAt: rec.CreatedAt},
+		Outbound: db.MessageRecord{SentAt: rec.CreatedAt},
+		Interaction: db.InteractionRecord{
+			CreatedAt:        rec.CreatedAt,
+			SystemPrompt:     system,
+			Model:            rec.Model,
+			Provider:         rec.Provider,
+			Temperature:      rec.Temperature,
+			TopP:             rec.TopP,
+			MaxTokens:        rec.MaxTokens,
+			Experiment:       rec.Experiment,
+			Variant:          rec.Variant,
+			LatencyMS:        rec.LatencyMS,
+			PromptTokens:     rec.PromptTokens,
+			CompletionTokens: rec.CompletionTokens,
+		},
+	}
+}
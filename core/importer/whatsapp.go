@@ -0,0 +1,292 @@
+// Package importer reads conversations kept outside the bot, such as old
+// JSONL exports and WhatsApp chat exports, into the store
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+)
+
+// Date orders of WhatsApp timestamps
+const (
+	DateDMY = "dmy"
+	DateMDY = "mdy"
+	DateYMD = "ymd"
+)
+
+// whatsAppHeader matches the line starting a message in the export formats
+// of both apps and all locales:
+//
+//	12/31/23, 9:41 PM - Alice: Hi
+//	31.12.23, 21:41 - Alice: Hi
+//	[31.12.2023, 21:41:05] Alice: Hi
+//	2023-12-31 21:41 - Alice: Hi
+var whatsAppHeader = regexp.MustCompile(
+	`^\[?(\d{1,4})[./-](\d{1,2})[./-](\d{1,4}),?\s+(\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?\s*([AaPp]\.?\s?[Mm]\.?)?\]?\s*(?:-\s+)?(.*)$`)
+
+// whatsAppLine is a message header split into its parts
+type whatsAppLine struct {
+	line    int
+	a, b, c int // date fields in file order
+	hour    int
+	minute  int
+	second  int
+	pm, am  bool
+	sender  string
+	text    string
+}
+
+// whatsAppMessage is a parsed message
+type whatsAppMessage struct {
+	sentAt time.Time
+	sender string
+	text   string
+}
+
+// parseWhatsApp reads a WhatsApp "Export chat" text file. Lines that do not
+// start with a timestamp continue the previous message.
+func parseWhatsApp(r io.Reader, path string, opts Options, report *Report) ([]*db.Exchange, error) {
+	var lines []*whatsAppLine
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxJSONLLine)
+	for n := 1; scanner.Scan(); n++ {
+		text := cleanWhatsAppText(scanner.Text())
+		m := whatsAppHeader.FindStringSubmatch(text)
+		if m == nil {
+			if len(lines) == 0 {
+				if strings.TrimSpace(text) != "" {
+					report.Errors = append(report.Errors, ParseError{Line: n, Err: fmt.Errorf("no timestamp at start of file")})
+				}
+				continue
+			}
+			last := lines[len(lines)-1]
+			last.text += "\n" + text
+			continue
+		}
+
+		l := &whatsAppLine{line: n}
+		l.a, _ = strconv.Atoi(m[1])
+		l.b, _ = strconv.Atoi(m[2])
+		l.c, _ = strconv.Atoi(m[3])
+		l.hour, _ = strconv.Atoi(m[4])
+		l.minute, _ = strconv.Atoi(m[5])
+		l.second, _ = strconv.Atoi(m[6])
+		if marker := strings.ToLower(strings.NewReplacer(".", "", " ", "").Replace(m[7])); marker != "" {
+			l.pm, l.am = marker == "pm", marker == "am"
+		}
+
+		// System notices such as "Messages are end-to-end encrypted" have no sender
+		sender, body, ok := strings.Cut(m[8], ": ")
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		l.sender, l.text = strings.TrimSpace(sender), body
+		lines = append(lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	order := opts.DateOrder
+	if order == "" {
+		var ambiguous bool
+		order, ambiguous = detectDateOrder(lines)
+		if ambiguous {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("date order is ambiguous, assuming %s; set the date order if dates look wrong", order))
+		}
+	}
+
+	var messages []whatsAppMessage
+	for _, l := range lines {
+		sentAt, err := l.time(order, opts.Location)
+		if err != nil {
+			report.Errors = append(report.Errors, ParseError{Line: l.line, Err: err})
+			continue
+		}
+		text := strings.TrimSpace(l.text)
+		if isMediaPlaceholder(text) {
+			report.Skipped++
+			continue
+		}
+		messages = append(messages, whatsAppMessage{sentAt: sentAt, sender: l.sender, text: text})
+	}
+
+	chat := whatsAppChat(path, messages, opts)
+	if opts.BotName != "" && !hasSender(messages, opts.BotName) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%s never wrote in this chat, importing messages without responses", opts.BotName))
+	}
+	return whatsAppExchanges(chat, messages, opts), nil
+}
+
+// cleanWhatsAppText removes the direction marks and odd spaces the apps put
+// into exported lines
+func cleanWhatsAppText(s string) string {
+	return strings.NewReplacer("\u200e", "", "\u200f", "", "\ufeff", "", "\u202f", " ", "\u00a0", " ").Replace(s)
+}
+
+// detectDateOrder finds the date order from fields that cannot be a month.
+// Without such a field the day-first order used by most locales is assumed.
+func detectDateOrder(lines []*whatsAppLine) (order string, ambiguous bool) {
+	for _, l := range lines {
+		switch {
+		case l.a > 31:
+			return DateYMD, false
+		case l.a > 12:
+			return DateDMY, false
+		case l.b > 12:
+			return DateMDY, false
+		}
+	}
+	return DateDMY, true
+}
+
+// time assembles the timestamp of a header
+func (l *whatsAppLine) time(order string, loc *time.Location) (time.Time, error) {
+	var year, month, day int
+	switch order {
+	case DateDMY:
+		day, month, year = l.a, l.b, l.c
+	case DateMDY:
+		month, day, year = l.a, l.b, l.c
+	case DateYMD:
+		year, month, day = l.a, l.b, l.c
+	default:
+		return time.Time{}, fmt.Errorf("unknown date order %q", order)
+	}
+	if year < 100 {
+		year += 2000
+	}
+
+	hour := l.hour
+	if l.pm || l.am {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("invalid 12-hour time %d", hour)
+		}
+		hour %= 12
+		if l.pm {
+			hour += 12
+		}
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, l.minute, l.second, 0, loc)
+	if t.Day() != day || int(t.Month()) != month || t.Hour() != hour || t.Minute() != l.minute {
+		return time.Time{}, fmt.Errorf("invalid date %02d-%02d-%02d %02d:%02d", year, month, day, hour, l.minute)
+	}
+	return t, nil
+}
+
+// isMediaPlaceholder reports whether a message only stands for an
+// attachment or deleted content that was not exported
+func isMediaPlaceholder(text string) bool {
+	lower := strings.ToLower(text)
+	return text == "" ||
+		strings.HasPrefix(lower, "<attached: ") ||
+		(strings.HasPrefix(text, "<") && strings.HasSuffix(text, ">") && !strings.Contains(text, "\n")) ||
+		strings.HasSuffix(lower, " omitted") ||
+		lower == "this message was deleted" ||
+		lower == "you deleted this message"
+}
+
+// whatsAppChat identifies the chat of an export. A private chat is named
+// after the other participant's JID, like the chats the bot stores itself.
+func whatsAppChat(path string, messages []whatsAppMessage, opts Options) db.ChatRef {
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	title = strings.TrimPrefix(title, "WhatsApp Chat with ")
+	title = strings.TrimPrefix(title, "WhatsApp Chat - ")
+
+	senders := make(map[string]bool)
+	for _, m := range messages {
+		if m.sender != opts.BotName {
+			senders[m.sender] = true
+		}
+	}
+
+	chat := db.ChatRef{Channel: opts.Channel, ExternalID: opts.ChatID, Title: title, IsGroup: len(senders) > 1}
+	if chat.Channel == "" {
+		chat.Channel = db.ChannelWhatsApp
+	}
+	if chat.ExternalID == "" && len(senders) == 1 {
+		for sender := range senders {
+			if id := senderID(sender, opts.Participants); strings.HasSuffix(id, "@s.whatsapp.net") {
+				chat.ExternalID = id
+			}
+		}
+	}
+	if chat.ExternalID == "" {
+		chat.ExternalID = fileChatID(path)
+	}
+	return chat
+}
+
+// hasSender reports whether anyone named sender wrote a message
+func hasSender(messages []whatsAppMessage, sender string) bool {
+	for _, m := range messages {
+		if m.sender == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// whatsAppExchanges pairs the messages into exchanges. Consecutive messages
+// of one sender are joined, as people often split a question over several
+// messages, and the bot's answer to them becomes the response.
+func whatsAppExchanges(chat db.ChatRef, messages []whatsAppMessage, opts Options) []*db.Exchange {
+	var exchanges []*db.Exchange
+	var pending *db.Exchange
+	flush := func() {
+		if pending != nil {
+			exchangeIDs(pending)
+			exchanges = append(exchanges, pending)
+			pending = nil
+		}
+	}
+
+	for _, m := range messages {
+		if opts.BotName != "" && m.sender == opts.BotName {
+			switch {
+			case pending == nil:
+				pending = &db.Exchange{Chat: chat, Outbound: db.MessageRecord{Content: m.text, SentAt: m.sentAt}}
+			case pending.Outbound.Content == "":
+				pending.Outbound = db.MessageRecord{Content: m.text, SentAt: m.sentAt}
+			default:
+				pending.Outbound.Content += "\n" + m.text
+			}
+			continue
+		}
+
+		sender := db.ParticipantRef{ExternalID: senderID(m.sender, opts.Participants), DisplayName: m.sender}
+		if pending != nil && pending.Outbound.Content == "" && pending.Sender == sender {
+			pending.Inbound.Content += "\n" + m.text
+			continue
+		}
+		flush()
+		pending = &db.Exchange{Chat: chat, Sender: sender, Inbound: db.MessageRecord{Content: m.text, SentAt: m.sentAt}}
+	}
+	flush()
+
+	for _, ex := range exchanges {
+		if ex.Inbound.Content != "" && ex.Outbound.Content != "" {
+			ex.Interaction = db.InteractionRecord{
+				CreatedAt: ex.Inbound.SentAt,
+				Prompt:    ex.Inbound.Content,
+				Response:  ex.Outbound.Content,
+				LatencyMS: ex.Outbound.SentAt.Sub(ex.Inbound.SentAt).Milliseconds(),
+			}
+		}
+	}
+	return exchanges
+}
@@ -0,0 +1,215 @@
+package importer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/importer"
+)
+
+// captureStore keeps the imported exchanges instead of storing them
+type captureStore struct {
+	exchanges []*db.Exchange
+}
+
+func (s *captureStore) ImportExchanges(ctx context.Context, exchanges []*db.Exchange) (*db.ImportResult, error) {
+	s.exchanges = append(s.exchanges, exchanges...)
+	return &db.ImportResult{}, nil
+}
+
+// importWhatsApp imports a chat export with the given content as written by
+// Alice and the bot, with timestamps in UTC
+func importWhatsApp(t *testing.T, content string, opts importer.Options) (*importer.Report, []*db.Exchange) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "WhatsApp Chat with Alice.txt")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing export: %v", err)
+	}
+	opts.BotName = "Bot"
+	opts.Location = time.UTC
+
+	store := &captureStore{}
+	report, err := importer.Import(context.Background(), store, path, opts)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	return report, store.exchanges
+}
+
+func TestWhatsAppHeaderFormats(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		sentAt  time.Time
+	}{
+		{
+			"android 12-hour",
+			"12/31/23, 9:41 PM - Alice: Hi\n12/31/23, 9:42 PM - Bot: Hello\n",
+			time.Date(2023, 12, 31, 21, 41, 0, 0, time.UTC),
+		},
+		{
+			"android 24-hour",
+			"31.12.23, 21:41 - Alice: Hi\n31.12.23, 21:42 - Bot: Hello\n",
+			time.Date(2023, 12, 31, 21, 41, 0, 0, time.UTC),
+		},
+		{
+			"ios with seconds",
+			"[31.12.2023, 21:41:05] Alice: Hi\n[31.12.2023, 21:42:00] Bot: Hello\n",
+			time.Date(2023, 12, 31, 21, 41, 5, 0, time.UTC),
+		},
+		{
+			"year first",
+			"2023-12-31 21:41 - Alice: Hi\n2023-12-31 21:42 - Bot: Hello\n",
+			time.Date(2023, 12, 31, 21, 41, 0, 0, time.UTC),
+		},
+		{
+			"direction marks",
+			"\u200e[31.12.2023, 21:41:05] Alice: Hi\n\u200e[31.12.2023, 21:42:00] Bot: Hello\n",
+			time.Date(2023, 12, 31, 21, 41, 5, 0, time.UTC),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			report, exchanges := importWhatsApp(t, c.content, importer.Options{})
+			if len(report.Errors) != 0 || len(report.Warnings) != 0 {
+				t.Errorf("errors %v, warnings %v, want none", report.Errors, report.Warnings)
+			}
+			if len(exchanges) != 1 {
+				t.Fatalf("got %d exchanges, want 1", len(exchanges))
+			}
+			ex := exchanges[0]
+			if !ex.Inbound.SentAt.Equal(c.sentAt) {
+				t.Errorf("sent at %v, want %v", ex.Inbound.SentAt, c.sentAt)
+			}
+			if ex.Sender.DisplayName != "Alice" || ex.Interaction.Prompt != "Hi" || ex.Interaction.Response != "Hello" {
+				t.Errorf("exchange = %q by %q answered %q, want Hi by Alice answered Hello",
+					ex.Interaction.Prompt, ex.Sender.DisplayName, ex.Interaction.Response)
+			}
+		})
+	}
+}
+
+func TestWhatsAppDateOrder(t *testing.T) {
+	const ambiguous = "01/02/24, 10:00 - Alice: Hi\n"
+	cases := []struct {
+		name      string
+		content   string
+		order     string
+		sentAt    time.Time
+		ambiguous bool
+	}{
+		{"day first assumed", ambiguous, "", time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC), true},
+		{"configured month first", ambiguous, importer.DateMDY, time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), false},
+		// A later line settles the order for the whole file
+		{"detected from a later day", ambiguous + "01/13/24, 10:00 - Alice: Later\n", "", time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			report, exchanges := importWhatsApp(t, c.content, importer.Options{DateOrder: c.order})
+			if len(exchanges) == 0 {
+				t.Fatal("no exchanges")
+			}
+			if !exchanges[0].Inbound.SentAt.Equal(c.sentAt) {
+				t.Errorf("sent at %v, want %v", exchanges[0].Inbound.SentAt, c.sentAt)
+			}
+			warned := false
+			for _, w := range report.Warnings {
+				warned = warned || strings.Contains(w, "ambiguous")
+			}
+			if warned != c.ambiguous {
+				t.Errorf("warnings = %v, want an ambiguity warning: %t", report.Warnings, c.ambiguous)
+			}
+		})
+	}
+}
+
+func TestWhatsAppTwelveHourClock(t *testing.T) {
+	cases := []struct {
+		clock string
+		hour  int
+	}{
+		{"12:05 AM", 0},
+		{"1:05 AM", 1},
+		{"12:05 PM", 12},
+		{"1:05 PM", 13},
+		{"11:05 pm", 23},
+		{"12:05 a. m.", 0},
+		{"12:05 p.m.", 12},
+	}
+	for _, c := range cases {
+		t.Run(c.clock, func(t *testing.T) {
+			report, exchanges := importWhatsApp(t, "12/31/23, "+c.clock+" - Alice: Hi\n", importer.Options{DateOrder: importer.DateMDY})
+			if len(report.Errors) != 0 || len(exchanges) != 1 {
+				t.Fatalf("errors %v, %d exchanges, want 1 exchange", report.Errors, len(exchanges))
+			}
+			if at := exchanges[0].Inbound.SentAt; at.Hour() != c.hour || at.Minute() != 5 {
+				t.Errorf("sent at %v, want %02d:05", at, c.hour)
+			}
+		})
+	}
+
+	// Hours a 12-hour clock does not show are errors, not messages
+	report, exchanges := importWhatsApp(t, "12/31/23, 13:05 PM - Alice: Hi\n12/31/23, 0:05 AM - Alice: Hi\n", importer.Options{DateOrder: importer.DateMDY})
+	if len(report.Errors) != 2 || len(exchanges) != 0 {
+		t.Errorf("errors %v, %d exchanges, want 2 errors and no exchanges", report.Errors, len(exchanges))
+	}
+}
+
+func TestWhatsAppMessages(t *testing.T) {
+	content := strings.Join([]string{
+		"31.12.23, 21:40 - Messages and calls are end-to-end encrypted. No one outside of this chat can read them.",
+		"31.12.23, 21:41 - Alice: My order",
+		"has not arrived",
+		"",
+		"31.12.23, 21:41 - Alice: <Media omitted>",
+		"31.12.23, 21:41 - Alice: image omitted",
+		"31.12.23, 21:41 - Alice: <attached: 00000012-PHOTO-2023-12-31.jpg>",
+		"31.12.23, 21:41 - Alice: This message was deleted",
+		"31.12.23, 21:42 - Alice: Where is it?",
+		"31.12.23, 21:43 - Bot: It is on its way",
+		"31.12.23, 21:44 - Bot: Tracking: 1234",
+		"31.12.23, 21:45 - Alice: Thanks",
+	}, "\n")
+
+	report, exchanges := importWhatsApp(t, content, importer.Options{})
+	if report.Skipped != 5 {
+		t.Errorf("skipped %d lines, want the system notice and 4 placeholders", report.Skipped)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("errors = %v, want none", report.Errors)
+	}
+	if len(exchanges) != 2 {
+		t.Fatalf("got %d exchanges, want 2", len(exchanges))
+	}
+
+	// Continuation lines belong to their message, and consecutive messages
+	// of a sender are one prompt
+	first := exchanges[0]
+	if want := "My order\nhas not arrived\nWhere is it?"; first.Interaction.Prompt != want {
+		t.Errorf("prompt = %q, want %q", first.Interaction.Prompt, want)
+	}
+	if want := "It is on its way\nTracking: 1234"; first.Interaction.Response != want {
+		t.Errorf("response = %q, want %q", first.Interaction.Response, want)
+	}
+	if first.Interaction.LatencyMS != (2 * time.Minute).Milliseconds() {
+		t.Errorf("latency = %d ms, want the time from the first prompt line to the reply", first.Interaction.LatencyMS)
+	}
+
+	// The last message was never answered
+	last := exchanges[1]
+	if last.Inbound.Content != "Thanks" || last.Outbound.Content != "" || last.Interaction.Prompt != "" {
+		t.Errorf("last exchange = %+v, want only the inbound message", last)
+	}
+	if first.Inbound.ExternalID == "" || first.Inbound.ExternalID == last.Inbound.ExternalID {
+		t.Errorf("message IDs %q and %q, want distinct IDs", first.Inbound.ExternalID, last.Inbound.ExternalID)
+	}
+	// Without a JID for Alice the chat is named after the file
+	if first.Chat.ExternalID != "import:WhatsApp Chat with Alice" || first.Chat.IsGroup || first.Chat.Title != "Alice" {
+		t.Errorf("chat = %+v, want the private chat with Alice", first.Chat)
+	}
+}
@@ -11,9 +11,24 @@ import (
 	"github.com/go-resty/resty/v2"
 )
 
+// ProviderGroq identifies the Groq API in stored interactions
+const ProviderGroq = "groq"
+
 // Client defines the interface for LLM interactions
 type Client interface {
 	SendMessage(ctx context.Context, prompt string) (string, error)
+	Complete(ctx context.Context, prompt string) (*Completion, error)
+	CompleteRequest(ctx context.Context, req Request) (*Completion, error)
+}
+
+// Request describes a completion with optional per-request overrides.
+// Empty fields fall back to the client configuration.
+type Request struct {
+	Prompt       string
+	SystemPrompt string
+	Instructions string // appended to the system prompt, e.g. the reply language
+	Model        string
+	Params       *Params
 }
 
 // GroqClient implements the LLM Client interface for Groq's API
@@ -28,13 +43,50 @@ type Message struct {
 	Content string `json:"content"`
 }
 
+// Usage reports the tokens consumed by a request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Pricing holds model prices in USD per million tokens
+type Pricing struct {
+	PromptPerMTok     float64
+	CompletionPerMTok float64
+}
+
+// Cost returns the price in USD of the given token usage
+func (p Pricing) Cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.PromptPerMTok + float64(u.CompletionTokens)*p.CompletionPerMTok) / 1e6
+}
+
+// Params holds the sampling parameters of a request
+type Params struct {
+	Temperature float64
+	TopP        float64
+	MaxTokens   int // 0 leaves the limit to the provider
+}
+
+// Completion is an LLM response together with the settings that produced it
+type Completion struct {
+	Content      string
+	Model        string
+	Provider     string
+	SystemPrompt string
+	Params       Params
+	Usage        Usage
+}
+
 // ChatResponse represents the API response structure
 type ChatResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage Usage `json:"usage"`
 }
 
 // NewGroqClient creates a new Groq API client with retry middleware
@@ -57,33 +109,86 @@ func NewGroqClient(cfg *config.Config) *GroqClient {
 
 // SendMessage sends a message to the Groq API and returns the response
 func (c *GroqClient) SendMessage(ctx context.Context, prompt string) (string, error) {
+	completion, err := c.Complete(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return completion.Content, nil
+}
+
+// Complete sends a message to the Groq API and returns the response with token usage
+func (c *GroqClient) Complete(ctx context.Context, prompt string) (*Completion, error) {
+	return c.CompleteRequest(ctx, Request{Prompt: prompt})
+}
+
+// CompleteRequest sends a request to the Groq API, applying its overrides to the client configuration
+func (c *GroqClient) CompleteRequest(ctx context.Context, req Request) (*Completion, error) {
+	systemPrompt := c.config.SystemPrompt
+	if req.SystemPrompt != "" {
+		systemPrompt = req.SystemPrompt
+	}
+	if req.Instructions != "" {
+		systemPrompt += "\n\n" + req.Instructions
+	}
+	modelName := c.config.ModelName
+	if req.Model != "" {
+		modelName = req.Model
+	}
+	params := Params{
+		Temperature: c.config.Temperature,
+		TopP:        c.config.TopP,
+		MaxTokens:   c.config.MaxTokens,
+	}
+	if req.Params != nil {
+		params = *req.Params
+	}
+
 	messages := []Message{
-		{Role: "system", Content: c.config.SystemPrompt},
-		{Role: "user", Content: prompt},
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: req.Prompt},
+	}
+
+	body := map[string]interface{}{
+		"messages":    messages,
+		"model":       modelName,
+		"temperature": params.Temperature,
+		"top_p":       params.TopP,
+	}
+	if params.MaxTokens > 0 {
+		body["max_tokens"] = params.MaxTokens
 	}
 
 	var result ChatResponse
 	resp, err := c.client.R().
 		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
-		SetBody(map[string]interface{}{
-			"messages": messages,
-			"model":    c.config.ModelName,
-		}).
+		SetBody(body).
 		SetResult(&result).
 		Post("https://api.groq.com/openai/v1/chat/completions")
 
 	if err != nil {
-		return "", fmt.Errorf("failed to send message to Groq: %w", err)
+		return nil, fmt.Errorf("failed to send message to Groq: %w", err)
 	}
 
 	if !resp.IsSuccess() {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode(), resp.String())
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode(), resp.String())
 	}
 
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned from API")
+		return nil, fmt.Errorf("no response choices returned from API")
+	}
+
+	model := result.Model
+	if model == "" {
+		model = modelName
 	}
 
-	return result.Choices[0].Message.Content, nil
+	return &Completion{
+		Content:      result.Choices[0].Message.Content,
+		Model:        model,
+		Provider:     ProviderGroq,
+		SystemPrompt: systemPrompt,
+		Params:       params,
+		Usage:        result.Usage,
+	}, nil
 }
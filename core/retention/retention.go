@@ -0,0 +1,403 @@
+// Package retention prunes stored interactions according to configurable retention rules
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// Rules holds the default retention policy and per-channel overrides
+type Rules struct {
+	Default  db.RetentionPolicy
+	Channels map[string]db.RetentionPolicy
+}
+
+// For returns the policy that applies to a channel
+func (r Rules) For(channel string) db.RetentionPolicy {
+	if p, ok := r.Channels[channel]; ok {
+		return p
+	}
+	return r.Default
+}
+
+// Enabled reports whether any rule removes data
+func (r Rules) Enabled() bool {
+	if r.Default.Enabled() {
+		return true
+	}
+	for _, p := range r.Channels {
+		if p.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// RulesFromConfig builds the rules from the RETENTION_* settings. Overrides
+// are written as channel:max_age:max_per_chat; an empty field inherits the
+// default and 0 removes the limit, e.g. "cli:24h:,whatsapp::500".
+func RulesFromConfig(cfg *config.Config) (Rules, error) {
+	rules := Rules{
+		Default:  db.RetentionPolicy{MaxAge: cfg.RetentionMaxAge, MaxPerChat: cfg.RetentionMaxPerChat},
+		Channels: make(map[string]db.RetentionPolicy),
+	}
+
+	for _, entry := range cfg.RetentionChannels {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return Rules{}, fmt.Errorf("retention override %q must be channel:max_age:max_per_chat", entry)
+		}
+
+		policy := rules.Default
+		if parts[1] != "" {
+			age, err := parseAge(parts[1])
+			if err != nil {
+				return Rules{}, fmt.Errorf("retention override %q: invalid max age: %w", entry, err)
+			}
+			policy.MaxAge = age
+		}
+		if parts[2] != "" {
+			n, err := strconv.Atoi(parts[2])
+			if err != nil || n < 0 {
+				return Rules{}, fmt.Errorf("retention override %q: invalid max per chat", entry)
+			}
+			policy.MaxPerChat = n
+		}
+		rules.Channels[parts[0]] = policy
+	}
+	return rules, nil
+}
+
+// parseAge parses a duration, treating a bare 0 as no limit
+func parseAge(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("%s is negative", s)
+	}
+	return d, err
+}
+
+// Store is the storage the janitor prunes
+type Store interface {
+	InteractionChannels(ctx context.Context) ([]string, error)
+	CountExpiredInteractions(ctx context.Context, channel string, policy db.RetentionPolicy, now time.Time) (int, error)
+	ExpiredInteractionIDs(ctx context.Context, channel string, policy db.RetentionPolicy, now time.Time, limit int) ([]int64, error)
+	InteractionsByID(ctx context.Context, ids []int64) ([]db.InteractionRecord, error)
+	DeleteInteractions(ctx context.Context, ids []int64) (int, error)
+	Reclaim(ctx context.Context) error
+	AppendAudit(ctx context.Context, ev db.AuditEvent) (*db.AuditEntry, error)
+}
+
+// Options controls how the janitor prunes
+type Options struct {
+	BatchSize  int    // interactions deleted per transaction
+	ArchiveDir string // archive deleted interactions here as JSONL, empty to only delete
+	DryRun     bool   // only report what would be removed
+
+	// Keys seals archived interactions, nil to archive them in plaintext
+	Keys *encryption.Keyring
+}
+
+// ChannelReport is the outcome of a pass for one channel
+type ChannelReport struct {
+	Channel  string
+	Policy   db.RetentionPolicy
+	Expired  int // interactions violating the policy
+	Archived int
+	Deleted  int
+}
+
+// Report is the outcome of a janitor pass
+type Report struct {
+	DryRun   bool
+	Channels []ChannelReport
+}
+
+// Deleted returns the number of interactions deleted across all channels
+func (r *Report) Deleted() int {
+	total := 0
+	for _, c := range r.Channels {
+		total += c.Deleted
+	}
+	return total
+}
+
+// AuditEvent returns the event recording the interactions deleted by the
+// pass, by channel, in the audit log
+func (r *Report) AuditEvent(actor string) db.AuditEvent {
+	deleted := make(map[string]int)
+	for _, c := range r.Channels {
+		if c.Deleted > 0 {
+			deleted[channelName(c.Channel)] = c.Deleted
+		}
+	}
+	return db.AuditEvent{Actor: actor, Action: db.AuditRetentionPrune, Target: "interactions", After: deleted}
+}
+
+// Janitor periodically removes interactions that violate the retention rules
+type Janitor struct {
+	store Store
+	rules Rules
+	opts  Options
+	now   func() time.Time
+}
+
+// NewJanitor creates a janitor for the given store and rules
+func NewJanitor(store Store, rules Rules, opts Options) *Janitor {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 500
+	}
+	return &Janitor{store: store, rules: rules, opts: opts, now: time.Now}
+}
+
+// FromConfig creates a janitor from the RETENTION_* settings, or returns nil
+// when no rule removes data or RETENTION_INTERVAL is not positive. The archive
+// is sealed with the encryption keys the store uses.
+func FromConfig(cfg *config.Config, store Store) (*Janitor, error) {
+	if cfg.RetentionInterval <= 0 {
+		return nil, nil
+	}
+	rules, err := RulesFromConfig(cfg)
+	if err != nil || !rules.Enabled() {
+		return nil, err
+	}
+	keys, err := encryption.FromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading encryption keys: %w", err)
+	}
+	return NewJanitor(store, rules, Options{
+		BatchSize:  cfg.RetentionBatchSize,
+		ArchiveDir: cfg.RetentionArchiveDir,
+		DryRun:     cfg.RetentionDryRun,
+		Keys:       keys,
+	}), nil
+}
+
+// Run prunes once immediately and then at every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := j.Prune(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Retention pass failed: %v", err)
+		}
+		if report != nil {
+			logReport(report)
+			if report.Deleted() > 0 {
+				if _, err := j.store.AppendAudit(context.WithoutCancel(ctx), report.AuditEvent(db.ActorRetention)); err != nil {
+					log.Printf("Failed to record the retention pass in the audit log: %v", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune applies the rules to every channel with stored interactions. In dry
+// run mode nothing is changed and the report lists what would be removed.
+func (j *Janitor) Prune(ctx context.Context) (*Report, error) {
+	report := &Report{DryRun: j.opts.DryRun}
+
+	channels, err := j.store.InteractionChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := j.now()
+
+	for _, channel := range channels {
+		policy := j.rules.For(channel)
+		if !policy.Enabled() {
+			continue
+		}
+
+		cr := ChannelReport{Channel: channel, Policy: policy}
+		if cr.Expired, err = j.store.CountExpiredInteractions(ctx, channel, policy, now); err != nil {
+			return report, err
+		}
+		if !j.opts.DryRun {
+			err = j.pruneChannel(ctx, &cr, now)
+		}
+		report.Channels = append(report.Channels, cr)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if report.Deleted() > 0 {
+		if err := j.store.Reclaim(ctx); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// pruneChannel deletes the expired interactions of a channel in batches
+func (j *Janitor) pruneChannel(ctx context.Context, cr *ChannelReport, now time.Time) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ids, err := j.store.ExpiredInteractionIDs(ctx, cr.Channel, cr.Policy, now, j.opts.BatchSize)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		if j.opts.ArchiveDir != "" {
+			archived, err := j.archive(ctx, ids, now)
+			if err != nil {
+				return err
+			}
+			cr.Archived += archived
+		}
+
+		deleted, err := j.store.DeleteInteractions(ctx, ids)
+		if err != nil {
+			return err
+		}
+		cr.Deleted += deleted
+	}
+}
+
+// archivedInteraction is the JSONL shape of an archived interaction
+type archivedInteraction struct {
+	ID               int64     `json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	Channel          string    `json:"channel,omitempty"`
+	ChatID           string    `json:"chat_id,omitempty"`
+	SenderID         string    `json:"sender_id,omitempty"`
+	Prompt           string    `json:"prompt"`
+	Response         string    `json:"response"`
+	PromptVersion    string    `json:"prompt_version,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	Provider         string    `json:"provider,omitempty"`
+	Experiment       string    `json:"experiment,omitempty"`
+	Variant          string    `json:"variant,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+}
+
+// ArchiveLabel binds sealed archive records to the retention archive
+const ArchiveLabel = "retention.archive"
+
+// sealedInteraction is the JSONL shape of an archived interaction written with
+// encryption keys. Only the ID and time are left readable; the rest is the
+// archivedInteraction as sealed JSON.
+type sealedInteraction struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	KeyID     string    `json:"key_id"`
+	Sealed    string    `json:"sealed"`
+}
+
+// seal returns the record to write, encrypted when keys are set
+func (a archivedInteraction) seal(keys *encryption.Keyring) (any, error) {
+	if keys == nil {
+		return a, nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := keys.Seal(ArchiveLabel, string(data))
+	if err != nil {
+		return nil, err
+	}
+	return sealedInteraction{ID: a.ID, CreatedAt: a.CreatedAt, KeyID: keys.ActiveKeyID(), Sealed: sealed}, nil
+}
+
+// archive appends the interactions to the day's archive file before they are
+// deleted, sealed when the janitor has encryption keys
+func (j *Janitor) archive(ctx context.Context, ids []int64, now time.Time) (int, error) {
+	records, err := j.store.InteractionsByID(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(j.opts.ArchiveDir, 0o700); err != nil {
+		return 0, fmt.Errorf("creating archive directory: %w", err)
+	}
+	path := filepath.Join(j.opts.ArchiveDir, "interactions-"+now.UTC().Format("2006-01-02")+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("opening archive: %w", err)
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, rec := range records {
+		record, err := archivedInteraction{
+			ID:               rec.ID,
+			CreatedAt:        rec.CreatedAt.UTC(),
+			Channel:          rec.Channel,
+			ChatID:           rec.ChatID,
+			SenderID:         rec.SenderID,
+			Prompt:           rec.Prompt,
+			Response:         rec.Response,
+			PromptVersion:    rec.PromptVersion,
+			Model:            rec.Model,
+			Provider:         rec.Provider,
+			Experiment:       rec.Experiment,
+			Variant:          rec.Variant,
+			PromptTokens:     rec.PromptTokens,
+			CompletionTokens: rec.CompletionTokens,
+		}.seal(j.opts.Keys)
+		if err != nil {
+			return 0, fmt.Errorf("encrypting archive record: %w", err)
+		}
+		if err := enc.Encode(record); err != nil {
+			return 0, fmt.Errorf("writing archive: %w", err)
+		}
+	}
+
+	// Make sure the archive is durable before the rows are gone
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("syncing archive: %w", err)
+	}
+	return len(records), nil
+}
+
+// logReport logs the channels that had expired interactions
+func logReport(report *Report) {
+	sort.Slice(report.Channels, func(a, b int) bool { return report.Channels[a].Channel < report.Channels[b].Channel })
+	for _, c := range report.Channels {
+		if c.Expired == 0 {
+			continue
+		}
+		if report.DryRun {
+			log.Printf("Retention dry run: would remove %d interactions from %s", c.Expired, channelName(c.Channel))
+		} else {
+			log.Printf("Retention: removed %d interactions from %s (%d archived)", c.Deleted, channelName(c.Channel), c.Archived)
+		}
+	}
+}
+
+// channelName shows interactions without a channel as (none)
+func channelName(channel string) string {
+	if channel == "" {
+		return "(none)"
+	}
+	return channel
+}
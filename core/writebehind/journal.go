@@ -0,0 +1,218 @@
+// Package writebehind records exchanges asynchronously in batched transactions
+package writebehind
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+)
+
+// journalLabel binds sealed journal entries to the journal
+const journalLabel = "writebehind.journal"
+
+// journalEntry is one exchange waiting in the journal. With encryption keys
+// the exchange is written sealed, so the file holds no content in plaintext.
+type journalEntry struct {
+	Attempts int          `json:"attempts"` // failed replays so far
+	Exchange *db.Exchange `json:"exchange,omitempty"`
+	KeyID    string       `json:"key_id,omitempty"` // key the sealed exchange is encrypted with
+	Sealed   string       `json:"sealed,omitempty"` // the exchange as sealed JSON
+}
+
+// seal returns the entry to write, with the exchange encrypted when keys are set
+func (e journalEntry) seal(keys *encryption.Keyring) (journalEntry, error) {
+	if keys == nil {
+		return e, nil
+	}
+	data, err := json.Marshal(e.Exchange)
+	if err != nil {
+		return e, err
+	}
+	sealed, err := keys.Seal(journalLabel, string(data))
+	if err != nil {
+		return e, err
+	}
+	return journalEntry{Attempts: e.Attempts, KeyID: keys.ActiveKeyID(), Sealed: sealed}, nil
+}
+
+// open decrypts the exchange of a sealed entry in place
+func (e *journalEntry) open(keys *encryption.Keyring) error {
+	if e.Sealed == "" {
+		return nil
+	}
+	if keys == nil {
+		return fmt.Errorf("journal entries are encrypted with key %s: %w", e.KeyID, db.ErrNoKeys)
+	}
+	data, err := keys.Open(e.KeyID, journalLabel, e.Sealed)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(data), &e.Exchange); err != nil {
+		return err
+	}
+	e.KeyID, e.Sealed = "", ""
+	return nil
+}
+
+// journal is an append-only JSONL file holding exchanges that could not be
+// written to the database. A replay moves the file aside, so exchanges can be
+// spilled while older ones are being replayed.
+type journal struct {
+	mu      sync.Mutex
+	path    string
+	keys    *encryption.Keyring // seals new entries, nil to write them in plaintext
+	pending int                 // entries in the journal and the replay file
+}
+
+// openJournal opens the journal at path, counting the entries left by a
+// previous run
+func openJournal(path string, keys *encryption.Keyring) (*journal, error) {
+	j := &journal{path: path, keys: keys}
+	for _, p := range []string{path, j.replayPath()} {
+		entries, err := readJournal(p, keys)
+		if err != nil {
+			return nil, err
+		}
+		j.pending += len(entries)
+	}
+	return j, nil
+}
+
+// replayPath is where the journal is moved while it is replayed
+func (j *journal) replayPath() string {
+	return j.path + ".replay"
+}
+
+// size returns the number of entries waiting to be replayed
+func (j *journal) size() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pending
+}
+
+// append writes entries to the end of the journal and syncs it to disk
+func (j *journal) append(entries []journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := appendJournal(j.path, j.keys, entries); err != nil {
+		return err
+	}
+	j.pending += len(entries)
+	return nil
+}
+
+// take returns the entries to replay. A replay file left by an interrupted
+// replay is taken first; otherwise the journal is moved aside.
+func (j *journal) take() ([]journalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := os.Stat(j.replayPath()); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(j.path, j.replayPath()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("moving journal aside: %w", err)
+		}
+	}
+	return readJournal(j.replayPath(), j.keys)
+}
+
+// finish ends a replay of taken entries by writing the ones that could not be
+// replayed back to the journal
+func (j *journal) finish(taken int, remaining []journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := appendJournal(j.path, j.keys, remaining); err != nil {
+		return err
+	}
+	if err := os.Remove(j.replayPath()); err != nil {
+		return fmt.Errorf("removing replayed journal: %w", err)
+	}
+	j.pending += len(remaining) - taken
+	return nil
+}
+
+// readJournal reads the entries of a journal file, skipping lines that cannot
+// be decoded. A missing file has no entries. Sealed entries cannot be read
+// without keys, which is an error rather than a reason to drop them.
+func readJournal(path string, keys *encryption.Keyring) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Skipping unreadable entry on line %d of %s", line, path)
+			continue
+		}
+		if err := entry.open(keys); err != nil {
+			if errors.Is(err, db.ErrNoKeys) {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+			log.Printf("Skipping entry on line %d of %s that cannot be decrypted: %v", line, path, err)
+			continue
+		}
+		if entry.Exchange == nil {
+			log.Printf("Skipping unreadable entry on line %d of %s", line, path)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	return entries, nil
+}
+
+// appendJournal appends entries to a journal file, sealed when keys are set,
+// and syncs it
+func appendJournal(path string, keys *encryption.Keyring, entries []journalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		sealed, err := entry.seal(keys)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("encrypting journal entry: %w", err)
+		}
+		if err := enc.Encode(sealed); err != nil {
+			file.Close()
+			return fmt.Errorf("writing journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,497 @@
+// Package writebehind records exchanges asynchronously in batched transactions
+package writebehind
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang-llm-sqlite-bot/core/config"
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+	"golang-llm-sqlite-bot/core/retention"
+)
+
+// Store is the storage the writer records exchanges in
+type Store interface {
+	RecordExchanges(ctx context.Context, exchanges []*db.Exchange) error
+	ListErasures(ctx context.Context, subject string) ([]db.Erasure, error)
+}
+
+// Options controls how the writer buffers and batches exchanges
+type Options struct {
+	QueueSize     int           // exchanges buffered in memory before spilling to the journal
+	BatchSize     int           // exchanges written per transaction
+	FlushInterval time.Duration // longest time an exchange waits in memory
+	WriteTimeout  time.Duration // time limit for writing one batch
+	JournalPath   string        // spill file for exchanges that could not be written, empty to drop them
+	MaxAttempts   int           // failed replays after which a journaled exchange is dropped
+
+	// Keys seals the exchanges written to the journal, nil to write them in plaintext
+	Keys *encryption.Keyring
+	// Retention drops journaled exchanges older than the max age of their channel
+	Retention retention.Rules
+}
+
+// Stats is a point-in-time snapshot of the writer metrics
+type Stats struct {
+	Queued        int        `json:"queued"`
+	Capacity      int        `json:"capacity"`
+	Written       uint64     `json:"written"`
+	Batches       uint64     `json:"batches"`
+	FailedBatches uint64     `json:"failed_batches"`
+	Spilled       uint64     `json:"spilled"`   // exchanges written to the journal
+	Journaled     int        `json:"journaled"` // exchanges waiting in the journal
+	Replayed      uint64     `json:"replayed"`  // exchanges written from the journal
+	Dropped       uint64     `json:"dropped"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Writer records exchanges in the background. Exchanges are buffered in
+// memory and written in batches, one transaction per batch, once a batch is
+// full, the flush interval passes or the writer shuts down. Batches that
+// cannot be written are spilled to an on-disk journal and replayed later.
+type Writer struct {
+	store   Store
+	opts    Options
+	queue   chan *db.Exchange
+	journal *journal // nil when failed writes are dropped
+
+	flushed bool // a flush succeeded since the last replay, only used by run
+
+	closeMu sync.RWMutex // held for writing while closing, so no exchange is queued after the final drain
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+
+	flushes chan chan struct{} // Flush requests, closed by run once served
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewWriter creates a writer for the given store, opening the journal if one is configured
+func NewWriter(store Store, opts Options) (*Writer, error) {
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 1000
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 50
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 30 * time.Second
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 10
+	}
+
+	w := &Writer{
+		store:   store,
+		opts:    opts,
+		queue:   make(chan *db.Exchange, opts.QueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		flushes: make(chan chan struct{}),
+	}
+	if opts.JournalPath != "" {
+		j, err := openJournal(opts.JournalPath, opts.Keys)
+		if err != nil {
+			return nil, err
+		}
+		w.journal = j
+	}
+	return w, nil
+}
+
+// FromConfig creates a writer from the WRITE_* settings, or returns nil when
+// exchanges are recorded synchronously. The journal is sealed with the
+// encryption keys and pruned by the retention rules the store uses.
+func FromConfig(cfg *config.Config, store Store) (*Writer, error) {
+	if cfg.WriteQueueSize <= 0 {
+		return nil, nil
+	}
+	keys, err := encryption.FromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading encryption keys: %w", err)
+	}
+	rules, err := retention.RulesFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(store, Options{
+		QueueSize:     cfg.WriteQueueSize,
+		BatchSize:     cfg.WriteBatchSize,
+		FlushInterval: cfg.WriteFlushInterval,
+		JournalPath:   cfg.WriteJournalPath,
+		Keys:          keys,
+		Retention:     rules,
+	})
+}
+
+// Start launches the background goroutine. Exchanges left in the journal by
+// a previous run are replayed first.
+func (w *Writer) Start() {
+	go w.run()
+	log.Printf("Write-behind started (queue %d, batch %d, flush every %v)",
+		w.opts.QueueSize, w.opts.BatchSize, w.opts.FlushInterval)
+}
+
+// Enqueue schedules an exchange to be recorded. It never blocks on the
+// database: when the queue is full or the writer has shut down, the exchange
+// goes straight to the journal.
+func (w *Writer) Enqueue(ex *db.Exchange) {
+	// Replayed exchanges must keep the time they happened, not the time they are written
+	if ex.Interaction.CreatedAt.IsZero() {
+		ex.Interaction.CreatedAt = time.Now()
+	}
+
+	w.closeMu.RLock()
+	closed := w.closed
+	if !closed {
+		select {
+		case w.queue <- ex:
+			w.closeMu.RUnlock()
+			return
+		default:
+		}
+	}
+	w.closeMu.RUnlock()
+
+	reason := "write queue is full"
+	if closed {
+		reason = "writer has shut down"
+	}
+	w.spill([]*db.Exchange{ex}, reason)
+}
+
+// Shutdown stops accepting exchanges, writes everything still queued and
+// waits for the writer to finish or ctx to expire
+func (w *Writer) Shutdown(ctx context.Context) error {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.closeMu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush writes the exchanges queued so far and waits until they are stored
+// or spilled to the journal. It returns at once when the writer has stopped.
+func (w *Writer) Flush(ctx context.Context) error {
+	served := make(chan struct{})
+	select {
+	case w.flushes <- served:
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-served:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the writer metrics
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	stats := w.stats
+	w.mu.Unlock()
+
+	stats.Queued = len(w.queue)
+	stats.Capacity = cap(w.queue)
+	if w.journal != nil {
+		stats.Journaled = w.journal.size()
+	}
+	return stats
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	w.replay()
+
+	batch := make([]*db.Exchange, 0, w.opts.BatchSize)
+	for {
+		select {
+		case ex := <-w.queue:
+			batch = append(batch, ex)
+			if len(batch) >= w.opts.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+			w.replay()
+
+		case served := <-w.flushes:
+			w.drain(batch)
+			batch = batch[:0]
+			close(served)
+
+		case <-w.stop:
+			// Nothing is queued after stop is closed, so the drain is final
+			w.drain(batch)
+			return
+		}
+	}
+}
+
+// drain writes the batch together with everything waiting in the queue
+func (w *Writer) drain(batch []*db.Exchange) {
+	for {
+		select {
+		case ex := <-w.queue:
+			batch = append(batch, ex)
+			if len(batch) >= w.opts.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		default:
+			if len(batch) > 0 {
+				w.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush writes a batch in one transaction, spilling it to the journal on failure
+func (w *Writer) flush(batch []*db.Exchange) {
+	if err := w.write(batch); err != nil {
+		log.Printf("Failed to record %d exchanges: %v", len(batch), err)
+		w.spill(batch, "batch failed")
+		return
+	}
+	w.flushed = true
+	w.mu.Lock()
+	w.stats.Written += uint64(len(batch))
+	w.mu.Unlock()
+}
+
+// write records a batch and updates the batch metrics
+func (w *Writer) write(batch []*db.Exchange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.WriteTimeout)
+	defer cancel()
+	err := w.store.RecordExchanges(ctx, batch)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Batches++
+	if err != nil {
+		w.stats.FailedBatches++
+		w.stats.LastError = err.Error()
+		now := time.Now()
+		w.stats.LastErrorAt = &now
+	}
+	return err
+}
+
+// spill appends exchanges to the journal, or drops them when there is no
+// journal or it cannot be written
+func (w *Writer) spill(exchanges []*db.Exchange, reason string) {
+	if w.journal != nil {
+		entries := make([]journalEntry, len(exchanges))
+		for i, ex := range exchanges {
+			entries[i] = journalEntry{Exchange: ex}
+		}
+		err := w.journal.append(entries)
+		if err == nil {
+			w.mu.Lock()
+			w.stats.Spilled += uint64(len(exchanges))
+			w.mu.Unlock()
+			return
+		}
+		log.Printf("Failed to spill %d exchanges to the journal: %v", len(exchanges), err)
+	}
+
+	log.Printf("Dropping %d exchanges: %s", len(exchanges), reason)
+	w.mu.Lock()
+	w.stats.Dropped += uint64(len(exchanges))
+	w.mu.Unlock()
+}
+
+// replay writes the journaled exchanges in batches. When a batch fails its
+// exchanges are retried one by one so a single bad exchange cannot hold back
+// the rest. Failures only count towards MaxAttempts while the database is
+// known to be reachable; otherwise the replay stops and is retried later.
+func (w *Writer) replay() {
+	reachable := w.flushed // a flush succeeded since the last replay
+	w.flushed = false
+	if w.journal == nil || w.journal.size() == 0 {
+		return
+	}
+	taken, err := w.journal.take()
+	if err != nil {
+		log.Printf("Failed to read the write journal: %v", err)
+		return
+	}
+	entries, discarded, err := w.discard(taken)
+	if err != nil {
+		log.Printf("Failed to check the write journal for erased senders: %v", err)
+		if err := w.journal.finish(len(taken), taken); err != nil {
+			log.Printf("Failed to update the write journal: %v", err)
+		}
+		return
+	}
+	if discarded > 0 {
+		log.Printf("Discarding %d journaled exchanges of erased senders or past retention", discarded)
+	}
+
+	var remaining []journalEntry
+	replayed := 0
+replay:
+	for start := 0; start < len(entries); start += w.opts.BatchSize {
+		chunk := entries[start:min(start+w.opts.BatchSize, len(entries))]
+
+		batch := make([]*db.Exchange, len(chunk))
+		for i, entry := range chunk {
+			batch[i] = entry.Exchange
+		}
+		if err := w.write(batch); err == nil {
+			replayed += len(chunk)
+			reachable = true
+			continue
+		}
+
+		for i, entry := range chunk {
+			if err := w.write([]*db.Exchange{entry.Exchange}); err == nil {
+				replayed++
+				reachable = true
+				continue
+			}
+			if !reachable {
+				// Nothing could be written, the database is likely unavailable
+				remaining = append(remaining, entries[start+i:]...)
+				break replay
+			}
+			entry.Attempts++
+			remaining = append(remaining, entry)
+		}
+	}
+
+	// Keep entries that failed too often out of the journal
+	kept := remaining[:0]
+	dropped := 0
+	for _, entry := range remaining {
+		if entry.Attempts >= w.opts.MaxAttempts {
+			dropped++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if dropped > 0 {
+		log.Printf("Dropping %d journaled exchanges after %d failed replays", dropped, w.opts.MaxAttempts)
+	}
+
+	if err := w.journal.finish(len(taken), kept); err != nil {
+		log.Printf("Failed to update the write journal: %v", err)
+	}
+
+	w.mu.Lock()
+	w.stats.Written += uint64(replayed)
+	w.stats.Replayed += uint64(replayed)
+	w.stats.Dropped += uint64(dropped + discarded)
+	w.mu.Unlock()
+	if replayed > 0 {
+		log.Printf("Replayed %d exchanges from the write journal", replayed)
+	}
+}
+
+// discard removes journaled exchanges that must not reach the database
+// anymore: those of senders and private chats erased after the exchange
+// happened, and those older than the retention max age of their channel.
+// Every replay rewrites the journal, so an erasure or retention pass also
+// clears the journal of a running writer at its next flush interval.
+func (w *Writer) discard(entries []journalEntry) ([]journalEntry, int, error) {
+	if len(entries) == 0 {
+		return entries, 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.WriteTimeout)
+	defer cancel()
+	// Latest erasure of every sender and private chat in the entries, by ID
+	erasedAt := make(map[string]time.Time)
+	for _, entry := range entries {
+		for _, subject := range subjects(entry.Exchange) {
+			if _, ok := erasedAt[subject]; ok {
+				continue
+			}
+			erasures, err := w.store.ListErasures(ctx, subject)
+			if err != nil {
+				return nil, 0, err
+			}
+			var latest time.Time
+			for _, e := range erasures {
+				if e.ErasedAt.After(latest) {
+					latest = e.ErasedAt
+				}
+			}
+			erasedAt[subject] = latest
+		}
+	}
+
+	now := time.Now()
+	kept := entries[:0]
+	for _, entry := range entries {
+		if erased(entry.Exchange, erasedAt) || expired(entry.Exchange, w.opts.Retention, now) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, len(entries) - len(kept), nil
+}
+
+// subjects lists the IDs an exchange can be erased under: its sender and,
+// for private chats, the chat
+func subjects(ex *db.Exchange) []string {
+	var ids []string
+	for _, id := range []string{ex.Sender.ExternalID, ex.Interaction.SenderID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if !ex.Chat.IsGroup && ex.Chat.ExternalID != "" {
+		ids = append(ids, ex.Chat.ExternalID)
+	}
+	return ids
+}
+
+// erased reports whether the sender or private chat of an exchange was
+// erased after the exchange happened
+func erased(ex *db.Exchange, erasedAt map[string]time.Time) bool {
+	for _, subject := range subjects(ex) {
+		if at := erasedAt[subject]; !at.IsZero() && !at.Before(ex.Interaction.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether an exchange is older than the retention max age of its channel
+func expired(ex *db.Exchange, rules retention.Rules, now time.Time) bool {
+	maxAge := rules.For(ex.Chat.Channel).MaxAge
+	return maxAge > 0 && now.Sub(ex.Interaction.CreatedAt) > maxAge
+}
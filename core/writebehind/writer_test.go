@@ -0,0 +1,313 @@
+package writebehind_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-llm-sqlite-bot/core/db"
+	"golang-llm-sqlite-bot/core/encryption"
+	"golang-llm-sqlite-bot/core/retention"
+	"golang-llm-sqlite-bot/core/writebehind"
+)
+
+// flakyStore records exchanges in memory. While it is down every write
+// fails, and a batch with a poisoned exchange always fails as a whole.
+type flakyStore struct {
+	mu       sync.Mutex
+	down     bool
+	written  map[string]int // times each exchange was written, by inbound message ID
+	erasures map[string][]db.Erasure
+}
+
+func newFlakyStore(down bool) *flakyStore {
+	return &flakyStore{down: down, written: make(map[string]int), erasures: make(map[string][]db.Erasure)}
+}
+
+func (s *flakyStore) RecordExchanges(ctx context.Context, exchanges []*db.Exchange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("database is unavailable")
+	}
+	for _, ex := range exchanges {
+		if strings.Contains(ex.Inbound.Content, "poison") {
+			return errors.New("constraint failed")
+		}
+	}
+	for _, ex := range exchanges {
+		s.written[ex.Inbound.ExternalID]++
+	}
+	return nil
+}
+
+func (s *flakyStore) ListErasures(ctx context.Context, subject string) ([]db.Erasure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.erasures[subject], nil
+}
+
+func (s *flakyStore) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+// writes returns how often each exchange was written
+func (s *flakyStore) writes() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writes := make(map[string]int, len(s.written))
+	for id, n := range s.written {
+		writes[id] = n
+	}
+	return writes
+}
+
+// exchange returns an exchange of a sender in their private chat
+func exchange(sender, text string, at time.Time) *db.Exchange {
+	return &db.Exchange{
+		Chat:        db.ChatRef{Channel: db.ChannelWhatsApp, ExternalID: sender},
+		Sender:      db.ParticipantRef{ExternalID: sender},
+		Inbound:     db.MessageRecord{Content: text, ExternalID: "in-" + text, SentAt: at},
+		Outbound:    db.MessageRecord{Content: "reply to " + text, ExternalID: "out-" + text, SentAt: at},
+		Interaction: db.InteractionRecord{Prompt: text, Response: "reply to " + text, CreatedAt: at},
+	}
+}
+
+func testKeys(t *testing.T) *encryption.Keyring {
+	t.Helper()
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keys, err := encryption.NewKeyring([]string{"test:" + key}, "")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keys
+}
+
+func shutdown(t *testing.T, w *writebehind.Writer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+// waitFor polls the writer stats until done reports true
+func waitFor(t *testing.T, w *writebehind.Writer, what string, done func(writebehind.Stats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done(w.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, stats %+v", what, w.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplayAfterOutage(t *testing.T) {
+	store := newFlakyStore(true)
+	w, err := writebehind.NewWriter(store, writebehind.Options{
+		BatchSize:     2,
+		FlushInterval: 10 * time.Millisecond,
+		JournalPath:   filepath.Join(t.TempDir(), "journal.jsonl"),
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		w.Enqueue(exchange("491", fmt.Sprintf("message %d", i), now))
+	}
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if stats := w.Stats(); stats.Spilled != 5 || stats.Written != 0 || stats.FailedBatches == 0 {
+		t.Errorf("stats while down = %+v, want 5 spilled and nothing written", stats)
+	}
+
+	// Replays while nothing can be written do not count against the exchanges
+	time.Sleep(100 * time.Millisecond)
+	if stats := w.Stats(); stats.Dropped != 0 || stats.Journaled != 5 {
+		t.Errorf("stats after replays while down = %+v, want 5 journaled and none dropped", stats)
+	}
+
+	store.setDown(false)
+	waitFor(t, w, "the replay", func(s writebehind.Stats) bool { return s.Replayed == 5 })
+	shutdown(t, w)
+
+	writes := store.writes()
+	if len(writes) != 5 {
+		t.Errorf("wrote %d exchanges, want 5", len(writes))
+	}
+	for id, n := range writes {
+		if n != 1 {
+			t.Errorf("exchange %s was written %d times, want once", id, n)
+		}
+	}
+	if stats := w.Stats(); stats.Journaled != 0 || stats.Written != 5 || stats.Dropped != 0 {
+		t.Errorf("stats after the replay = %+v, want 5 written and an empty journal", stats)
+	}
+}
+
+func TestSealedJournalAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	keys := testKeys(t)
+	opts := writebehind.Options{FlushInterval: time.Hour, JournalPath: path, Keys: keys}
+
+	// Exchanges still queued when the writer stops are spilled when the final write fails
+	store := newFlakyStore(true)
+	w, err := writebehind.NewWriter(store, opts)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	now := time.Now()
+	w.Enqueue(exchange("491", "secret question", now))
+	w.Enqueue(exchange("492", "another secret", now))
+	shutdown(t, w)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading journal: %v", err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Count(string(data), `"key_id":"test"`) != 2 {
+		t.Errorf("journal = %s, want 2 entries sealed with the test key", data)
+	}
+	if _, err := writebehind.NewWriter(store, writebehind.Options{JournalPath: path}); !errors.Is(err, db.ErrNoKeys) {
+		t.Errorf("opening the sealed journal without keys: %v, want ErrNoKeys", err)
+	}
+
+	// The next run replays the journal before anything else
+	store.setDown(false)
+	w, err = writebehind.NewWriter(store, opts)
+	if err != nil {
+		t.Fatalf("NewWriter after restart: %v", err)
+	}
+	if stats := w.Stats(); stats.Journaled != 2 {
+		t.Errorf("journaled after restart = %d, want 2", stats.Journaled)
+	}
+	w.Start()
+	shutdown(t, w)
+
+	writes := store.writes()
+	if len(writes) != 2 || writes["in-secret question"] != 1 || writes["in-another secret"] != 1 {
+		t.Errorf("writes = %v, want each exchange once", writes)
+	}
+	if stats := w.Stats(); stats.Replayed != 2 || stats.Journaled != 0 {
+		t.Errorf("stats = %+v, want 2 replayed and an empty journal", stats)
+	}
+}
+
+func TestReplayDiscardsErasedAndExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	now := time.Now()
+
+	store := newFlakyStore(true)
+	w, err := writebehind.NewWriter(store, writebehind.Options{FlushInterval: time.Hour, JournalPath: path})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	w.Enqueue(exchange("491", "before the erasure", now.Add(-time.Hour)))
+	w.Enqueue(exchange("491", "after the erasure", now))
+	w.Enqueue(exchange("492", "too old", now.Add(-48*time.Hour)))
+	w.Enqueue(exchange("492", "recent", now.Add(-time.Hour)))
+	shutdown(t, w)
+
+	// The sender was erased while the exchanges waited in the journal
+	store.setDown(false)
+	store.erasures["491"] = []db.Erasure{{SubjectHash: "hash", ErasedAt: now.Add(-time.Minute)}}
+	w, err = writebehind.NewWriter(store, writebehind.Options{
+		FlushInterval: time.Hour,
+		JournalPath:   path,
+		Retention:     retention.Rules{Channels: map[string]db.RetentionPolicy{db.ChannelWhatsApp: {MaxAge: 24 * time.Hour}}},
+	})
+	if err != nil {
+		t.Fatalf("NewWriter after restart: %v", err)
+	}
+	w.Start()
+	shutdown(t, w)
+
+	writes := store.writes()
+	if len(writes) != 2 || writes["in-after the erasure"] != 1 || writes["in-recent"] != 1 {
+		t.Errorf("writes = %v, want only the exchange after the erasure and the recent one", writes)
+	}
+	if stats := w.Stats(); stats.Replayed != 2 || stats.Dropped != 2 || stats.Journaled != 0 {
+		t.Errorf("stats = %+v, want 2 replayed, 2 dropped and an empty journal", stats)
+	}
+}
+
+func TestDroppedWrites(t *testing.T) {
+	// Without a journal failed batches are lost
+	store := newFlakyStore(true)
+	w, err := writebehind.NewWriter(store, writebehind.Options{FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		w.Enqueue(exchange("491", fmt.Sprintf("lost %d", i), now))
+	}
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	shutdown(t, w)
+	if stats := w.Stats(); stats.Dropped != 3 || stats.FailedBatches != 1 {
+		t.Errorf("stats without a journal = %+v, want 3 dropped from 1 failed batch", stats)
+	}
+
+	// An exchange the database keeps rejecting is dropped after MaxAttempts
+	// replays, while the others in its batch are written
+	store = newFlakyStore(false)
+	w, err = writebehind.NewWriter(store, writebehind.Options{
+		FlushInterval: 10 * time.Millisecond,
+		JournalPath:   filepath.Join(t.TempDir(), "journal.jsonl"),
+		MaxAttempts:   2,
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	w.Enqueue(exchange("491", "first", now))
+	w.Enqueue(exchange("491", "poison", now))
+	w.Enqueue(exchange("491", "second", now))
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// Failed replays only count while other writes show the database is up
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; w.Stats().Dropped == 0; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("the rejected exchange was not dropped, stats %+v", w.Stats())
+		}
+		w.Enqueue(exchange("492", fmt.Sprintf("filler %d", i), now))
+		if err := w.Flush(context.Background()); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	shutdown(t, w)
+
+	writes := store.writes()
+	if writes["in-first"] != 1 || writes["in-second"] != 1 || writes["in-poison"] != 0 {
+		t.Errorf("writes = %v, want first and second once and not the rejected exchange", writes)
+	}
+	if stats := w.Stats(); stats.Dropped != 1 || stats.Spilled != 3 || stats.Replayed != 2 || stats.Journaled != 0 {
+		t.Errorf("stats = %+v, want 3 spilled, 2 replayed and 1 dropped", stats)
+	}
+}